@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type streamClient struct {
@@ -14,6 +15,9 @@ type streamClient struct {
 	wantedQuality consts.Quality
 	inputChan     chan []byte
 	done          uint32
+	timeShiftID   string
+	// qualityChanged is called when the wanted quality of the client changed, it is nil outside of a broadcast
+	qualityChanged func()
 	sync.Mutex
 }
 
@@ -25,6 +29,11 @@ func (c *streamClient) GetOutputChannel() chan []byte {
 	return c.inputChan
 }
 
+// GetTimeShiftSession returns the session id of a time shift client, it is empty for live clients
+func (c *streamClient) GetTimeShiftSession() string {
+	return c.timeShiftID
+}
+
 func (c *streamClient) SetDone() {
 	atomic.StoreUint32(&c.done, 1)
 }
@@ -33,9 +42,42 @@ func (c *streamClient) IsDone() bool {
 	return atomic.LoadUint32(&c.done) == 1
 }
 
-func (c *streamClient) ChangeWantedQuality(higher bool) error {
+// GetWantedQuality returns the quality the client asked for last
+func (c *streamClient) GetWantedQuality() consts.Quality {
+	c.Lock()
+	defer c.Unlock()
+	return c.wantedQuality
+}
+
+// sentQuality returns the quality the client is sent, if the wanted quality does not exist it falls back to the closest one.
+// It also reports whether the wanted quality was changed to the fallback.
+func (c *streamClient) sentQuality(available map[consts.Quality]bool) (consts.Quality, bool) {
 	c.Lock()
 	defer c.Unlock()
+	if available[c.wantedQuality] {
+		return c.wantedQuality, false
+	}
+
+	c.wantedQuality = fallbackQuality(c.wantedQuality, available)
+	return c.wantedQuality, true
+}
+
+func (c *streamClient) ChangeWantedQuality(higher bool) error {
+	c.Lock()
+	previous := c.wantedQuality
+	err := c.changeWantedQuality(higher)
+	changed := c.wantedQuality != previous
+	qualityChanged := c.qualityChanged
+	c.Unlock()
+
+	// the broadcaster locks the client itself while it counts the viewers
+	if changed && qualityChanged != nil {
+		qualityChanged()
+	}
+	return err
+}
+
+func (c *streamClient) changeWantedQuality(higher bool) error {
 	if higher {
 		c.wantedQuality++
 	} else {
@@ -60,14 +102,27 @@ type streamBroadcaster struct {
 	streamID       string
 	inputStream    consumer.StreamConnection
 	clientStreams  []*streamClient
+	viewerCounts   map[consts.Quality]int
+	available      map[consts.Quality]bool
 	isBroadcasting bool
 	sync.Mutex
 }
 
+// qualityFrame is a frame read from one of the qualities of the input stream
+type qualityFrame struct {
+	quality consts.Quality
+	data    []byte
+}
+
 func (sb *streamBroadcaster) addClient(c *streamClient) {
+	c.Lock()
+	c.qualityChanged = sb.updateViewerCounts
+	c.Unlock()
+
 	sb.Lock()
 	sb.clientStreams = append(sb.clientStreams, c)
 	sb.Unlock()
+	sb.updateViewerCounts()
 }
 
 func (sb *streamBroadcaster) setClientsDone() {
@@ -79,77 +134,239 @@ func (sb *streamBroadcaster) setClientsDone() {
 	}
 }
 
+// updateViewerCounts tells the input stream how many clients want each quality, if it wants to know
+func (sb *streamBroadcaster) updateViewerCounts() {
+	observer, ok := sb.inputStream.(consumer.ViewerObserver)
+	if !ok {
+		return
+	}
+
+	sb.Lock()
+	counts := make(map[consts.Quality]int)
+	for _, client := range sb.clientStreams {
+		if client.IsDone() {
+			continue
+		}
+
+		// count clients at the quality they are sent, not one that does not exist
+		quality := client.GetWantedQuality()
+		if sb.available != nil && !sb.available[quality] {
+			quality = fallbackQuality(quality, sb.available)
+		}
+		counts[quality]++
+	}
+
+	changed := len(counts) != len(sb.viewerCounts)
+	for quality, count := range counts {
+		if sb.viewerCounts[quality] != count {
+			changed = true
+		}
+	}
+	sb.viewerCounts = counts
+	sb.Unlock()
+
+	if changed {
+		observer.SetViewerCounts(counts)
+	}
+}
+
+// fallbackQuality returns the best available quality that is not higher than the wanted one,
+// or the lowest available quality if there is none
+func fallbackQuality(wanted consts.Quality, available map[consts.Quality]bool) consts.Quality {
+	best := consts.Quality(-1)
+	lowest := consts.Quality(-1)
+	for quality := range available {
+		if quality <= wanted && quality > best {
+			best = quality
+		}
+		if lowest < 0 || quality < lowest {
+			lowest = quality
+		}
+	}
+
+	if best >= 0 {
+		return best
+	}
+	return lowest
+}
+
+// qualityRefreshInterval is how often a broadcast looks for qualities that were added to the input stream
+var qualityRefreshInterval = time.Second
+
+// qualityReaders reads each quality of the input stream at its own pace,
+// so a quality without frames does not hold back the others
+type qualityReaders struct {
+	inputStream consumer.StreamConnection
+	frames      chan qualityFrame
+	ended       chan consts.Quality
+	stop        chan struct{}
+	reading     map[consts.Quality]<-chan []byte
+	// closed holds the channel of the reader that ended last for each quality so it is not read again
+	closed map[consts.Quality]<-chan []byte
+}
+
+// refresh starts a reader for every quality of the input stream that is not read yet
+func (qr *qualityReaders) refresh() {
+	for quality := range consts.Qualities {
+		if _, ok := qr.reading[quality]; ok {
+			continue
+		}
+
+		qualityChan, err := qr.inputStream.GetOutputChan(quality)
+		if err != nil || qualityChan == qr.closed[quality] {
+			continue
+		}
+
+		qr.reading[quality] = qualityChan
+		go qr.read(quality, qualityChan)
+	}
+}
+
+func (qr *qualityReaders) read(quality consts.Quality, qualityChan <-chan []byte) {
+	for image := range qualityChan {
+		select {
+		case qr.frames <- qualityFrame{quality, image}:
+		case <-qr.stop:
+			return
+		}
+	}
+
+	select {
+	case qr.ended <- quality:
+	case <-qr.stop:
+	}
+}
+
+// end forgets the reader of the quality and looks for a new channel of it
+func (qr *qualityReaders) end(quality consts.Quality) {
+	qr.closed[quality] = qr.reading[quality]
+	delete(qr.reading, quality)
+	qr.refresh()
+}
+
+func (qr *qualityReaders) available() map[consts.Quality]bool {
+	available := make(map[consts.Quality]bool, len(qr.reading))
+	for quality := range qr.reading {
+		available[quality] = true
+	}
+	return available
+}
+
+func (sb *streamBroadcaster) setAvailable(available map[consts.Quality]bool) {
+	sb.Lock()
+	changed := len(available) != len(sb.available)
+	for quality := range available {
+		if !sb.available[quality] {
+			changed = true
+		}
+	}
+	sb.available = available
+	sb.Unlock()
+
+	if changed {
+		fmt.Println("Broadcast of", sb.streamID, "has qualities", available)
+		sb.updateViewerCounts()
+	}
+}
+
 func (sb *streamBroadcaster) Broadcast() {
 	sb.isBroadcasting = true
+	readers := &qualityReaders{
+		inputStream: sb.inputStream,
+		frames:      make(chan qualityFrame, 8),
+		ended:       make(chan consts.Quality),
+		stop:        make(chan struct{}),
+		reading:     make(map[consts.Quality]<-chan []byte),
+		closed:      make(map[consts.Quality]<-chan []byte),
+	}
 	defer func() {
+		close(readers.stop)
 		sb.isBroadcasting = false
 		for _, client := range sb.clientStreams {
 			client.SetDone()
 		}
 	}()
 
-	for {
-		if !sb.inputStream.IsOpen() {
-			fmt.Println("Input Stream is closed. Stopping Broadcast.")
-			return
-		}
-
-		// Get images of all qualities
-		dataQualityMap := make(map[consts.Quality][]byte)
-		for quality := range consts.Qualities {
-			qualityChan, err := sb.inputStream.GetOutputChan(quality)
-			if err != nil {
-				continue
-			}
+	// If no quality can be read then stop broadcasting
+	readers.refresh()
+	if len(readers.reading) == 0 {
+		return
+	}
+	sb.setAvailable(readers.available())
 
-			image, ok := <-qualityChan
-			if ok {
-				dataQualityMap[quality] = image
+	// Qualities can be added to the input stream or end while it is broadcast
+	refresh := time.NewTicker(qualityRefreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case frame := <-readers.frames:
+			if !sb.inputStream.IsOpen() {
+				fmt.Println("Input Stream is closed. Stopping Broadcast.")
+				return
 			}
+			sb.sendFrame(frame)
+			continue
+		case quality := <-readers.ended:
+			readers.end(quality)
+		case <-refresh.C:
+			readers.refresh()
 		}
 
-		// If no image was retrieved then stop broadcasting
-		if len(dataQualityMap) == 0 {
+		if len(readers.reading) == 0 {
+			fmt.Println("All qualities of", sb.streamID, "ended. Stopping Broadcast.")
 			return
 		}
+		sb.setAvailable(readers.available())
+	}
+}
 
-		sb.Lock()
-		for index := len(sb.clientStreams) - 1; index >= 0; index-- {
-			streamClient := sb.clientStreams[index]
-			if streamClient.IsDone() {
-				fmt.Println("Removing streamClient", streamClient.clientID, "from", sb.streamID, "broadcast")
-				sb.clientStreams[index] = nil
-				sb.clientStreams = append(sb.clientStreams[:index], sb.clientStreams[index+1:]...)
-				continue
-			}
+// sendFrame sends the frame to the clients that are sent its quality and removes the clients that are done
+func (sb *streamBroadcaster) sendFrame(frame qualityFrame) {
+	clientsChanged := false
+	sb.Lock()
+	for index := len(sb.clientStreams) - 1; index >= 0; index-- {
+		streamClient := sb.clientStreams[index]
+		if streamClient.IsDone() {
+			fmt.Println("Removing streamClient", streamClient.clientID, "from", sb.streamID, "broadcast")
+			sb.clientStreams[index] = nil
+			sb.clientStreams = append(sb.clientStreams[:index], sb.clientStreams[index+1:]...)
+			clientsChanged = true
+			continue
+		}
 
-			image, ok := dataQualityMap[streamClient.wantedQuality]
-			if !ok {
-				// If image for wanted quality does not exist send LowQuality
-				image = dataQualityMap[consts.LowQuality]
-				streamClient.wantedQuality = consts.LowQuality
-			}
+		quality, fellBack := streamClient.sentQuality(sb.available)
+		if fellBack {
+			clientsChanged = true
+		}
+		if quality != frame.quality {
+			continue
+		}
 
-			select {
-			case streamClient.inputChan <- image:
-			default:
-				<-streamClient.inputChan
-				streamClient.inputChan <- image
-			}
+		select {
+		case streamClient.inputChan <- frame.data:
+		default:
+			<-streamClient.inputChan
+			streamClient.inputChan <- frame.data
 		}
-		sb.Unlock()
+	}
+	sb.Unlock()
+
+	if clientsChanged {
+		sb.updateViewerCounts()
 	}
 }
 
 type Broadcaster struct {
 	consumer.StreamConsumer
 	streamBroadcasters map[string]*streamBroadcaster
+	timeShifts         map[string]consumer.TimeShiftStream
 	sync.Mutex
 }
 
 func NewBroadcaster(streamConsumer consumer.StreamConsumer) *Broadcaster {
 	return &Broadcaster{
 		streamBroadcasters: make(map[string]*streamBroadcaster),
+		timeShifts:         make(map[string]consumer.TimeShiftStream),
 		StreamConsumer:     streamConsumer,
 	}
 }
@@ -174,7 +391,22 @@ func (bc *Broadcaster) countClients() int {
 	return count
 }
 
-func (bc *Broadcaster) AddClientStream(clientID, streamID string) (*streamClient, error) {
+// AddClientStream adds a client to the live stream, or to a replay of the stream from the given time on
+func (bc *Broadcaster) AddClientStream(clientID, streamID string, from ...time.Time) (*streamClient, error) {
+	if len(from) > 0 {
+		return bc.addTimeShiftClient(clientID, streamID, from[0])
+	}
+
+	return bc.addLiveClient(clientID, streamID, consts.HighQuality)
+}
+
+// AddRenditionClient adds a client to the live stream that is sent the given quality,
+// or the closest one if the stream does not have it
+func (bc *Broadcaster) AddRenditionClient(clientID, streamID string, quality consts.Quality) (*streamClient, error) {
+	return bc.addLiveClient(clientID, streamID, quality)
+}
+
+func (bc *Broadcaster) addLiveClient(clientID, streamID string, quality consts.Quality) (*streamClient, error) {
 	bc.Lock()
 	defer bc.Unlock()
 
@@ -185,12 +417,26 @@ func (bc *Broadcaster) AddClientStream(clientID, streamID string) (*streamClient
 
 	newClient := &streamClient{
 		clientID:      clientID,
-		wantedQuality: consts.HighQuality,
+		wantedQuality: quality,
 		streamType:    stream.GetType(),
 		done:          0,
 		inputChan:     make(chan []byte, 4),
 	}
 
+	// Send the cached parameter sets first so the client can start decoding at the next keyframe,
+	// they are those of the quality the client will be sent
+	renditions := stream.GetMetadata().Renditions
+	seen := make(map[consts.Quality]bool)
+	for rendition := range renditions {
+		seen[rendition] = true
+	}
+	for _, parameterSet := range renditions[fallbackQuality(quality, seen)].ParameterSets {
+		select {
+		case newClient.inputChan <- parameterSet:
+		default:
+		}
+	}
+
 	// Check if broadcaster for that specific stream exists
 	sBroadcaster, broadcasterOK := bc.streamBroadcasters[streamID]
 	if broadcasterOK {
@@ -209,6 +455,7 @@ func (bc *Broadcaster) AddClientStream(clientID, streamID string) (*streamClient
 		inputStream:   stream,
 		clientStreams: cStreams,
 	}
+	newClient.qualityChanged = sBroadcaster.updateViewerCounts
 
 	bc.streamBroadcasters[streamID] = sBroadcaster
 
@@ -0,0 +1,146 @@
+package broadcaster
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// observedStream records the viewer counts the broadcaster reports
+type observedStream struct {
+	consumer.StreamConnection
+	counts []map[consts.Quality]int
+}
+
+func (s *observedStream) SetViewerCounts(counts map[consts.Quality]int) {
+	s.counts = append(s.counts, counts)
+}
+
+func TestQualityChangeUpdatesViewerCounts(t *testing.T) {
+	stream := &observedStream{}
+	sb := &streamBroadcaster{
+		streamID:    "stream0",
+		inputStream: stream,
+		available:   map[consts.Quality]bool{consts.LowQuality: true, consts.HighQuality: true},
+	}
+
+	client := &streamClient{clientID: "viewer", wantedQuality: consts.HighQuality, inputChan: make(chan []byte, 4)}
+	sb.addClient(client)
+	if err := client.ChangeWantedQuality(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stream.counts) != 2 {
+		t.Fatalf("observer got %d updates, want one for the new client and one for the quality change", len(stream.counts))
+	}
+	last := stream.counts[1]
+	if last[consts.LowQuality] != 1 || last[consts.HighQuality] != 0 {
+		t.Errorf("viewer counts after switching to low quality are %v", last)
+	}
+
+	// the quality does not change at the top, so there is nothing to report
+	client.ChangeWantedQuality(true)
+	client.ChangeWantedQuality(true)
+	if len(stream.counts) != 3 {
+		t.Errorf("observer got %d updates, want 3", len(stream.counts))
+	}
+}
+
+// qualityStream is an input stream whose qualities can be added while it is broadcast
+type qualityStream struct {
+	consumer.StreamConnection
+	chans map[consts.Quality]chan []byte
+	sync.Mutex
+}
+
+func (s *qualityStream) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	ch, ok := s.chans[quality]
+	if !ok {
+		return nil, fmt.Errorf("no stream for quality %d", quality)
+	}
+	return ch, nil
+}
+
+func (s *qualityStream) IsOpen() bool {
+	return true
+}
+
+func (s *qualityStream) addQuality(quality consts.Quality) chan []byte {
+	s.Lock()
+	defer s.Unlock()
+	s.chans[quality] = make(chan []byte, 4)
+	return s.chans[quality]
+}
+
+func waitForQualities(t *testing.T, sb *streamBroadcaster, want map[consts.Quality]bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sb.Lock()
+		available := sb.available
+		sb.Unlock()
+		if fmt.Sprint(available) == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broadcast has qualities %v, want %v", available, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func receiveFrame(t *testing.T, client *streamClient, want string) {
+	t.Helper()
+	select {
+	case frame := <-client.inputChan:
+		if string(frame) != want {
+			t.Errorf("client got frame %q, want %q", frame, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client did not get frame %q", want)
+	}
+}
+
+func TestBroadcastFollowsQualitiesOfTheStream(t *testing.T) {
+	defer func(interval time.Duration) { qualityRefreshInterval = interval }(qualityRefreshInterval)
+	qualityRefreshInterval = 10 * time.Millisecond
+
+	stream := &qualityStream{chans: make(map[consts.Quality]chan []byte)}
+	low := stream.addQuality(consts.LowQuality)
+	sb := &streamBroadcaster{streamID: "stream0", inputStream: stream}
+	done := make(chan struct{})
+	go func() {
+		sb.Broadcast()
+		close(done)
+	}()
+	waitForQualities(t, sb, map[consts.Quality]bool{consts.LowQuality: true})
+
+	// a quality that appears after the broadcast started is broadcast too
+	high := stream.addQuality(consts.HighQuality)
+	waitForQualities(t, sb, map[consts.Quality]bool{consts.LowQuality: true, consts.HighQuality: true})
+	client := &streamClient{clientID: "viewer", wantedQuality: consts.HighQuality, inputChan: make(chan []byte, 4)}
+	sb.addClient(client)
+	high <- []byte("high")
+	receiveFrame(t, client, "high")
+
+	// a quality that ended is no longer available, the others are still broadcast
+	close(low)
+	waitForQualities(t, sb, map[consts.Quality]bool{consts.HighQuality: true})
+	high <- []byte("still high")
+	receiveFrame(t, client, "still high")
+
+	close(high)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("broadcast did not stop after all qualities ended")
+	}
+	if !client.IsDone() {
+		t.Error("client is not done after the broadcast stopped")
+	}
+}
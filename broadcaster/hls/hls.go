@@ -0,0 +1,307 @@
+package broadcaster
+
+import (
+	"StreamingServer/broadcaster"
+	"StreamingServer/consts"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatTS cuts MPEG-TS segments, which every HLS player supports
+	FormatTS = "ts"
+	// FormatFMP4 cuts fragmented MP4 segments with an init segment
+	FormatFMP4 = "fmp4"
+
+	playlistType = "application/vnd.apple.mpegurl"
+
+	// minReadyTimeout is how long a request waits at least for the first segment of a rendition
+	minReadyTimeout = 10 * time.Second
+)
+
+// Config sets how the streams are cut into segments
+type Config struct {
+	SegmentDuration time.Duration
+	// Window is the number of segments in the media playlists, older segments are dropped
+	Window int
+	Format string
+	// IdleTimeout stops packaging a stream that no player requested for this long
+	IdleTimeout time.Duration
+}
+
+// DefaultConfig returns 2 second MPEG-TS segments with a window of 6 segments
+func DefaultConfig() Config {
+	return Config{
+		SegmentDuration: 2 * time.Second,
+		Window:          6,
+		Format:          FormatTS,
+		IdleTimeout:     30 * time.Second,
+	}
+}
+
+// Validate checks that the segments can be cut with the config
+func (c Config) Validate() error {
+	if c.SegmentDuration <= 0 {
+		return fmt.Errorf("hls segment duration must be positive, not %s", c.SegmentDuration)
+	}
+	if c.Window < 1 {
+		return fmt.Errorf("hls window must hold at least 1 segment, not %d", c.Window)
+	}
+	if c.Format != FormatTS && c.Format != FormatFMP4 {
+		return fmt.Errorf("hls format must be %s or %s, not '%s'", FormatTS, FormatFMP4, c.Format)
+	}
+	if c.IdleTimeout <= 0 {
+		return fmt.Errorf("hls idle timeout must be positive, not %s", c.IdleTimeout)
+	}
+	return nil
+}
+
+func (c Config) segmentExtension() string {
+	if c.Format == FormatFMP4 {
+		return "m4s"
+	}
+	return "ts"
+}
+
+func (c Config) readyTimeout() time.Duration {
+	// the first segment is cut at the first keyframe after a whole segment
+	timeout := 3 * c.SegmentDuration
+	if timeout < minReadyTimeout {
+		timeout = minReadyTimeout
+	}
+	return timeout
+}
+
+// streamPackager packages every quality of a stream while players request it
+type streamPackager struct {
+	streamID    string
+	renditions  map[consts.Quality]*rendition
+	lastRequest time.Time
+	stop        chan struct{}
+	sync.Mutex
+}
+
+func (sp *streamPackager) touch() {
+	sp.Lock()
+	sp.lastRequest = time.Now()
+	sp.Unlock()
+}
+
+func (sp *streamPackager) idleFor() time.Duration {
+	sp.Lock()
+	defer sp.Unlock()
+	return time.Since(sp.lastRequest)
+}
+
+// Server serves the H.264 streams of the broadcaster as HLS on <prefix><streamID>/master.m3u8
+type Server struct {
+	broadcaster *broadcaster.Broadcaster
+	prefix      string
+	config      Config
+	packagers   map[string]*streamPackager
+	sync.Mutex
+}
+
+// NewServer creates an HLS server for the requests whose path starts with prefix, e.g. /hls/
+func NewServer(bc *broadcaster.Broadcaster, prefix string, config Config) *Server {
+	return &Server{
+		broadcaster: bc,
+		prefix:      prefix,
+		config:      config,
+		packagers:   make(map[string]*streamPackager),
+	}
+}
+
+// getPackager returns the packager of the stream and starts it if nobody watches the stream as HLS yet
+func (s *Server) getPackager(streamID string) (*streamPackager, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if packager, ok := s.packagers[streamID]; ok {
+		packager.touch()
+		return packager, nil
+	}
+
+	stream, err := s.broadcaster.GetStream(streamID)
+	if err != nil {
+		return nil, err
+	}
+	if stream.GetType() != consts.StreamH264 {
+		return nil, fmt.Errorf("stream %s is %s, only h264 streams are served as hls", streamID, stream.GetType())
+	}
+
+	packager := &streamPackager{
+		streamID:    streamID,
+		renditions:  make(map[consts.Quality]*rendition),
+		lastRequest: time.Now(),
+		stop:        make(chan struct{}),
+	}
+
+	var renditions sync.WaitGroup
+	for quality := range consts.Qualities {
+		if _, err := stream.GetOutputChan(quality); err != nil {
+			continue
+		}
+
+		client, err := s.broadcaster.AddRenditionClient(fmt.Sprintf("hls-%d", quality), streamID, quality)
+		if err != nil {
+			continue
+		}
+
+		r := newRendition(quality, client, s.config)
+		packager.renditions[quality] = r
+		renditions.Add(1)
+		go func() {
+			defer renditions.Done()
+			r.run(packager.stop)
+		}()
+	}
+
+	if len(packager.renditions) == 0 {
+		return nil, fmt.Errorf("stream %s has no quality to package", streamID)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		renditions.Wait()
+		close(finished)
+	}()
+	go s.watchPackager(packager, finished)
+
+	s.packagers[streamID] = packager
+	fmt.Println("Started hls packaging of stream", streamID)
+	return packager, nil
+}
+
+// watchPackager stops the packager once no player requested it for the idle timeout or once the broadcast ended
+func (s *Server) watchPackager(packager *streamPackager, finished <-chan struct{}) {
+	for idle := false; !idle; {
+		select {
+		case <-finished:
+			idle = true
+		case <-time.After(s.config.IdleTimeout / 4):
+			idle = packager.idleFor() > s.config.IdleTimeout
+		}
+	}
+
+	s.Lock()
+	delete(s.packagers, packager.streamID)
+	s.Unlock()
+
+	close(packager.stop)
+	fmt.Println("Stopped hls packaging of stream", packager.streamID)
+}
+
+// parsePath splits <streamID>/master.m3u8 or <streamID>/<rendition>/<file>, stream ids may contain slashes
+func parsePath(path string) (streamID, renditionName, file string, err error) {
+	fileStart := strings.LastIndex(path, "/")
+	if fileStart <= 0 {
+		return "", "", "", fmt.Errorf("hls path must look like <stream>/master.m3u8 or <stream>/<rendition>/<file>")
+	}
+
+	file = path[fileStart+1:]
+	if file == "master.m3u8" {
+		return path[:fileStart], "", file, nil
+	}
+
+	renditionStart := strings.LastIndex(path[:fileStart], "/")
+	if renditionStart <= 0 {
+		return "", "", "", fmt.Errorf("hls path must look like <stream>/master.m3u8 or <stream>/<rendition>/<file>")
+	}
+	return path[:renditionStart], path[renditionStart+1 : fileStart], file, nil
+}
+
+func (s *Server) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	streamID, renditionName, file, err := parsePath(strings.TrimPrefix(req.URL.Path, s.prefix))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	packager, err := s.getPackager(streamID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if renditionName == "" {
+		s.serveMaster(writer, packager)
+		return
+	}
+
+	quality, err := consts.GetQualityFromString(renditionName)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	r, ok := packager.renditions[quality]
+	if !ok {
+		http.Error(writer, fmt.Sprintf("stream %s has no %s rendition", streamID, renditionName), http.StatusNotFound)
+		return
+	}
+
+	s.serveRendition(writer, r, file)
+}
+
+// serveMaster lists the renditions that have a segment, it waits for the first segments of a new stream
+func (s *Server) serveMaster(writer http.ResponseWriter, packager *streamPackager) {
+	ready := make(map[consts.Quality]*rendition)
+	for quality, r := range packager.renditions {
+		if r.waitReady(s.config.readyTimeout()) {
+			ready[quality] = r
+		}
+	}
+
+	if len(ready) == 0 {
+		http.Error(writer, fmt.Sprintf("stream %s has no segments yet", packager.streamID), http.StatusServiceUnavailable)
+		return
+	}
+
+	writer.Header().Set("Content-Type", playlistType)
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Write(masterPlaylist(ready))
+}
+
+func (s *Server) serveRendition(writer http.ResponseWriter, r *rendition, file string) {
+	switch {
+	case file == "playlist.m3u8":
+		if !r.waitReady(s.config.readyTimeout()) {
+			http.Error(writer, "the rendition has no segments yet", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", playlistType)
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Write(r.mediaPlaylist())
+
+	case strings.HasPrefix(file, "init-") && strings.HasSuffix(file, ".mp4"):
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, "init-"), ".mp4"))
+		init, ok := r.getInit(id)
+		if err != nil || !ok {
+			http.Error(writer, "no init segment "+file, http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "video/mp4")
+		writer.Write(init)
+
+	case strings.HasSuffix(file, "."+s.config.segmentExtension()):
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(file, "."+s.config.segmentExtension()), 10, 64)
+		data, ok := r.getSegment(sequence)
+		if err != nil || !ok {
+			http.Error(writer, "no segment "+file, http.StatusNotFound)
+			return
+		}
+		if s.config.Format == FormatFMP4 {
+			writer.Header().Set("Content-Type", "video/iso.segment")
+		} else {
+			writer.Header().Set("Content-Type", "video/mp2t")
+		}
+		writer.Write(data)
+
+	default:
+		http.Error(writer, "no hls file "+file, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,82 @@
+package broadcaster
+
+import (
+	"StreamingServer/consts"
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// mediaPlaylist writes the rolling playlist of the segments in the window
+func (r *rendition) mediaPlaylist() []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	version := 3
+	if r.config.Format == FormatFMP4 {
+		version = 6
+	}
+
+	// the target duration must not be shorter than any segment
+	targetDuration := int(math.Ceil(r.config.SegmentDuration.Seconds()))
+	for _, seg := range r.segments {
+		if seconds := int(math.Ceil(seg.duration.Seconds())); seconds > targetDuration {
+			targetDuration = seconds
+		}
+	}
+
+	var playlist bytes.Buffer
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n", version, targetDuration)
+	if len(r.segments) > 0 {
+		fmt.Fprintf(&playlist, "#EXT-X-MEDIA-SEQUENCE:%d\n", r.segments[0].sequence)
+	}
+
+	initID := 0
+	for i, seg := range r.segments {
+		if seg.discontinuity && i > 0 {
+			playlist.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if r.config.Format == FormatFMP4 && seg.initID != initID {
+			fmt.Fprintf(&playlist, "#EXT-X-MAP:URI=\"init-%d.mp4\"\n", seg.initID)
+			initID = seg.initID
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%d.%s\n", seg.duration.Seconds(), seg.sequence, r.config.segmentExtension())
+	}
+
+	return playlist.Bytes()
+}
+
+// masterPlaylist lists the renditions of the stream from the lowest to the highest quality
+func masterPlaylist(renditions map[consts.Quality]*rendition) []byte {
+	var qualities []int
+	for quality := range renditions {
+		qualities = append(qualities, int(quality))
+	}
+	sort.Ints(qualities)
+
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n")
+	for _, quality := range qualities {
+		r := renditions[consts.Quality(quality)]
+		name, err := consts.GetStringFromQuality(r.quality)
+		if err != nil {
+			continue
+		}
+
+		r.Lock()
+		codecs, resolution := r.codecs, r.resolution
+		r.Unlock()
+
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d", r.bandwidth())
+		if resolution != "" {
+			fmt.Fprintf(&playlist, ",RESOLUTION=%s", resolution)
+		}
+		if codecs != "" {
+			fmt.Fprintf(&playlist, ",CODECS=\"%s\"", codecs)
+		}
+		fmt.Fprintf(&playlist, "\n%s/playlist.m3u8\n", name)
+	}
+
+	return playlist.Bytes()
+}
@@ -0,0 +1,272 @@
+package broadcaster
+
+import (
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// liveClient is the part of a broadcaster client that a rendition reads from
+type liveClient interface {
+	GetOutputChannel() chan []byte
+	SetDone()
+	IsDone() bool
+}
+
+// timedAccessUnit is an access unit with its presentation time in 90 kHz units
+type timedAccessUnit struct {
+	codec.H264AccessUnit
+	pts int64
+}
+
+type segment struct {
+	sequence uint64
+	duration time.Duration
+	data     []byte
+	// initID is the init segment the fMP4 segment is decoded with
+	initID int
+	// discontinuity is set if the parameter sets changed before the segment
+	discontinuity bool
+}
+
+// rendition cuts one quality of a stream into segments and keeps the last ones in memory
+type rendition struct {
+	quality consts.Quality
+	client  liveClient
+	config  Config
+	start   time.Time
+
+	// only used by the goroutine that reads the client
+	assembler  codec.H264AccessUnitAssembler
+	params     codec.H264ParameterSets
+	muxer      *codec.TSMuxer
+	pending    []timedAccessUnit
+	lastPTS    int64
+	segmentSPS []byte
+
+	segments     []segment
+	nextSequence uint64
+	inits        map[int][]byte
+	initID       int
+	codecs       string
+	resolution   string
+	ready        chan struct{}
+	sync.Mutex
+}
+
+func newRendition(quality consts.Quality, client liveClient, config Config) *rendition {
+	return &rendition{
+		quality: quality,
+		client:  client,
+		config:  config,
+		start:   time.Now(),
+		muxer:   codec.NewTSMuxer(),
+		inits:   make(map[int][]byte),
+		ready:   make(chan struct{}),
+	}
+}
+
+// run reads the frames of the client until the packager stops or the broadcast of the stream ends
+func (r *rendition) run(stop <-chan struct{}) {
+	defer r.client.SetDone()
+
+	frames := r.client.GetOutputChannel()
+	for {
+		select {
+		case <-stop:
+			return
+
+		case frame := <-frames:
+			r.push(frame, time.Now())
+
+		case <-time.After(time.Second):
+			if r.client.IsDone() {
+				return
+			}
+		}
+	}
+}
+
+// push stamps the access units that the frame completes with the time they arrived
+func (r *rendition) push(frame []byte, now time.Time) {
+	for _, unit := range r.assembler.Push(frame) {
+		pts := int64(now.Sub(r.start) * codec.FMP4Timescale / time.Second)
+		if pts <= r.lastPTS {
+			pts = r.lastPTS + 1
+		}
+		r.lastPTS = pts
+		r.addAccessUnit(timedAccessUnit{unit, pts})
+	}
+}
+
+func (r *rendition) addAccessUnit(unit timedAccessUnit) {
+	// segments are cut at the first keyframe after the segment duration
+	if len(r.pending) > 0 && unit.Keyframe && r.ptsDuration(unit.pts-r.pending[0].pts) >= r.config.SegmentDuration {
+		r.cutSegment(unit.pts)
+	}
+
+	hasParameterSets := false
+	for _, nal := range unit.NALs {
+		if r.params.Observe(nal) {
+			hasParameterSets = true
+		}
+	}
+
+	if len(r.pending) == 0 {
+		// a segment can only start at a keyframe that a decoder can start with
+		if !unit.Keyframe || !r.params.Complete() {
+			return
+		}
+		if r.config.Format == FormatFMP4 && !bytes.Equal(r.segmentSPS, r.params.SPS) {
+			if err := r.addInit(); err != nil {
+				fmt.Println("Unable to create hls init segment:", err)
+				return
+			}
+		}
+	}
+
+	if unit.Keyframe && !hasParameterSets && r.config.Format == FormatTS {
+		// every transport stream segment can be decoded on its own
+		nals := [][]byte{r.params.SPS, r.params.PPS}
+		unit.NALs = append(nals, unit.NALs...)
+	}
+
+	r.pending = append(r.pending, unit)
+}
+
+func (r *rendition) ptsDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / codec.FMP4Timescale
+}
+
+// addInit creates the init segment for the current parameter sets
+func (r *rendition) addInit() error {
+	init, err := codec.FMP4Init(r.params.SPS, r.params.PPS)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.initID++
+	r.inits[r.initID] = init
+	r.Unlock()
+	return nil
+}
+
+// cutSegment muxes the pending access units into a segment that ends at the given time
+func (r *rendition) cutSegment(end int64) {
+	units := r.pending
+	r.pending = nil
+
+	var data []byte
+	switch r.config.Format {
+	case FormatFMP4:
+		samples := make([]codec.FMP4Sample, len(units))
+		for i, unit := range units {
+			next := end
+			if i+1 < len(units) {
+				next = units[i+1].pts
+			}
+			samples[i] = codec.FMP4Sample{
+				Data:     codec.AVCCFromNALs(unit.NALs),
+				Duration: uint32(next - unit.pts),
+				Keyframe: unit.Keyframe,
+			}
+		}
+		data = codec.FMP4Fragment(uint32(r.nextSequence+1), uint64(units[0].pts), samples)
+	default:
+		data = r.muxer.Tables()
+		for _, unit := range units {
+			data = append(data, r.muxer.AccessUnit(unit.NALs, unit.pts, unit.Keyframe)...)
+		}
+	}
+
+	info, err := codec.ParseH264SPS(r.params.SPS)
+	discontinuity := r.segmentSPS != nil && !bytes.Equal(r.segmentSPS, r.params.SPS)
+	r.segmentSPS = r.params.SPS
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err == nil {
+		r.codecs = info.CodecString()
+		r.resolution = fmt.Sprintf("%dx%d", info.Width, info.Height)
+	}
+
+	r.segments = append(r.segments, segment{
+		sequence:      r.nextSequence,
+		duration:      r.ptsDuration(end - units[0].pts),
+		data:          data,
+		initID:        r.initID,
+		discontinuity: discontinuity,
+	})
+	r.nextSequence++
+
+	if len(r.segments) > r.config.Window {
+		r.segments = append([]segment(nil), r.segments[len(r.segments)-r.config.Window:]...)
+		r.pruneInits()
+	}
+
+	if r.nextSequence == 1 {
+		close(r.ready)
+	}
+}
+
+// pruneInits removes the init segments that no segment in the window uses anymore
+func (r *rendition) pruneInits() {
+	for id := range r.inits {
+		if id < r.segments[0].initID {
+			delete(r.inits, id)
+		}
+	}
+}
+
+// waitReady waits until the first segment was cut
+func (r *rendition) waitReady(timeout time.Duration) bool {
+	select {
+	case <-r.ready:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (r *rendition) getSegment(sequence uint64) ([]byte, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, seg := range r.segments {
+		if seg.sequence == sequence {
+			return seg.data, true
+		}
+	}
+	return nil, false
+}
+
+func (r *rendition) getInit(id int) ([]byte, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	init, ok := r.inits[id]
+	return init, ok
+}
+
+// bandwidth is the peak bit rate of the segments in the window
+func (r *rendition) bandwidth() int {
+	r.Lock()
+	defer r.Unlock()
+
+	peak := 0
+	for _, seg := range r.segments {
+		if seg.duration <= 0 {
+			continue
+		}
+		bitRate := int(float64(len(seg.data)*8) / seg.duration.Seconds())
+		if bitRate > peak {
+			peak = bitRate
+		}
+	}
+	return peak
+}
@@ -2,11 +2,28 @@ package broadcaster
 
 import (
 	"StreamingServer/broadcaster"
-	"StreamingServer/broadcaster/http/httphandler"
+	hls "StreamingServer/broadcaster/hls"
+	webrtc "StreamingServer/broadcaster/webrtc"
 	"StreamingServer/consumer"
+	"StreamingServer/streamtype"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	metadataPath  = "/api/streams/"
+	timeShiftPath = "/api/timeshift/"
+	hlsPath       = "/hls/"
+	// whepPath takes the webrtc offers of viewers, the sessions are ended on whepSessionPath
+	whepPath        = "/whep/"
+	whepSessionPath = "/api/whep/"
+
+	// timeShiftHeader tells the client the session id of its time shift
+	timeShiftHeader = "X-Timeshift-Session"
 )
 
 type HTMLPage struct {
@@ -16,12 +33,30 @@ type HTMLPage struct {
 
 type HttpBroadcaster struct {
 	*broadcaster.Broadcaster
+	hlsConfig    hls.Config
+	webrtcConfig webrtc.Config
 }
 
 func NewHTTPBroadcaster(streamConsumer consumer.StreamConsumer) *HttpBroadcaster {
 	return &HttpBroadcaster{
 		Broadcaster: broadcaster.NewBroadcaster(streamConsumer),
+		hlsConfig:   hls.DefaultConfig(),
+	}
+}
+
+// ConfigureHLS changes how the h264 streams are cut into HLS segments, it must be called before PrepareStreamHandlers
+func (hss *HttpBroadcaster) ConfigureHLS(config hls.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
 	}
+
+	hss.hlsConfig = config
+	return nil
+}
+
+// ConfigureWebRTC changes the settings of the webrtc peer connections, it must be called before PrepareStreamHandlers
+func (hss *HttpBroadcaster) ConfigureWebRTC(config webrtc.Config) {
+	hss.webrtcConfig = config
 }
 
 func (hss *HttpBroadcaster) handleRootRequest(writer http.ResponseWriter, req *http.Request) {
@@ -41,18 +76,41 @@ func (hss *HttpBroadcaster) handleRootRequest(writer http.ResponseWriter, req *h
 
 func (hss *HttpBroadcaster) handleStreamRequest(writer http.ResponseWriter, req *http.Request) {
 	streamID := req.URL.Path[1:] // cut off the / at the beginning
-	streamClient, err := hss.AddClientStream(req.RemoteAddr, streamID)
+	from, err := parseTimeShift(req)
 	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	handleHttpStream, hErr := httphandler.GetHTTPStreamHandler(streamClient.GetStreamType())
-	if hErr != nil {
+	streamClient, err := hss.AddClientStream(req.RemoteAddr, streamID, from...)
+	if err != nil {
+		if len(from) > 0 {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	if sessionID := streamClient.GetTimeShiftSession(); sessionID != "" {
+		writer.Header().Set(timeShiftHeader, sessionID)
+	}
+
+	def, err := streamtype.Lookup(streamClient.GetStreamType())
+	if err != nil {
+		streamClient.SetDone()
+		return
+	}
+
+	output, err := def.GetOutput(req.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
 		streamClient.SetDone()
 		return
 	}
 
-	httphandler.SendHTTPHeaders(streamClient.GetStreamType(), writer)
+	handleHttpStream := output.Write
+	if output.Headers != nil {
+		output.Headers(writer)
+	}
 	var auxOutput interface{}
 	for !streamClient.IsDone() {
 		// Need to be wary of raising h264 quality since it will throw an error about using a hjacked connection
@@ -74,6 +132,62 @@ func (hss *HttpBroadcaster) handleStreamRequest(writer http.ResponseWriter, req
 	}
 }
 
+// handleMetadataRequest responds with the metadata of the stream as json
+func (hss *HttpBroadcaster) handleMetadataRequest(writer http.ResponseWriter, req *http.Request) {
+	streamID := strings.TrimPrefix(req.URL.Path, metadataPath)
+	stream, err := hss.GetStream(streamID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(stream.GetMetadata())
+	if err != nil {
+		fmt.Println("Error encoding metadata of stream", streamID, err)
+	}
+}
+
+// parseTimeShift reads the start of a time shift from ?from=<RFC3339 time> or ?offset=<negative duration>,
+// there is no start for the live stream
+func parseTimeShift(req *http.Request) ([]time.Time, error) {
+	query := req.URL.Query()
+	if from := query.Get("from"); from != "" {
+		start, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("from must be an RFC3339 time like 2006-01-02T15:04:05Z")
+		}
+		return []time.Time{start}, nil
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		duration, err := time.ParseDuration(offset)
+		if err != nil || duration > 0 {
+			return nil, fmt.Errorf("offset must be a negative duration like -300s")
+		}
+		return []time.Time{time.Now().Add(duration)}, nil
+	}
+
+	return nil, nil
+}
+
+// handleTimeShiftRequest catches a time shift up to the live stream on POST /api/timeshift/<session>/live
+func (hss *HttpBroadcaster) handleTimeShiftRequest(writer http.ResponseWriter, req *http.Request) {
+	sessionID := strings.TrimPrefix(req.URL.Path, timeShiftPath)
+	if req.Method != http.MethodPost || !strings.HasSuffix(sessionID, "/live") {
+		http.Error(writer, "use POST "+timeShiftPath+"<session>/live", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := hss.CatchUpTimeShift(strings.TrimSuffix(sessionID, "/live"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
 func (hss *HttpBroadcaster) loadPageFromFile(filename, title string) (*HTMLPage, error) {
 	body, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -83,15 +197,41 @@ func (hss *HttpBroadcaster) loadPageFromFile(filename, title string) (*HTMLPage,
 	return &HTMLPage{Title: title, Body: body}, nil
 }
 
+// handleFileOrStreamRequest serves the streams that have no handler of their own, e.g. discovered kafka topics,
+// and the static files for every other path
+func (hss *HttpBroadcaster) handleFileOrStreamRequest(fileServer http.Handler) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if _, err := hss.GetStream(req.URL.Path[1:]); err == nil {
+			hss.handleStreamRequest(writer, req)
+			return
+		}
+
+		fileServer.ServeHTTP(writer, req)
+	}
+}
+
 func (hss *HttpBroadcaster) PrepareStreamHandlers(prepend string, nStreams int) {
-	http.Handle("/", http.FileServer(http.Dir(".")))
+	http.Handle("/", hss.handleFileOrStreamRequest(http.FileServer(http.Dir("."))))
+	http.HandleFunc(metadataPath, hss.handleMetadataRequest)
+	http.HandleFunc(timeShiftPath, hss.handleTimeShiftRequest)
+	http.Handle(hlsPath, hls.NewServer(hss.Broadcaster, hlsPath, hss.hlsConfig))
+	if whep, err := webrtc.NewServer(hss.Broadcaster, whepPath, whepSessionPath, hss.webrtcConfig); err == nil {
+		http.HandleFunc(whepPath, whep.HandleOffer)
+		http.HandleFunc(whepSessionPath, whep.HandleSession)
+	} else {
+		fmt.Println("Unable to serve webrtc:", err)
+	}
 	for i := 0; i < nStreams; i++ {
-		streamID := fmt.Sprintf("/%s%d", prepend, i)
-		fmt.Println(streamID)
-		http.HandleFunc(streamID, hss.handleStreamRequest)
+		hss.AddStreamHandler(fmt.Sprintf("%s%d", prepend, i))
 	}
 }
 
+// AddStreamHandler serves the stream with the given id on /<streamID>
+func (hss *HttpBroadcaster) AddStreamHandler(streamID string) {
+	fmt.Println("/" + streamID)
+	http.HandleFunc("/"+streamID, hss.handleStreamRequest)
+}
+
 func (hss *HttpBroadcaster) StartServer(ip string, port int) {
 	http.ListenAndServe(fmt.Sprintf("%s:%d", ip, port), nil)
 }
@@ -0,0 +1,129 @@
+package httphandler
+
+import (
+	"StreamingServer/codec"
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fmp4DefaultDuration is the duration of the first sample, before the frame rate is known (30 fps)
+const fmp4DefaultDuration = codec.FMP4Timescale / 30
+
+// fmp4Message is a websocket message of an fMP4 client
+type fmp4Message struct {
+	messageType int
+	data        []byte
+}
+
+// fmp4Output muxes the NAL units of a client into fragmented MP4, it is kept between calls as the reusable output
+type fmp4Output struct {
+	webConn   *websocket.Conn
+	assembler codec.H264AccessUnitAssembler
+	params    codec.H264ParameterSets
+	initSPS   []byte
+	sequence  uint32
+	// decodeTime is where the next sample starts, the samples follow each other without gaps
+	decodeTime   uint64
+	lastArrival  time.Time
+	lastDuration uint32
+}
+
+// push returns the messages for the access units that the frame completes.
+// Access units are dropped until the first keyframe after the parameter sets, a new init segment is sent when the SPS changes.
+func (out *fmp4Output) push(frame []byte, now time.Time) ([]fmp4Message, error) {
+	var messages []fmp4Message
+	for _, unit := range out.assembler.Push(frame) {
+		for _, nal := range unit.NALs {
+			out.params.Observe(nal)
+		}
+
+		if out.initSPS == nil || !bytes.Equal(out.initSPS, out.params.SPS) {
+			if !unit.Keyframe || !out.params.Complete() {
+				continue
+			}
+
+			init, err := codec.FMP4Init(out.params.SPS, out.params.PPS)
+			if err != nil {
+				return nil, err
+			}
+			info, _ := codec.ParseH264SPS(out.params.SPS)
+			mimeType := fmt.Sprintf("video/mp4; codecs=\"%s\"", info.CodecString())
+			messages = append(messages, fmp4Message{websocket.TextMessage, []byte(mimeType)}, fmp4Message{websocket.BinaryMessage, init})
+			out.initSPS = out.params.SPS
+		}
+
+		// the duration of a sample is not known until the next one arrives, the last frame interval is used instead
+		if !out.lastArrival.IsZero() {
+			if interval := uint32(now.Sub(out.lastArrival) * codec.FMP4Timescale / time.Second); interval > 0 {
+				out.lastDuration = interval
+			}
+		}
+		out.lastArrival = now
+		duration := out.lastDuration
+		if duration == 0 {
+			duration = fmp4DefaultDuration
+		}
+
+		out.sequence++
+		sample := codec.FMP4Sample{Data: codec.AVCCFromNALs(unit.NALs), Duration: duration, Keyframe: unit.Keyframe}
+		messages = append(messages, fmp4Message{websocket.BinaryMessage, codec.FMP4Fragment(out.sequence, out.decodeTime, []codec.FMP4Sample{sample})})
+		out.decodeTime += uint64(duration)
+	}
+
+	return messages, nil
+}
+
+// HandleH264FMP4StreamRequest muxes the NAL units into fragmented MP4 and sends it over a websocket for Media Source Extensions.
+// A text message with the MIME type for addSourceBuffer precedes every init segment, every access unit is a fragment of its own.
+func HandleH264FMP4StreamRequest(streamChan chan []byte, writer http.ResponseWriter, request *http.Request, reusableOutput interface{}) (bool, interface{}, error) {
+	closeChannel := writer.(http.CloseNotifier).CloseNotify()
+	var output *fmp4Output
+	if reusableOutput != nil {
+		var ok bool
+		output, ok = reusableOutput.(*fmp4Output)
+		if !ok {
+			return false, nil, fmt.Errorf("reusableOutput cannot be casted to fmp4Output")
+		}
+	} else {
+		webConn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return false, nil, err
+		}
+		output = &fmp4Output{webConn: webConn}
+	}
+
+	for {
+		select {
+		case <-time.After(5 * time.Second):
+			fmt.Println(request.RemoteAddr, " has too poor connectivity to the server, removing from stream.", request.URL.Path)
+			return false, output, nil
+
+		case data, ok := <-streamChan:
+			if !ok {
+				return false, output, nil
+			}
+
+			messages, err := output.push(data, time.Now())
+			if err != nil {
+				fmt.Println("Unable to mux fmp4 for", request.RemoteAddr, err)
+				continue
+			}
+
+			for _, message := range messages {
+				err = output.webConn.WriteMessage(message.messageType, message.data)
+				if err != nil {
+					output.webConn.Close()
+					return false, nil, err
+				}
+			}
+
+		case <-closeChannel:
+			output.webConn.Close()
+			return false, nil, fmt.Errorf("Client closed the connection")
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package httphandler
+
+import (
+	"net/http"
+)
+
+// HandleH265StreamRequest passes the HEVC NAL units through over a websocket
+func HandleH265StreamRequest(streamChan chan []byte, writer http.ResponseWriter, request *http.Request, reusableOutput interface{}) (bool, interface{}, error) {
+	return handleWebsocketStream(streamChan, writer, request, reusableOutput)
+}
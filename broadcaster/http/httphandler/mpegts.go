@@ -0,0 +1,11 @@
+package httphandler
+
+import (
+	"net/http"
+)
+
+// HandleMPEGTSStreamRequest sends the transport stream over a websocket as binary messages.
+// Every message holds whole 188 byte packets which is what the jsmpeg WSSource expects.
+func HandleMPEGTSStreamRequest(streamChan chan []byte, writer http.ResponseWriter, request *http.Request, reusableOutput interface{}) (bool, interface{}, error) {
+	return handleWebsocketStream(streamChan, writer, request, reusableOutput)
+}
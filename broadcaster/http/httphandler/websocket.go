@@ -0,0 +1,54 @@
+package httphandler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// handleWebsocketStream upgrades the request to a websocket (or reuses the one passed as reusableOutput)
+// and forwards every chunk from streamChan as a binary message.
+func handleWebsocketStream(streamChan chan []byte, writer http.ResponseWriter, request *http.Request, reusableOutput interface{}) (bool, interface{}, error) {
+	closeChannel := writer.(http.CloseNotifier).CloseNotify()
+	var err error
+	var webConn *websocket.Conn
+	if reusableOutput != nil {
+		var ok bool
+		webConn, ok = reusableOutput.(*websocket.Conn)
+		if !ok {
+			return false, nil, fmt.Errorf("reusableOutput cannot be casted to websocket.Conn")
+		}
+	} else {
+		webConn, err = upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	for {
+		select {
+		case <-time.After(5 * time.Second):
+			fmt.Println(request.RemoteAddr, " has too poor connectivity to the server, removing from stream.", request.URL.Path)
+			return false, webConn, nil
+
+		case data, ok := <-streamChan:
+			if !ok {
+				return false, webConn, nil
+			}
+
+			err = webConn.WriteMessage(websocket.BinaryMessage, data)
+			if err != nil {
+				webConn.Close()
+				return false, nil, err
+			}
+
+		case <-closeChannel:
+			webConn.Close()
+			return false, nil, fmt.Errorf("Client closed the connection")
+		}
+	}
+}
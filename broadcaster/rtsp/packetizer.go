@@ -0,0 +1,260 @@
+package broadcaster
+
+import (
+	"StreamingServer/codec"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	rtpVersion = 2
+	// rtpMaxPacketSize keeps the packets below the MTU of common links
+	rtpMaxPacketSize  = 1400
+	rtpHeaderSize     = 12
+	rtpMaxPayloadSize = rtpMaxPacketSize - rtpHeaderSize
+
+	// payload types of the SDP, JPEG has a static one (RFC 3551)
+	payloadTypeH264 = 96
+	payloadTypeJPEG = 26
+
+	h264NALFUA = 28
+
+	// jpegDynamicQ tells the receiver that the quantization tables are sent in the first packet of every image (RFC 2435 3.1.8)
+	jpegDynamicQ = 255
+	// jpegMaxDimension is the largest width and height that fit into the RTP/JPEG header
+	jpegMaxDimension = 2040
+)
+
+// packetizer splits the frames of a stream into RTP payloads
+type packetizer interface {
+	// packetize returns the payloads of every picture that the frame completes, the last payload of a picture gets the marker bit
+	packetize(frame []byte) ([][][]byte, error)
+}
+
+// rtpHeader writes the fixed RTP header (RFC 3550) in front of the payload
+func rtpHeader(payloadType byte, marker bool, sequence uint16, timestamp, ssrc uint32, payload []byte) []byte {
+	packet := make([]byte, rtpHeaderSize, rtpHeaderSize+len(payload))
+	packet[0] = rtpVersion << 6
+	packet[1] = payloadType
+	if marker {
+		packet[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(packet[2:4], sequence)
+	binary.BigEndian.PutUint32(packet[4:8], timestamp)
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+	return append(packet, payload...)
+}
+
+// h264Packetizer sends every access unit as single NAL unit packets and FU-A fragments (RFC 6184), starting at a keyframe
+type h264Packetizer struct {
+	assembler codec.H264AccessUnitAssembler
+	params    codec.H264ParameterSets
+	started   bool
+}
+
+func (p *h264Packetizer) packetize(frame []byte) ([][][]byte, error) {
+	var pictures [][][]byte
+	for _, unit := range p.assembler.Push(frame) {
+		hasParameterSets := false
+		for _, nal := range unit.NALs {
+			if p.params.Observe(nal) {
+				hasParameterSets = true
+			}
+		}
+
+		if !p.started {
+			if !unit.Keyframe || !p.params.Complete() {
+				continue
+			}
+			p.started = true
+		}
+
+		nals := unit.NALs
+		if unit.Keyframe && !hasParameterSets {
+			// clients that ignore the sprop-parameter-sets of the SDP can still start at every keyframe
+			nals = append([][]byte{p.params.SPS, p.params.PPS}, nals...)
+		}
+
+		var payloads [][]byte
+		for _, nal := range nals {
+			payloads = append(payloads, fragmentH264(nal, rtpMaxPayloadSize)...)
+		}
+		pictures = append(pictures, payloads)
+	}
+
+	return pictures, nil
+}
+
+// fragmentH264 returns the NAL unit as it is if it fits into a packet, otherwise as FU-A fragments
+func fragmentH264(nal []byte, maxSize int) [][]byte {
+	if len(nal) <= maxSize {
+		return [][]byte{nal}
+	}
+
+	indicator := nal[0]&0xe0 | h264NALFUA
+	nalType := nal[0] & 0x1f
+	data := nal[1:]
+
+	var payloads [][]byte
+	for first := true; len(data) > 0; first = false {
+		size := len(data)
+		if size > maxSize-2 {
+			size = maxSize - 2
+		}
+
+		header := nalType
+		if first {
+			header |= 0x80
+		}
+		if size == len(data) {
+			header |= 0x40
+		}
+
+		payload := make([]byte, 0, 2+size)
+		payload = append(payload, indicator, header)
+		payloads = append(payloads, append(payload, data[:size]...))
+		data = data[size:]
+	}
+
+	return payloads
+}
+
+// jpegImage is what RTP/JPEG sends of a baseline JPEG, the rest of the headers are rebuilt by the receiver
+type jpegImage struct {
+	jpegType        byte
+	width           int
+	height          int
+	tables          []byte
+	restartInterval uint16
+	scan            []byte
+}
+
+// parseJPEG reads the markers of a baseline YUV JPEG with 8 bit quantization tables and standard Huffman tables
+func parseJPEG(frame []byte) (*jpegImage, error) {
+	if len(frame) < 4 || frame[0] != 0xff || frame[1] != 0xd8 {
+		return nil, fmt.Errorf("jpeg does not start with SOI")
+	}
+
+	image := &jpegImage{}
+	tables := make(map[byte][]byte)
+	hasFrameHeader := false
+	for i := 2; i+4 <= len(frame); {
+		if frame[i] != 0xff {
+			return nil, fmt.Errorf("jpeg marker expected at %d", i)
+		}
+		marker := frame[i+1]
+		if marker == 0xff {
+			// fill byte
+			i++
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(frame[i+2 : i+4]))
+		if length < 2 || i+2+length > len(frame) {
+			return nil, fmt.Errorf("jpeg segment %02x exceeds the frame", marker)
+		}
+		segment := frame[i+4 : i+2+length]
+
+		switch marker {
+		case 0xdb: // DQT
+			for offset := 0; offset+65 <= len(segment); offset += 65 {
+				if segment[offset]>>4 != 0 {
+					return nil, fmt.Errorf("16 bit jpeg quantization tables are not supported by rtp/jpeg")
+				}
+				tables[segment[offset]&0x0f] = segment[offset+1 : offset+65]
+			}
+
+		case 0xc0: // SOF0, baseline
+			if len(segment) < 15 || segment[5] != 3 {
+				return nil, fmt.Errorf("rtp/jpeg only supports jpegs with 3 components")
+			}
+			image.height = int(binary.BigEndian.Uint16(segment[1:3]))
+			image.width = int(binary.BigEndian.Uint16(segment[3:5]))
+			switch segment[7] {
+			case 0x21:
+				image.jpegType = 0
+			case 0x22:
+				image.jpegType = 1
+			default:
+				return nil, fmt.Errorf("rtp/jpeg does not support the chroma subsampling %02x", segment[7])
+			}
+			hasFrameHeader = true
+
+		case 0xc1, 0xc2, 0xc3, 0xc5, 0xc6, 0xc7, 0xc9, 0xca, 0xcb, 0xcd, 0xce, 0xcf:
+			return nil, fmt.Errorf("rtp/jpeg only supports baseline jpegs")
+
+		case 0xdd: // DRI
+			if len(segment) >= 2 {
+				image.restartInterval = binary.BigEndian.Uint16(segment)
+			}
+
+		case 0xda: // SOS, the scan data follows until EOI
+			if !hasFrameHeader || tables[0] == nil {
+				return nil, fmt.Errorf("jpeg scan without frame header or quantization table")
+			}
+			if image.width > jpegMaxDimension || image.height > jpegMaxDimension {
+				return nil, fmt.Errorf("rtp/jpeg does not support images larger than %d pixels", jpegMaxDimension)
+			}
+
+			image.tables = append([]byte(nil), tables[0]...)
+			chromaTable := tables[1]
+			if chromaTable == nil {
+				chromaTable = tables[0]
+			}
+			image.tables = append(image.tables, chromaTable...)
+			if image.restartInterval != 0 {
+				image.jpegType += 64
+			}
+
+			image.scan = frame[i+2+length:]
+			if n := len(image.scan); n >= 2 && image.scan[n-2] == 0xff && image.scan[n-1] == 0xd9 {
+				image.scan = image.scan[:n-2]
+			}
+			return image, nil
+		}
+
+		i += 2 + length
+	}
+
+	return nil, fmt.Errorf("jpeg has no scan")
+}
+
+// jpegPacketizer sends every image as RTP/JPEG (RFC 2435) with its quantization tables
+type jpegPacketizer struct{}
+
+func (jpegPacketizer) packetize(frame []byte) ([][][]byte, error) {
+	image, err := parseJPEG(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(image.scan) == 0 {
+		return nil, fmt.Errorf("jpeg has no scan data")
+	}
+
+	var payloads [][]byte
+	for offset := 0; offset < len(image.scan); {
+		payload := []byte{
+			0, // type-specific
+			byte(offset >> 16), byte(offset >> 8), byte(offset),
+			image.jpegType, jpegDynamicQ,
+			byte(image.width / 8), byte(image.height / 8),
+		}
+		if image.restartInterval != 0 {
+			// the packets do not have to start at a restart marker, so the count is the reserved 0x3fff
+			payload = append(payload, byte(image.restartInterval>>8), byte(image.restartInterval), 0xff, 0xff)
+		}
+		if offset == 0 {
+			payload = append(payload, 0, 0, byte(len(image.tables)>>8), byte(len(image.tables)))
+			payload = append(payload, image.tables...)
+		}
+
+		size := len(image.scan) - offset
+		if space := rtpMaxPayloadSize - len(payload); size > space {
+			size = space
+		}
+		payloads = append(payloads, append(payload, image.scan[offset:offset+size]...))
+		offset += size
+	}
+
+	return [][][]byte{payloads}, nil
+}
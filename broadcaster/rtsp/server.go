@@ -0,0 +1,661 @@
+package broadcaster
+
+import (
+	"StreamingServer/broadcaster"
+	"StreamingServer/consts"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rtspVersion = "RTSP/1.0"
+	// trackControl is the only track of a stream, players append it to the stream url for the SETUP
+	trackControl = "trackID=0"
+
+	// sessionTimeout is announced to the players, UDP sessions without requests or receiver reports for that long are closed
+	sessionTimeout = 60 * time.Second
+	writeTimeout   = 5 * time.Second
+
+	publicMethods = "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER"
+)
+
+// statusText has the RTSP status codes that HTTP does not know (RFC 2326 7.1.1)
+var statusText = map[int]string{
+	454: "Session Not Found",
+	455: "Method Not Valid in This State",
+	459: "Aggregate Operation Not Allowed",
+	461: "Unsupported Transport",
+}
+
+// Config holds the ports of the RTSP server
+type Config struct {
+	Port int
+	// RTPPort is the UDP port the packets are sent from, RTCP uses the next one. UDP transport is disabled if it is 0.
+	RTPPort int
+}
+
+// DefaultConfig returns the standard RTSP port with RTP on 8000 and RTCP on 8001
+func DefaultConfig() Config {
+	return Config{Port: 8554, RTPPort: 8000}
+}
+
+// udpListeners are the sockets that the packets of all UDP sessions are sent from
+type udpListeners struct {
+	rtp  *net.UDPConn
+	rtcp *net.UDPConn
+}
+
+// Server sends the streams of the broadcaster to RTSP players at rtsp://host:port/<streamID>/<rendition>
+type Server struct {
+	broadcaster *broadcaster.Broadcaster
+	config      Config
+	listener    net.Listener
+	udp         *udpListeners
+	sessions    map[string]*session
+	stop        chan struct{}
+	sync.Mutex
+}
+
+// NewServer creates an RTSP server for the streams of the broadcaster
+func NewServer(bc *broadcaster.Broadcaster, config Config) *Server {
+	return &Server{
+		broadcaster: bc,
+		config:      config,
+		sessions:    make(map[string]*session),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start listens for RTSP connections and serves them until the server is stopped
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
+	if err != nil {
+		return err
+	}
+
+	if s.config.RTPPort > 0 {
+		if s.udp, err = listenUDP(s.config.RTPPort); err != nil {
+			listener.Close()
+			return err
+		}
+		go s.readUDP(s.udp.rtp)
+		go s.readUDP(s.udp.rtcp)
+	}
+
+	s.Lock()
+	s.listener = listener
+	s.Unlock()
+	go s.expireSessions()
+
+	fmt.Println("Serving rtsp on port", s.config.Port)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Stop closes the listeners and all sessions
+func (s *Server) Stop() error {
+	s.Lock()
+	select {
+	case <-s.stop:
+		s.Unlock()
+		return nil
+	default:
+	}
+	close(s.stop)
+	listener := s.listener
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, ss := range s.sessions {
+		sessions = append(sessions, ss)
+	}
+	s.Unlock()
+
+	for _, ss := range sessions {
+		s.closeSession(ss)
+	}
+	if s.udp != nil {
+		s.udp.rtp.Close()
+		s.udp.rtcp.Close()
+	}
+	if listener != nil {
+		return listener.Close()
+	}
+	return nil
+}
+
+func listenUDP(rtpPort int) (*udpListeners, error) {
+	rtp, err := net.ListenUDP("udp", &net.UDPAddr{Port: rtpPort})
+	if err != nil {
+		return nil, err
+	}
+
+	rtcp, err := net.ListenUDP("udp", &net.UDPAddr{Port: rtpPort + 1})
+	if err != nil {
+		rtp.Close()
+		return nil, err
+	}
+
+	return &udpListeners{rtp: rtp, rtcp: rtcp}, nil
+}
+
+// readUDP keeps the UDP sessions alive that the packets, usually receiver reports, come from
+func (s *Server) readUDP(conn *net.UDPConn) {
+	buffer := make([]byte, 1500)
+	for {
+		_, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		for _, ss := range s.sessions {
+			t := ss.transport
+			if t.conn != nil {
+				continue
+			}
+			if t.rtcpAddr.IP.Equal(addr.IP) && (t.rtcpAddr.Port == addr.Port || t.rtpAddr.Port == addr.Port) {
+				ss.touch()
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// expireSessions closes the UDP sessions whose players are gone without a TEARDOWN
+func (s *Server) expireSessions() {
+	ticker := time.NewTicker(sessionTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*session
+		s.Lock()
+		for _, ss := range s.sessions {
+			if ss.expired(sessionTimeout) {
+				expired = append(expired, ss)
+			}
+		}
+		s.Unlock()
+
+		for _, ss := range expired {
+			fmt.Println("Rtsp session", ss.id, "of stream", ss.streamID, "timed out")
+			s.closeSession(ss)
+		}
+	}
+}
+
+func (s *Server) getSession(id string) (*session, bool) {
+	s.Lock()
+	defer s.Unlock()
+	ss, ok := s.sessions[id]
+	return ss, ok
+}
+
+// closeSession stops the session and forgets it, it can be called more than once
+func (s *Server) closeSession(ss *session) {
+	ss.close()
+
+	s.Lock()
+	_, ok := s.sessions[ss.id]
+	delete(s.sessions, ss.id)
+	s.Unlock()
+
+	if ok {
+		fmt.Println("Closed rtsp session", ss.id, "of stream", ss.streamID)
+	}
+}
+
+// rtspConn is the TCP connection of a player, the interleaved packets and the responses are written to it
+type rtspConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	sync.Mutex
+}
+
+type request struct {
+	method string
+	url    *url.URL
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+type response struct {
+	status int
+	header map[string]string
+	body   []byte
+}
+
+func newResponse(status int) *response {
+	return &response{status: status, header: make(map[string]string)}
+}
+
+// readRequest returns the next request, interleaved packets of the player are skipped
+func (c *rtspConn) readRequest() (*request, error) {
+	for {
+		first, err := c.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if first[0] != '$' {
+			break
+		}
+
+		// '$', the channel and the length of the packet
+		header := make([]byte, 4)
+		if _, err = io.ReadFull(c.reader, header); err != nil {
+			return nil, err
+		}
+		length := int(header[2])<<8 | int(header[3])
+		if _, err = c.reader.Discard(length); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := textproto.NewReader(c.reader)
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[2] != rtspVersion {
+		return nil, fmt.Errorf("invalid rtsp request line '%s'", line)
+	}
+
+	target, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{method: parts[0], url: target, header: header}
+	if length := header.Get("Content-Length"); length != "" {
+		size, err := strconv.Atoi(length)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid content length '%s'", length)
+		}
+		req.body = make([]byte, size)
+		if _, err = io.ReadFull(c.reader, req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+func (c *rtspConn) writeResponse(cseq string, resp *response) error {
+	text := statusText[resp.status]
+	if text == "" {
+		text = http.StatusText(resp.status)
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %d %s\r\n", rtspVersion, resp.status, text)
+	fmt.Fprintf(&buffer, "CSeq: %s\r\n", cseq)
+
+	names := make([]string, 0, len(resp.header))
+	for name := range resp.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buffer, "%s: %s\r\n", name, resp.header[name])
+	}
+	if len(resp.body) > 0 {
+		fmt.Fprintf(&buffer, "Content-Length: %d\r\n", len(resp.body))
+	}
+	buffer.WriteString("\r\n")
+	buffer.Write(resp.body)
+
+	return c.write(buffer.Bytes())
+}
+
+// writeInterleaved sends a packet on the channel of the TCP connection (RFC 2326 10.12)
+func (c *rtspConn) writeInterleaved(channel byte, packet []byte) error {
+	data := make([]byte, 4, 4+len(packet))
+	data[0] = '$'
+	data[1] = channel
+	data[2] = byte(len(packet) >> 8)
+	data[3] = byte(len(packet))
+	return c.write(append(data, packet...))
+}
+
+func (c *rtspConn) write(data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (s *Server) serveConn(netConn net.Conn) {
+	c := &rtspConn{conn: netConn, reader: bufio.NewReader(netConn)}
+	defer func() {
+		netConn.Close()
+
+		// interleaved sessions cannot outlive their connection
+		var sessions []*session
+		s.Lock()
+		for _, ss := range s.sessions {
+			if ss.transport.conn == c {
+				sessions = append(sessions, ss)
+			}
+		}
+		s.Unlock()
+		for _, ss := range sessions {
+			s.closeSession(ss)
+		}
+	}()
+
+	for {
+		req, err := c.readRequest()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Closing rtsp connection of", netConn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		resp, afterResponse := s.handle(c, req)
+		if err = c.writeResponse(req.header.Get("CSeq"), resp); err != nil {
+			fmt.Println("Unable to answer rtsp request of", netConn.RemoteAddr(), err)
+			return
+		}
+		if afterResponse != nil {
+			afterResponse()
+		}
+	}
+}
+
+// handle answers a request, afterResponse is called once the response is sent, e.g. to start sending the packets of a PLAY
+func (s *Server) handle(c *rtspConn, req *request) (resp *response, afterResponse func()) {
+	var ss *session
+	if sessionHeader := req.header.Get("Session"); sessionHeader != "" {
+		id := strings.TrimSpace(strings.Split(sessionHeader, ";")[0])
+		var ok bool
+		if ss, ok = s.getSession(id); !ok {
+			return newResponse(454), nil
+		}
+		ss.touch()
+	}
+
+	switch req.method {
+	case "OPTIONS":
+		resp = newResponse(http.StatusOK)
+		resp.header["Public"] = publicMethods
+		return resp, nil
+
+	case "DESCRIBE":
+		return s.describe(req), nil
+
+	case "SETUP":
+		if ss != nil {
+			// every stream has a single track, so a session is only set up once
+			return newResponse(459), nil
+		}
+		return s.setup(c, req), nil
+
+	case "PLAY":
+		if ss == nil {
+			return newResponse(454), nil
+		}
+		return s.play(req, ss)
+
+	case "TEARDOWN":
+		if ss == nil {
+			return newResponse(454), nil
+		}
+		s.closeSession(ss)
+		return newResponse(http.StatusOK), nil
+
+	case "GET_PARAMETER":
+		// players send it as keepalive
+		resp = newResponse(http.StatusOK)
+		if ss != nil {
+			resp.header["Session"] = ss.id
+		}
+		return resp, nil
+	}
+
+	resp = newResponse(http.StatusNotImplemented)
+	resp.header["Public"] = publicMethods
+	return resp, nil
+}
+
+// parseStreamURL splits rtsp://host/<streamID>/<rendition>[/trackID=0], stream ids may contain slashes
+func parseStreamURL(target *url.URL) (string, consts.Quality, error) {
+	path := strings.TrimSuffix(strings.Trim(target.Path, "/"), "/"+trackControl)
+	renditionStart := strings.LastIndex(path, "/")
+	if renditionStart <= 0 {
+		return "", 0, fmt.Errorf("rtsp url must look like rtsp://host/<stream>/<rendition>")
+	}
+
+	quality, err := consts.GetQualityFromString(path[renditionStart+1:])
+	if err != nil {
+		return "", 0, err
+	}
+
+	return path[:renditionStart], quality, nil
+}
+
+// streamURL is the url of the stream without the track, as the players use it for the aggregate requests
+func streamURL(target *url.URL) string {
+	streamTarget := *target
+	streamTarget.Path = strings.TrimSuffix(strings.TrimSuffix(target.Path, "/"), "/"+trackControl)
+	return streamTarget.String()
+}
+
+// describe answers with the SDP of the rendition, H.264 streams carry their cached parameter sets
+func (s *Server) describe(req *request) *response {
+	streamID, quality, err := parseStreamURL(req.url)
+	if err != nil {
+		return newResponse(http.StatusNotFound)
+	}
+
+	stream, err := s.broadcaster.GetStream(streamID)
+	if err != nil {
+		return newResponse(http.StatusNotFound)
+	}
+	if _, err = stream.GetOutputChan(quality); err != nil {
+		return newResponse(http.StatusNotFound)
+	}
+
+	var media string
+	switch stream.GetType() {
+	case consts.StreamH264:
+		fmtp := "packetization-mode=1"
+		// the qualities are separate encodings, the parameter sets of another one would not decode this one
+		codec := stream.GetMetadata().Renditions[quality].Codec
+		if codecs := codec["codecs"]; strings.HasPrefix(codecs, "avc1.") {
+			fmtp += ";profile-level-id=" + strings.ToLower(strings.TrimPrefix(codecs, "avc1."))
+		}
+		if codec["sps"] != "" && codec["pps"] != "" {
+			fmtp += ";sprop-parameter-sets=" + codec["sps"] + "," + codec["pps"]
+		}
+		media = fmt.Sprintf("m=video 0 RTP/AVP %d\r\na=rtpmap:%d H264/%d\r\na=fmtp:%d %s\r\n",
+			payloadTypeH264, payloadTypeH264, rtpClockRate, payloadTypeH264, fmtp)
+
+	case consts.StreamMJPG:
+		media = fmt.Sprintf("m=video 0 RTP/AVP %d\r\na=rtpmap:%d JPEG/%d\r\n", payloadTypeJPEG, payloadTypeJPEG, rtpClockRate)
+
+	default:
+		fmt.Println("Rtsp cannot send stream", streamID, "of type", stream.GetType())
+		return newResponse(http.StatusUnsupportedMediaType)
+	}
+
+	sdp := "v=0\r\n" +
+		fmt.Sprintf("o=- %d 1 IN IP4 0.0.0.0\r\n", time.Now().Unix()) +
+		fmt.Sprintf("s=%s\r\n", streamID) +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n" +
+		"a=control:*\r\n" +
+		"a=range:npt=0-\r\n" +
+		media +
+		"a=control:" + trackControl + "\r\n"
+
+	resp := newResponse(http.StatusOK)
+	resp.header["Content-Base"] = streamURL(req.url) + "/"
+	resp.header["Content-Type"] = "application/sdp"
+	resp.body = []byte(sdp)
+	return resp
+}
+
+// parsePortRange reads a pair of ports or channels like 5000-5001, the second one defaults to the first one + 1
+func parsePortRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return first, first + 1, nil
+	}
+
+	second, err := strconv.Atoi(parts[1])
+	return first, second, err
+}
+
+// parseTransport picks the first transport of the player that the server supports, unicast RTP interleaved on the connection or over UDP.
+// It returns the transport and the value of the Transport header of the response.
+func (s *Server) parseTransport(c *rtspConn, header string) (*rtpTransport, string, error) {
+	for _, spec := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(spec), ";")
+		params := make(map[string]string)
+		for _, field := range fields[1:] {
+			keyValue := strings.SplitN(field, "=", 2)
+			if len(keyValue) == 2 {
+				params[keyValue[0]] = keyValue[1]
+			} else {
+				params[keyValue[0]] = ""
+			}
+		}
+		if _, ok := params["multicast"]; ok {
+			continue
+		}
+
+		switch fields[0] {
+		case "RTP/AVP/TCP":
+			rtpChannel, rtcpChannel := 0, 1
+			if interleaved, ok := params["interleaved"]; ok {
+				var err error
+				if rtpChannel, rtcpChannel, err = parsePortRange(interleaved); err != nil || rtpChannel > 254 || rtcpChannel != rtpChannel+1 {
+					continue
+				}
+			}
+			transport := &rtpTransport{conn: c, rtpChannel: byte(rtpChannel)}
+			return transport, fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", rtpChannel, rtcpChannel), nil
+
+		case "RTP/AVP", "RTP/AVP/UDP":
+			if s.udp == nil {
+				continue
+			}
+			rtpPort, rtcpPort, err := parsePortRange(params["client_port"])
+			if err != nil {
+				continue
+			}
+
+			remote, ok := c.conn.RemoteAddr().(*net.TCPAddr)
+			if !ok {
+				return nil, "", fmt.Errorf("udp transport needs an ip connection")
+			}
+			transport := &rtpTransport{
+				udp:      s.udp,
+				rtpAddr:  &net.UDPAddr{IP: remote.IP, Port: rtpPort},
+				rtcpAddr: &net.UDPAddr{IP: remote.IP, Port: rtcpPort},
+			}
+			return transport, fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d", rtpPort, rtcpPort, s.config.RTPPort, s.config.RTPPort+1), nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("no supported transport in '%s'", header)
+}
+
+// setup creates the session of a player with the transport it asked for
+func (s *Server) setup(c *rtspConn, req *request) *response {
+	streamID, quality, err := parseStreamURL(req.url)
+	if err != nil {
+		return newResponse(http.StatusNotFound)
+	}
+
+	stream, err := s.broadcaster.GetStream(streamID)
+	if err != nil {
+		return newResponse(http.StatusNotFound)
+	}
+	if stream.GetType() != consts.StreamH264 && stream.GetType() != consts.StreamMJPG {
+		return newResponse(http.StatusUnsupportedMediaType)
+	}
+
+	transport, transportHeader, err := s.parseTransport(c, req.header.Get("Transport"))
+	if err != nil {
+		fmt.Println("Unable to set up rtsp session of stream", streamID, err)
+		return newResponse(461)
+	}
+
+	ss, err := newSession(streamID, quality, stream.GetType(), transport)
+	if err != nil {
+		return newResponse(http.StatusInternalServerError)
+	}
+
+	s.Lock()
+	s.sessions[ss.id] = ss
+	s.Unlock()
+	fmt.Println("Set up rtsp session", ss.id, "of stream", streamID, "with", transportHeader)
+
+	resp := newResponse(http.StatusOK)
+	resp.header["Transport"] = fmt.Sprintf("%s;ssrc=%08X", transportHeader, ss.ssrc)
+	resp.header["Session"] = fmt.Sprintf("%s;timeout=%d", ss.id, int(sessionTimeout/time.Second))
+	return resp
+}
+
+// play adds the session to the broadcast of its rendition, the packets are sent once the response is written
+func (s *Server) play(req *request, ss *session) (*response, func()) {
+	resp := newResponse(http.StatusOK)
+	resp.header["Session"] = ss.id
+	resp.header["Range"] = "npt=0.000-"
+
+	if ss.playing() {
+		// pausing is not supported, so a repeated PLAY changes nothing
+		return resp, nil
+	}
+
+	client, err := s.broadcaster.AddRenditionClient("rtsp-"+ss.id, ss.streamID, ss.quality)
+	if err != nil {
+		fmt.Println("Unable to play rtsp session", ss.id, err)
+		return newResponse(http.StatusNotFound), nil
+	}
+
+	sequence, timestamp := ss.play(client)
+	resp.header["RTP-Info"] = fmt.Sprintf("url=%s/%s;seq=%d;rtptime=%d", streamURL(req.url), trackControl, sequence, timestamp)
+	return resp, func() {
+		go ss.run(s.closeSession)
+	}
+}
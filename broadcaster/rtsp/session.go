@@ -0,0 +1,222 @@
+package broadcaster
+
+import (
+	"StreamingServer/consts"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	rtpClockRate = 90000
+	// ntpEpochOffset is the number of seconds from 1900, where NTP time starts, to 1970
+	ntpEpochOffset       = 2208988800
+	senderReportInterval = 5 * time.Second
+)
+
+// liveClient is the part of a broadcaster client that a session sends to the player
+type liveClient interface {
+	GetOutputChannel() chan []byte
+	SetDone()
+	IsDone() bool
+}
+
+// rtpTransport sends the packets of a session interleaved on the RTSP connection or over UDP
+type rtpTransport struct {
+	// conn is set for interleaved transport, the packets are sent on rtpChannel and rtpChannel+1
+	conn       *rtspConn
+	rtpChannel byte
+
+	udp      *udpListeners
+	rtpAddr  *net.UDPAddr
+	rtcpAddr *net.UDPAddr
+}
+
+func (t *rtpTransport) writeRTP(packet []byte) error {
+	if t.conn != nil {
+		return t.conn.writeInterleaved(t.rtpChannel, packet)
+	}
+	_, err := t.udp.rtp.WriteToUDP(packet, t.rtpAddr)
+	return err
+}
+
+func (t *rtpTransport) writeRTCP(packet []byte) error {
+	if t.conn != nil {
+		return t.conn.writeInterleaved(t.rtpChannel+1, packet)
+	}
+	_, err := t.udp.rtcp.WriteToUDP(packet, t.rtcpAddr)
+	return err
+}
+
+// session is a player that set up a rendition of a stream
+type session struct {
+	id         string
+	streamID   string
+	quality    consts.Quality
+	streamType consts.StreamType
+	transport  *rtpTransport
+
+	ssrc          uint32
+	sequence      uint16
+	timestampBase uint32
+	start         time.Time
+	packets       uint32
+	octets        uint32
+
+	client   liveClient
+	lastSeen time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	sync.Mutex
+}
+
+func randomUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func newSession(streamID string, quality consts.Quality, streamType consts.StreamType, transport *rtpTransport) (*session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{
+		id:            id,
+		streamID:      streamID,
+		quality:       quality,
+		streamType:    streamType,
+		transport:     transport,
+		ssrc:          randomUint32(),
+		sequence:      uint16(randomUint32()),
+		timestampBase: randomUint32(),
+		lastSeen:      time.Now(),
+		stop:          make(chan struct{}),
+	}, nil
+}
+
+// touch keeps a UDP session from timing out
+func (ss *session) touch() {
+	ss.Lock()
+	ss.lastSeen = time.Now()
+	ss.Unlock()
+}
+
+// expired reports whether a UDP session got no request or receiver report for the timeout, interleaved sessions end with their connection
+func (ss *session) expired(timeout time.Duration) bool {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.transport.conn == nil && time.Since(ss.lastSeen) > timeout
+}
+
+func (ss *session) playing() bool {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.client != nil
+}
+
+func (ss *session) rtpTimestamp(now time.Time) uint32 {
+	return ss.timestampBase + uint32(now.Sub(ss.start)*rtpClockRate/time.Second)
+}
+
+// close stops sending and tells the broadcaster that the player is gone
+func (ss *session) close() {
+	ss.stopOnce.Do(func() {
+		close(ss.stop)
+		ss.Lock()
+		if ss.client != nil {
+			ss.client.SetDone()
+		}
+		ss.Unlock()
+	})
+}
+
+// play starts the clock of the session and returns the first sequence number and timestamp for the RTP-Info
+func (ss *session) play(client liveClient) (uint16, uint32) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	ss.client = client
+	ss.start = time.Now()
+	return ss.sequence, ss.timestampBase
+}
+
+// run sends the frames of the client until the session is closed or the broadcast ends
+func (ss *session) run(onClose func(*session)) {
+	defer onClose(ss)
+	// the session may have been closed before it was played
+	defer ss.client.SetDone()
+
+	var p packetizer = &h264Packetizer{}
+	payloadType := byte(payloadTypeH264)
+	if ss.streamType == consts.StreamMJPG {
+		p = jpegPacketizer{}
+		payloadType = payloadTypeJPEG
+	}
+
+	reports := time.NewTicker(senderReportInterval)
+	defer reports.Stop()
+
+	frames := ss.client.GetOutputChannel()
+	for {
+		select {
+		case <-ss.stop:
+			return
+
+		case frame := <-frames:
+			pictures, err := p.packetize(frame)
+			if err != nil {
+				fmt.Println("Unable to packetize frame for rtsp session", ss.id, err)
+				continue
+			}
+
+			timestamp := ss.rtpTimestamp(time.Now())
+			for _, payloads := range pictures {
+				for i, payload := range payloads {
+					packet := rtpHeader(payloadType, i == len(payloads)-1, ss.sequence, timestamp, ss.ssrc, payload)
+					if err = ss.transport.writeRTP(packet); err != nil {
+						fmt.Println("Error sending to rtsp session", ss.id, err)
+						return
+					}
+					ss.sequence++
+					ss.packets++
+					ss.octets += uint32(len(payload))
+				}
+			}
+
+		case now := <-reports.C:
+			if ss.client.IsDone() {
+				return
+			}
+			ss.transport.writeRTCP(ss.senderReport(now))
+		}
+	}
+}
+
+// senderReport tells the player which RTP timestamp belongs to the wall clock time (RFC 3550 6.4.1)
+func (ss *session) senderReport(now time.Time) []byte {
+	report := make([]byte, 28)
+	report[0] = rtpVersion << 6
+	report[1] = 200
+	binary.BigEndian.PutUint16(report[2:4], 6) // length in 32 bit words minus one
+	binary.BigEndian.PutUint32(report[4:8], ss.ssrc)
+	binary.BigEndian.PutUint32(report[8:12], uint32(now.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(report[12:16], uint32(uint64(now.Nanosecond())<<32/uint64(time.Second)))
+	binary.BigEndian.PutUint32(report[16:20], ss.rtpTimestamp(now))
+	binary.BigEndian.PutUint32(report[20:24], ss.packets)
+	binary.BigEndian.PutUint32(report[24:28], ss.octets)
+	return report
+}
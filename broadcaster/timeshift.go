@@ -0,0 +1,115 @@
+package broadcaster
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// timeShiftCheckInterval is how often a waiting replay checks whether its client left
+const timeShiftCheckInterval = time.Second
+
+// addTimeShiftClient adds a client that watches the stream from the given time on with a replay of its own.
+// The session id of the client is used to catch up to the live stream.
+func (bc *Broadcaster) addTimeShiftClient(clientID, streamID string, from time.Time) (*streamClient, error) {
+	stream, err := bc.GetStream(streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	shifter, ok := stream.(consumer.TimeShifter)
+	if !ok {
+		return nil, fmt.Errorf("stream %s does not support time shifting", streamID)
+	}
+
+	// replay the best quality that has a history
+	var replay consumer.TimeShiftStream
+	quality := consts.HighQuality
+	for ; quality >= consts.LowQuality; quality-- {
+		replay, err = shifter.OpenTimeShift(quality, from)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	newClient := &streamClient{
+		clientID:      clientID,
+		wantedQuality: consts.HighQuality,
+		streamType:    stream.GetType(),
+		inputChan:     make(chan []byte, 4),
+	}
+
+	for _, parameterSet := range stream.GetMetadata().Renditions[quality].ParameterSets {
+		select {
+		case newClient.inputChan <- parameterSet:
+		default:
+		}
+	}
+
+	bc.Lock()
+	newClient.timeShiftID = fmt.Sprintf("%s-%08x", streamID, rand.Uint32())
+	bc.timeShifts[newClient.timeShiftID] = replay
+	bc.Unlock()
+
+	fmt.Println("Adding streamClient", clientID, "to time shift", newClient.timeShiftID, "from", from.Format(time.RFC3339))
+	go bc.forwardTimeShift(newClient.timeShiftID, replay, newClient)
+	return newClient, nil
+}
+
+// CatchUpTimeShift lets the replay of the session run without pacing until it reaches the live stream
+func (bc *Broadcaster) CatchUpTimeShift(sessionID string) error {
+	bc.Lock()
+	replay, ok := bc.timeShifts[sessionID]
+	bc.Unlock()
+	if !ok {
+		return fmt.Errorf("no time shift session '%s'", sessionID)
+	}
+
+	replay.CatchUp()
+	return nil
+}
+
+// forwardTimeShift sends the frames of the replay to the client until either of them is done
+func (bc *Broadcaster) forwardTimeShift(sessionID string, replay consumer.TimeShiftStream, client *streamClient) {
+	defer func() {
+		client.SetDone()
+		bc.Lock()
+		delete(bc.timeShifts, sessionID)
+		bc.Unlock()
+
+		err := replay.Close()
+		if err != nil {
+			fmt.Println("Error closing time shift", sessionID, err)
+		}
+		fmt.Println("Removing time shift", sessionID)
+	}()
+
+	ticker := time.NewTicker(timeShiftCheckInterval)
+	defer ticker.Stop()
+
+	frames := replay.Frames()
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok || client.IsDone() {
+				return
+			}
+
+			select {
+			case client.inputChan <- frame:
+			default:
+				<-client.inputChan
+				client.inputChan <- frame
+			}
+		case <-ticker.C:
+			if client.IsDone() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package broadcaster
+
+import (
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	// lowerLossFraction is the share of lost packets in a receiver report above which a viewer gets a lower quality
+	lowerLossFraction = 0.1
+	// raiseLossFraction is the share of lost packets the reports must stay below for raiseInterval to raise the quality
+	raiseLossFraction = 0.02
+	raiseInterval     = 10 * time.Second
+	// changeHoldOff gives the reports time to reflect a quality change before the next one
+	changeHoldOff = 2 * time.Second
+
+	defaultSampleDuration = time.Second / 30
+)
+
+// viewerClient is the part of a broadcaster client that a peer sends to the viewer
+type viewerClient interface {
+	GetOutputChannel() chan []byte
+	SetDone()
+	IsDone() bool
+	GetWantedQuality() consts.Quality
+	ChangeWantedQuality(higher bool) error
+}
+
+// sampleWriter is the track a peer writes the access units of the viewer to
+type sampleWriter interface {
+	WriteSample(sample media.Sample) error
+}
+
+// qualityController decides from the receiver reports of a viewer whether it should get another quality
+type qualityController struct {
+	lastChange time.Time
+	goodSince  time.Time
+}
+
+// observe returns -1 if the quality should be lowered, 1 if it should be raised and 0 to keep it
+func (qc *qualityController) observe(fractionLost float64, now time.Time) int {
+	switch {
+	case fractionLost > lowerLossFraction:
+		qc.goodSince = time.Time{}
+		if now.Sub(qc.lastChange) < changeHoldOff {
+			return 0
+		}
+		qc.lastChange = now
+		return -1
+
+	case fractionLost > raiseLossFraction:
+		qc.goodSince = time.Time{}
+		return 0
+
+	case qc.goodSince.IsZero():
+		qc.goodSince = now
+		return 0
+
+	case now.Sub(qc.goodSince) >= raiseInterval && now.Sub(qc.lastChange) >= raiseInterval:
+		qc.goodSince = now
+		qc.lastChange = now
+		return 1
+	}
+
+	return 0
+}
+
+// peer sends a stream to the peer connection of one viewer
+type peer struct {
+	sessionID string
+	streamID  string
+	client    viewerClient
+	conn      *webrtc.PeerConnection
+	track     sampleWriter
+	sender    *webrtc.RTPSender
+	quality   qualityController
+	// parameterSets returns the parameter sets the stream cached for a quality, with start codes
+	parameterSets func(quality consts.Quality) [][]byte
+	// restart is set when the viewer has to wait for the next keyframe, e.g. after a quality change
+	restart   uint32
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// close stops sending and tells the broadcaster that the viewer is gone, onClose is called once
+func (p *peer) close(onClose func(*peer)) {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.client.SetDone()
+		p.conn.Close()
+		onClose(p)
+		fmt.Println("Closed webrtc session", p.sessionID, "of stream", p.streamID)
+	})
+}
+
+// sendFrames writes the access units of the client to the track, starting at a keyframe
+func (p *peer) sendFrames(onClose func(*peer)) {
+	var assembler codec.H264AccessUnitAssembler
+	var params codec.H264ParameterSets
+	var lastArrival time.Time
+	waitKeyframe := true
+
+	frames := p.client.GetOutputChannel()
+	for {
+		select {
+		case <-p.closed:
+			return
+
+		case frame := <-frames:
+			now := time.Now()
+			for _, unit := range assembler.Push(frame) {
+				if atomic.CompareAndSwapUint32(&p.restart, 1, 0) {
+					// the new quality may be encoded with other parameter sets than the old one
+					waitKeyframe = true
+					params = p.qualityParameterSets()
+				}
+
+				hasParameterSets := false
+				for _, nal := range unit.NALs {
+					if params.Observe(nal) {
+						hasParameterSets = true
+					}
+				}
+
+				if waitKeyframe {
+					if !unit.Keyframe || !params.Complete() {
+						continue
+					}
+					waitKeyframe = false
+				}
+
+				nals := unit.NALs
+				if unit.Keyframe && !hasParameterSets {
+					// browsers only decode keyframes that come with their parameter sets
+					nals = append([][]byte{params.SPS, params.PPS}, nals...)
+				}
+				var data []byte
+				for _, nal := range nals {
+					data = append(data, codec.WithStartCode(nal)...)
+				}
+
+				duration := now.Sub(lastArrival)
+				if lastArrival.IsZero() || duration <= 0 {
+					duration = defaultSampleDuration
+				}
+				lastArrival = now
+
+				err := p.track.WriteSample(media.Sample{Data: data, Duration: duration})
+				if err != nil {
+					fmt.Println("Error writing to webrtc session", p.sessionID, err)
+					p.close(onClose)
+					return
+				}
+			}
+
+		case <-time.After(time.Second):
+			if p.client.IsDone() {
+				p.close(onClose)
+				return
+			}
+		}
+	}
+}
+
+// qualityParameterSets returns the cached parameter sets of the quality the viewer wants now
+func (p *peer) qualityParameterSets() codec.H264ParameterSets {
+	var params codec.H264ParameterSets
+	if p.parameterSets == nil {
+		return params
+	}
+
+	for _, parameterSet := range p.parameterSets(p.client.GetWantedQuality()) {
+		for _, nal := range codec.SplitAnnexB(parameterSet) {
+			params.Observe(nal)
+		}
+	}
+	return params
+}
+
+// readReports changes the quality of the viewer with the packet loss in its receiver reports
+func (p *peer) readReports() {
+	for {
+		packets, _, err := p.sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			report, ok := packet.(*rtcp.ReceiverReport)
+			if !ok {
+				continue
+			}
+			for _, block := range report.Reports {
+				p.adapt(float64(block.FractionLost) / 256)
+			}
+		}
+	}
+}
+
+func (p *peer) adapt(fractionLost float64) {
+	quality := p.client.GetWantedQuality()
+	switch p.quality.observe(fractionLost, time.Now()) {
+	case -1:
+		if quality <= consts.LowQuality {
+			return
+		}
+		fmt.Printf("Webrtc session %s lost %.0f%% of the packets, lowering the quality\n", p.sessionID, fractionLost*100)
+		p.client.ChangeWantedQuality(false)
+	case 1:
+		if quality >= consts.HighQuality {
+			return
+		}
+		p.client.ChangeWantedQuality(true)
+	default:
+		return
+	}
+
+	atomic.StoreUint32(&p.restart, 1)
+}
@@ -0,0 +1,101 @@
+package broadcaster
+
+import (
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// fakeViewer is a client whose quality the test changes
+type fakeViewer struct {
+	frames  chan []byte
+	quality consts.Quality
+	sync.Mutex
+}
+
+func (fv *fakeViewer) GetOutputChannel() chan []byte { return fv.frames }
+func (fv *fakeViewer) SetDone()                      {}
+func (fv *fakeViewer) IsDone() bool                  { return false }
+func (fv *fakeViewer) GetWantedQuality() consts.Quality {
+	fv.Lock()
+	defer fv.Unlock()
+	return fv.quality
+}
+func (fv *fakeViewer) ChangeWantedQuality(higher bool) error {
+	fv.Lock()
+	defer fv.Unlock()
+	if higher {
+		fv.quality++
+	} else {
+		fv.quality--
+	}
+	return nil
+}
+
+// sampleRecorder passes the samples written to the track on to the test
+type sampleRecorder chan []byte
+
+func (sr sampleRecorder) WriteSample(sample media.Sample) error {
+	sr <- sample.Data
+	return nil
+}
+
+func TestQualityChangeUsesParameterSetsOfNewQuality(t *testing.T) {
+	highSPS := []byte{0x67, 0x64, 0x00, 0x1f, 0xac}
+	lowSPS := []byte{0x67, 0x42, 0xc0, 0x1f, 0xda}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+	slice := []byte{0x41, 0x9a, 0x02, 0x03}
+
+	viewer := &fakeViewer{frames: make(chan []byte, 8), quality: consts.HighQuality}
+	samples := make(sampleRecorder, 8)
+	p := &peer{
+		sessionID: "session0",
+		client:    viewer,
+		track:     samples,
+		closed:    make(chan struct{}),
+		parameterSets: func(quality consts.Quality) [][]byte {
+			if quality == consts.LowQuality {
+				return [][]byte{codec.WithStartCode(lowSPS), codec.WithStartCode(pps)}
+			}
+			return [][]byte{codec.WithStartCode(highSPS), codec.WithStartCode(pps)}
+		},
+	}
+	go p.sendFrames(func(*peer) {})
+	defer close(p.closed)
+
+	sample := func(want ...[]byte) {
+		t.Helper()
+		var data []byte
+		for _, nal := range want {
+			data = append(data, codec.WithStartCode(nal)...)
+		}
+		select {
+		case got := <-samples:
+			if !bytes.Equal(got, data) {
+				t.Errorf("sample is %x, want %x", got, data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no sample was written")
+		}
+	}
+
+	viewer.frames <- codec.WithStartCode(highSPS)
+	viewer.frames <- codec.WithStartCode(pps)
+	viewer.frames <- codec.WithStartCode(idr)
+	viewer.frames <- codec.WithStartCode(slice)
+	sample(highSPS, pps, idr)
+
+	// the keyframe of the low quality comes without parameter sets, the cached ones of the low quality are sent with it
+	viewer.ChangeWantedQuality(false)
+	atomic.StoreUint32(&p.restart, 1)
+	viewer.frames <- codec.WithStartCode(idr)
+	viewer.frames <- codec.WithStartCode(slice)
+	sample(lowSPS, pps, idr)
+}
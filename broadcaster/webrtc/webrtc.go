@@ -0,0 +1,261 @@
+package broadcaster
+
+import (
+	"StreamingServer/broadcaster"
+	"StreamingServer/consts"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	sdpType = "application/sdp"
+
+	// defaultProfileLevelID is offered when the SPS of the stream is not known yet (constrained baseline 3.1)
+	defaultProfileLevelID = "42e01f"
+)
+
+// Config holds the settings of the peer connections
+type Config struct {
+	// ICEServers are STUN or TURN urls, e.g. stun:stun.l.google.com:19302, the server only offers its own addresses without them
+	ICEServers []string
+}
+
+// Server answers WHEP offers with a peer connection per viewer that is sent an H.264 stream of the broadcaster
+type Server struct {
+	broadcaster *broadcaster.Broadcaster
+	api         *webrtc.API
+	config      Config
+	streamPath  string
+	sessionPath string
+	peers       map[string]*peer
+	sync.Mutex
+}
+
+// NewServer creates a WHEP server that takes offers on <streamPath><streamID> and ends sessions on <sessionPath><session>
+func NewServer(bc *broadcaster.Broadcaster, streamPath, sessionPath string, config Config) (*Server, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	// the default interceptors answer NACKs and send the sender reports
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptors); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		broadcaster: bc,
+		api:         webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(interceptors)),
+		config:      config,
+		streamPath:  streamPath,
+		sessionPath: sessionPath,
+		peers:       make(map[string]*peer),
+	}, nil
+}
+
+// negotiationError is returned by Answer if the offer cannot be answered, e.g. because its SDP is malformed
+type negotiationError struct {
+	err error
+}
+
+func (e negotiationError) Error() string {
+	return "Unable to answer the offer: " + e.err.Error()
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Answer creates the peer connection of a viewer of the stream from its SDP offer.
+// It returns the SDP answer with all ICE candidates and the session id.
+func (s *Server) Answer(streamID, offer string) (string, string, error) {
+	stream, err := s.broadcaster.GetStream(streamID)
+	if err != nil {
+		return "", "", err
+	}
+	if stream.GetType() != consts.StreamH264 {
+		return "", "", fmt.Errorf("stream %s is %s, only h264 streams are sent over webrtc", streamID, stream.GetType())
+	}
+
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", "", err
+	}
+
+	// the viewer is sent the high quality first, the qualities may be encoded with different profiles
+	metadata := stream.GetMetadata()
+	rendition, ok := metadata.Renditions[consts.HighQuality]
+	if !ok {
+		rendition.Codec = metadata.Codec
+	}
+	profileLevelID := defaultProfileLevelID
+	if codecs := rendition.Codec["codecs"]; strings.HasPrefix(codecs, "avc1.") {
+		profileLevelID = strings.ToLower(strings.TrimPrefix(codecs, "avc1."))
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profileLevelID,
+	}, "video", streamID)
+	if err != nil {
+		return "", "", err
+	}
+
+	var iceServers []webrtc.ICEServer
+	if len(s.config.ICEServers) > 0 {
+		iceServers = append(iceServers, webrtc.ICEServer{URLs: s.config.ICEServers})
+	}
+	conn, err := s.api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return "", "", err
+	}
+
+	answer, sender, err := s.negotiate(conn, track, offer)
+	if err != nil {
+		conn.Close()
+		return "", "", negotiationError{err}
+	}
+
+	client, err := s.broadcaster.AddClientStream("webrtc-"+sessionID, streamID)
+	if err != nil {
+		conn.Close()
+		return "", "", err
+	}
+
+	// looked up when the viewer changes quality, the stream caches the parameter sets of each quality
+	parameterSets := func(quality consts.Quality) [][]byte {
+		return stream.GetMetadata().Renditions[quality].ParameterSets
+	}
+	p := &peer{
+		sessionID:     sessionID,
+		streamID:      streamID,
+		client:        client,
+		conn:          conn,
+		track:         track,
+		sender:        sender,
+		closed:        make(chan struct{}),
+		parameterSets: parameterSets,
+	}
+	conn.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		fmt.Println("Webrtc session", sessionID, "of stream", streamID, "is", state)
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			p.close(s.removePeer)
+		}
+	})
+
+	s.Lock()
+	s.peers[sessionID] = p
+	s.Unlock()
+
+	go p.sendFrames(s.removePeer)
+	go p.readReports()
+	return answer, sessionID, nil
+}
+
+// negotiate answers the offer with the track and waits until all ICE candidates are gathered
+func (s *Server) negotiate(conn *webrtc.PeerConnection, track webrtc.TrackLocal, offer string) (string, *webrtc.RTPSender, error) {
+	sender, err := conn.AddTrack(track)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = conn.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer})
+	if err != nil {
+		return "", nil, err
+	}
+
+	answer, err := conn.CreateAnswer(nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(conn)
+	if err = conn.SetLocalDescription(answer); err != nil {
+		return "", nil, err
+	}
+	<-gathered
+
+	return conn.LocalDescription().SDP, sender, nil
+}
+
+func (s *Server) removePeer(p *peer) {
+	s.Lock()
+	delete(s.peers, p.sessionID)
+	s.Unlock()
+}
+
+// CloseSession ends the session of a viewer
+func (s *Server) CloseSession(sessionID string) error {
+	s.Lock()
+	p, ok := s.peers[sessionID]
+	s.Unlock()
+	if !ok {
+		return fmt.Errorf("No webrtc session %s", sessionID)
+	}
+
+	p.close(s.removePeer)
+	return nil
+}
+
+// HandleOffer answers a WHEP offer that is POSTed to <streamPath><streamID>
+func (s *Server) HandleOffer(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(writer, "POST an sdp offer to "+s.streamPath+"<stream>", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), sdpType) {
+		http.Error(writer, "the offer must be sent as "+sdpType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	answer, sessionID, err := s.Answer(strings.TrimPrefix(req.URL.Path, s.streamPath), string(offer))
+	if _, ok := err.(negotiationError); ok {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("Content-Type", sdpType)
+	writer.Header().Set("Location", s.sessionPath+sessionID)
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write([]byte(answer))
+}
+
+// HandleSession ends a session on DELETE <sessionPath><session>, trickle ICE is not supported
+func (s *Server) HandleSession(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(writer, "only DELETE is supported, the answer holds all ICE candidates", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.CloseSession(strings.TrimPrefix(req.URL.Path, s.sessionPath))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,196 @@
+package broadcaster
+
+import (
+	"StreamingServer/broadcaster"
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	_ "StreamingServer/streamtype/builtin"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+type fakeConsumer struct {
+	streams map[string]consumer.StreamConnection
+}
+
+func (fc *fakeConsumer) Start() error { return nil }
+func (fc *fakeConsumer) Stop() error  { return nil }
+func (fc *fakeConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	stream, ok := fc.streams[streamID]
+	if !ok {
+		return nil, fmt.Errorf("No stream %s", streamID)
+	}
+	return stream, nil
+}
+
+// fakeStream is an H.264 stream with a channel per quality
+type fakeStream struct {
+	consumer.StreamConnection
+	frames map[consts.Quality]chan []byte
+}
+
+func (fs *fakeStream) GetType() consts.StreamType { return consts.StreamH264 }
+func (fs *fakeStream) IsOpen() bool               { return true }
+func (fs *fakeStream) GetMetadata() consumer.StreamMetadata {
+	return consumer.StreamMetadata{StreamType: consts.StreamH264}
+}
+func (fs *fakeStream) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	return fs.frames[quality], nil
+}
+
+// publish sends a keyframe every ten frames to every quality until stop is closed
+func (fs *fakeStream) publish(stop chan struct{}) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0xe0, 0x08, 0x9f, 0x96, 0x10, 0, 0, 3, 0, 0x10, 0, 0, 3, 3, 0x20, 0xf1, 0x83, 0x1a, 0x80}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := append([]byte{0x65, 0x88}, bytes.Repeat([]byte{1}, 3000)...)
+	slice := []byte{0x41, 0x9a, 0x02, 0x03}
+
+	for quality, frames := range fs.frames {
+		go func(quality consts.Quality, frames chan []byte) {
+			for i := 0; ; i++ {
+				nals := [][]byte{slice}
+				if i%10 == 0 {
+					nals = [][]byte{sps, pps, idr}
+				}
+				for _, nal := range nals {
+					select {
+					case frames <- codec.WithStartCode(nal):
+					case <-stop:
+						return
+					}
+				}
+				time.Sleep(time.Second / 30)
+			}
+		}(quality, frames)
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeStream) {
+	t.Helper()
+
+	stream := &fakeStream{frames: map[consts.Quality]chan []byte{
+		consts.LowQuality:  make(chan []byte),
+		consts.HighQuality: make(chan []byte),
+	}}
+	bc := broadcaster.NewBroadcaster(&fakeConsumer{map[string]consumer.StreamConnection{"front_door": stream}})
+	server, err := NewServer(bc, "/whep/", "/api/whep/", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, stream
+}
+
+func TestAnswerSendsH264AndLowersQualityOnLoss(t *testing.T) {
+	server, stream := newTestServer(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	stream.publish(stop)
+
+	viewer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viewer.Close()
+
+	_, err = viewer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := make(chan *webrtc.TrackRemote, 1)
+	viewer.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		tracks <- track
+	})
+
+	offer, err := viewer.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(viewer)
+	if err = viewer.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	answer, sessionID, err := server.Answer("front_door", viewer.LocalDescription().SDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseSession(sessionID)
+	if err = viewer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	var track *webrtc.TrackRemote
+	select {
+	case track = <-tracks:
+	case <-time.After(15 * time.Second):
+		t.Fatal("viewer got no track")
+	}
+	if track.Codec().MimeType != webrtc.MimeTypeH264 {
+		t.Fatalf("track is %s", track.Codec().MimeType)
+	}
+
+	// the viewer starts at a keyframe, whose first packet is its SPS or the aggregate of its parameter sets
+	packet, _, err := track.ReadRTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet.Payload) == 0 {
+		t.Fatal("empty rtp payload")
+	}
+	if nalType := packet.Payload[0] & 0x1f; nalType != 7 && nalType != 24 {
+		t.Errorf("first packet has nal type %d, want the SPS or a STAP-A", nalType)
+	}
+
+	server.Lock()
+	p := server.peers[sessionID]
+	server.Unlock()
+	if quality := p.client.GetWantedQuality(); quality != consts.HighQuality {
+		t.Fatalf("viewer starts with quality %d", quality)
+	}
+
+	// half of the packets were lost
+	report := &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{{SSRC: uint32(track.SSRC()), FractionLost: 128}}}
+	if err = viewer.WriteRTCP([]rtcp.Packet{report}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.client.GetWantedQuality() != consts.LowQuality {
+		if time.Now().After(deadline) {
+			t.Fatal("quality was not lowered after the lossy receiver report")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleOfferStatus(t *testing.T) {
+	server, _ := newTestServer(t)
+	handler := httptest.NewServer(http.HandlerFunc(server.HandleOffer))
+	defer handler.Close()
+
+	for path, want := range map[string]int{
+		"/whep/front_door": http.StatusBadRequest,
+		"/whep/back_door":  http.StatusNotFound,
+	} {
+		resp, err := http.Post(handler.URL+path, sdpType, strings.NewReader("not an sdp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != want {
+			t.Errorf("offer to %s got status %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package codec
+
+import "bytes"
+
+var (
+	// NALStartCode is the 4 byte Annex-B start code that is put in front of every forwarded NAL unit
+	NALStartCode = []byte{0, 0, 0, 1}
+
+	shortStartCode = []byte{0, 0, 1}
+)
+
+// SplitAnnexB splits an Annex-B byte stream into its NAL units.
+// The returned NAL units do not include the start codes.
+func SplitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for {
+		index := bytes.Index(data, shortStartCode)
+		if index < 0 {
+			break
+		}
+
+		if start >= 0 {
+			// a 4 byte start code leaves a trailing zero on the previous NAL
+			nals = append(nals, bytes.TrimRight(data[:index], "\x00"))
+		}
+
+		data = data[index+len(shortStartCode):]
+		start = 0
+	}
+
+	if start >= 0 && len(data) > 0 {
+		nals = append(nals, data)
+	}
+
+	return nals
+}
+
+// WithStartCode returns a copy of the NAL unit prefixed with the 4 byte start code
+func WithStartCode(nal []byte) []byte {
+	return append(append(make([]byte, 0, len(NALStartCode)+len(nal)), NALStartCode...), nal...)
+}
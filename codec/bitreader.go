@@ -0,0 +1,102 @@
+package codec
+
+import "fmt"
+
+// RemoveEmulationPrevention strips the 0x03 bytes that the encoder inserted after two zero bytes
+func RemoveEmulationPrevention(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+
+	return rbsp
+}
+
+// bitReader reads the bit fields and exp-golomb codes of a raw byte sequence payload
+type bitReader struct {
+	data   []byte
+	offset int
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (br *bitReader) readBits(n int) (uint32, error) {
+	var val uint32
+	for i := 0; i < n; i++ {
+		if br.offset >= len(br.data)*8 {
+			return 0, fmt.Errorf("bit reader reached end of data at bit %d", br.offset)
+		}
+
+		bit := (br.data[br.offset/8] >> uint(7-br.offset%8)) & 1
+		val = val<<1 | uint32(bit)
+		br.offset++
+	}
+
+	return val, nil
+}
+
+func (br *bitReader) readFlag() (bool, error) {
+	bit, err := br.readBits(1)
+	return bit == 1, err
+}
+
+func (br *bitReader) skipBits(n int) error {
+	if br.offset+n > len(br.data)*8 {
+		return fmt.Errorf("bit reader cannot skip %d bits at bit %d", n, br.offset)
+	}
+
+	br.offset += n
+	return nil
+}
+
+// readUE reads an unsigned exp-golomb code
+func (br *bitReader) readUE() (uint32, error) {
+	leadingZeros := 0
+	for {
+		bit, err := br.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+
+		leadingZeros++
+		if leadingZeros > 31 {
+			return 0, fmt.Errorf("invalid exp-golomb code")
+		}
+	}
+
+	suffix, err := br.readBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+
+	return (1 << uint(leadingZeros)) - 1 + suffix, nil
+}
+
+// readSE reads a signed exp-golomb code
+func (br *bitReader) readSE() (int32, error) {
+	val, err := br.readUE()
+	if err != nil {
+		return 0, err
+	}
+
+	if val%2 == 0 {
+		return -int32(val / 2), nil
+	}
+	return int32(val+1) / 2, nil
+}
@@ -0,0 +1,128 @@
+package codec
+
+import "bytes"
+
+// H.264 NAL unit types (ITU-T H.264 table 7-1) that the detection relies on
+const (
+	H264NALIDR = 5
+	H264NALSEI = 6
+	H264NALSPS = 7
+	H264NALPPS = 8
+	H264NALAUD = 9
+)
+
+var (
+	jpegSOI = []byte{0xff, 0xd8}
+	jpegEOI = []byte{0xff, 0xd9}
+)
+
+// LooksLikeJPEG reports whether the data starts with a JPEG SOI marker followed by another marker.
+// If the data is a whole frame it must also end with the EOI marker.
+func LooksLikeJPEG(data []byte, wholeFrame bool) bool {
+	if len(data) < 4 || !bytes.HasPrefix(data, jpegSOI) || data[2] != 0xff || data[3] < 0xc0 || data[3] == 0xff {
+		return false
+	}
+
+	if wholeFrame {
+		// some encoders pad the frame with zeros after the EOI marker
+		return bytes.HasSuffix(bytes.TrimRight(data, "\x00"), jpegEOI)
+	}
+
+	return true
+}
+
+// LooksLikeMPEGTS reports whether the data starts with MPEG-TS packets, every whole packet must begin with a sync byte
+func LooksLikeMPEGTS(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	for offset := 0; offset < len(data); offset += TSPacketSize {
+		if data[offset] != TSSyncByte {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LooksLikeH264 reports whether the data is an Annex-B byte stream of valid H.264 NAL unit headers
+func LooksLikeH264(data []byte, wholeFrame bool) bool {
+	nals := sniffNALs(data, wholeFrame)
+	if len(nals) == 0 || !allNALs(nals, validH264NALHeader) {
+		return false
+	}
+
+	// the HEVC headers that also pass as H.264 start with a parameter set or an access unit delimiter
+	return !allNALs(nals, validHEVCNALHeader) || !startsHEVCAccessUnit(nals[0])
+}
+
+// LooksLikeH265 reports whether the data is an Annex-B byte stream of valid HEVC NAL unit headers
+func LooksLikeH265(data []byte, wholeFrame bool) bool {
+	nals := sniffNALs(data, wholeFrame)
+	if len(nals) == 0 || !allNALs(nals, validHEVCNALHeader) {
+		return false
+	}
+
+	return !allNALs(nals, validH264NALHeader) || startsHEVCAccessUnit(nals[0])
+}
+
+// sniffNALs returns the NAL units of data that starts with a start code.
+// The last NAL unit of a partial sample is dropped if its header was cut off.
+func sniffNALs(data []byte, wholeFrame bool) [][]byte {
+	if !bytes.HasPrefix(data, shortStartCode) && !bytes.HasPrefix(data, NALStartCode) {
+		return nil
+	}
+
+	nals := SplitAnnexB(data)
+	if !wholeFrame && len(nals) > 0 && len(nals[len(nals)-1]) < 2 {
+		nals = nals[:len(nals)-1]
+	}
+
+	return nals
+}
+
+func allNALs(nals [][]byte, valid func(nal []byte) bool) bool {
+	for _, nal := range nals {
+		if !valid(nal) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validH264NALHeader checks the forbidden bit, the type and the nal_ref_idc rules of ITU-T H.264 7.4.1
+func validH264NALHeader(nal []byte) bool {
+	if len(nal) == 0 || nal[0]&0x80 != 0 {
+		return false
+	}
+
+	refIDC := nal[0] >> 5 & 0x03
+	nalType := nal[0] & 0x1f
+	switch {
+	case nalType == 0 || nalType > 23:
+		return false
+	case nalType == H264NALIDR || nalType == H264NALSPS || nalType == H264NALPPS:
+		return refIDC != 0
+	case nalType == H264NALSEI || (nalType >= H264NALAUD && nalType <= 12):
+		return refIDC == 0
+	}
+
+	return true
+}
+
+// validHEVCNALHeader checks the forbidden bit, the layer, the temporal id and the type of ITU-T H.265 7.4.2.2
+func validHEVCNALHeader(nal []byte) bool {
+	header, err := ParseHEVCNALHeader(nal)
+	if err != nil || header.ForbiddenBit || header.LayerID != 0 {
+		return false
+	}
+
+	return header.Type <= 9 || (header.Type >= HEVCNALBLAWLP && header.Type <= 21) || (header.Type >= HEVCNALVPS && header.Type <= 40)
+}
+
+func startsHEVCAccessUnit(nal []byte) bool {
+	header, err := ParseHEVCNALHeader(nal)
+	return err == nil && (header.IsParameterSet() || header.Type == HEVCNALAUD)
+}
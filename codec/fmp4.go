@@ -0,0 +1,179 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// FMP4Timescale is the number of time units per second of fragmented MP4 tracks, the same clock as RTP and MPEG-TS
+const FMP4Timescale = 90000
+
+const (
+	fmp4TrackID = 1
+
+	// sample flags of ISO/IEC 14496-12 8.8.3.1
+	fmp4SyncSampleFlags    = 0x02000000
+	fmp4NonSyncSampleFlags = 0x01010000
+)
+
+// FMP4Sample is an access unit in AVCC form, see AVCCFromNALs
+type FMP4Sample struct {
+	Data     []byte
+	Duration uint32
+	Keyframe bool
+}
+
+// AVCCFromNALs writes the NAL units with 4 byte length prefixes instead of start codes
+func AVCCFromNALs(nals [][]byte) []byte {
+	var data []byte
+	for _, nal := range nals {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(nal)))
+		data = append(data, size[:]...)
+		data = append(data, nal...)
+	}
+
+	return data
+}
+
+// FMP4Init returns the initialization segment (ftyp and moov) of a single H.264 track
+func FMP4Init(sps, pps []byte) ([]byte, error) {
+	info, err := ParseH264SPS(sps)
+	if err != nil {
+		return nil, err
+	}
+	if len(pps) == 0 {
+		return nil, fmt.Errorf("h264 pps is missing")
+	}
+
+	ftyp := mp4Box("ftyp", []byte("iso5"), u32(512), []byte("iso5iso6avc1mp41"))
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation and modification time
+		u32(1000), u32(0), // timescale and duration
+		u32(0x00010000), u16(0x0100), make([]byte, 10), // rate, volume, reserved
+		mp4Matrix(),
+		make([]byte, 24), // pre_defined
+		u32(fmp4TrackID+1),
+	)
+
+	tkhd := mp4FullBox("tkhd", 0, 0x000003, // enabled and in movie
+		u32(0), u32(0), u32(fmp4TrackID), u32(0), u32(0), // times, track id, reserved, duration
+		make([]byte, 8), u16(0), u16(0), u16(0), u16(0), // reserved, layer, group, volume, reserved
+		mp4Matrix(),
+		u32(uint32(info.Width)<<16), u32(uint32(info.Height)<<16),
+	)
+
+	mdhd := mp4FullBox("mdhd", 0, 0, u32(0), u32(0), u32(FMP4Timescale), u32(0), u16(0x55c4), u16(0)) // language und
+	hdlr := mp4FullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+
+	avcC := mp4Box("avcC",
+		[]byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1}, // version, profile, compatibility, level, 4 byte lengths, 1 sps
+		u16(uint16(len(sps))), sps,
+		[]byte{1}, u16(uint16(len(pps))), pps,
+	)
+	avc1 := mp4Box("avc1",
+		make([]byte, 6), u16(1), // reserved, data_reference_index
+		make([]byte, 16), // pre_defined and reserved
+		u16(uint16(info.Width)), u16(uint16(info.Height)),
+		u32(0x00480000), u32(0x00480000), u32(0), u16(1), // resolution, reserved, frame_count
+		make([]byte, 32), u16(0x0018), u16(0xffff), // compressorname, depth, pre_defined
+		avcC,
+	)
+
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, u32(1), avc1),
+		mp4FullBox("stts", 0, 0, u32(0)),
+		mp4FullBox("stsc", 0, 0, u32(0)),
+		mp4FullBox("stsz", 0, 0, u32(0), u32(0)),
+		mp4FullBox("stco", 0, 0, u32(0)),
+	)
+	minf := mp4Box("minf",
+		mp4FullBox("vmhd", 0, 1, make([]byte, 8)),
+		mp4Box("dinf", mp4FullBox("dref", 0, 0, u32(1), mp4FullBox("url ", 0, 1))),
+		stbl,
+	)
+
+	trak := mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf))
+	mvex := mp4Box("mvex", mp4FullBox("trex", 0, 0, u32(fmp4TrackID), u32(1), u32(0), u32(0), u32(0)))
+
+	return append(ftyp, mp4Box("moov", mvhd, trak, mvex)...), nil
+}
+
+// FMP4Fragment returns a moof and mdat with the samples, baseTime is the decode time of the first sample
+func FMP4Fragment(sequence uint32, baseTime uint64, samples []FMP4Sample) []byte {
+	var entries, mdat []byte
+	for _, sample := range samples {
+		flags := uint32(fmp4NonSyncSampleFlags)
+		if sample.Keyframe {
+			flags = fmp4SyncSampleFlags
+		}
+		entries = append(entries, u32(sample.Duration)...)
+		entries = append(entries, u32(uint32(len(sample.Data)))...)
+		entries = append(entries, u32(flags)...)
+		mdat = append(mdat, sample.Data...)
+	}
+
+	var baseTimeBytes [8]byte
+	binary.BigEndian.PutUint64(baseTimeBytes[:], baseTime)
+
+	// the data offset is patched once the size of the moof is known
+	trun := mp4FullBox("trun", 0, 0x000701, u32(uint32(len(samples))), u32(0), entries)
+	moof := mp4Box("moof",
+		mp4FullBox("mfhd", 0, 0, u32(sequence)),
+		mp4Box("traf",
+			mp4FullBox("tfhd", 0, 0x020000, u32(fmp4TrackID)), // default-base-is-moof
+			mp4FullBox("tfdt", 1, 0, baseTimeBytes[:]),
+			trun,
+		),
+	)
+
+	// trun is the last box of the moof, its data offset follows the full box header and the sample count
+	dataOffsetPosition := len(moof) - len(trun) + 16
+	binary.BigEndian.PutUint32(moof[dataOffsetPosition:], uint32(len(moof)+8))
+
+	return append(moof, mp4Box("mdat", mdat)...)
+}
+
+func mp4Box(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	box := make([]byte, 0, size)
+	box = append(box, u32(uint32(size))...)
+	box = append(box, boxType...)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+
+	return box
+}
+
+func mp4FullBox(boxType string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, payloads...)...)
+}
+
+// mp4Matrix is the identity transformation of mvhd and tkhd
+func mp4Matrix() []byte {
+	var matrix []byte
+	for _, value := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		matrix = append(matrix, u32(value)...)
+	}
+
+	return matrix
+}
+
+func u32(value uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], value)
+	return b[:]
+}
+
+func u16(value uint16) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], value)
+	return b[:]
+}
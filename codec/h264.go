@@ -0,0 +1,362 @@
+package codec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// H264NALSlice is the NAL unit type of the slices of non IDR pictures
+const H264NALSlice = 1
+
+// H264NALType returns the type of a NAL unit without start code
+func H264NALType(nal []byte) int {
+	if len(nal) == 0 {
+		return 0
+	}
+	return int(nal[0] & 0x1f)
+}
+
+// H264ParameterSets holds the last SPS and PPS of an H.264 stream
+type H264ParameterSets struct {
+	SPS []byte
+	PPS []byte
+}
+
+// Observe caches the NAL unit if it is a parameter set.
+// It returns true if the NAL unit was one.
+func (ps *H264ParameterSets) Observe(nal []byte) bool {
+	nalCopy := append([]byte(nil), nal...)
+	switch H264NALType(nal) {
+	case H264NALSPS:
+		ps.SPS = nalCopy
+	case H264NALPPS:
+		ps.PPS = nalCopy
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Complete reports whether both parameter sets have been seen
+func (ps *H264ParameterSets) Complete() bool {
+	return ps.SPS != nil && ps.PPS != nil
+}
+
+// H264SPSInfo holds the codec parameters that can be read from a sequence parameter set
+type H264SPSInfo struct {
+	ProfileIDC      int
+	ConstraintFlags int
+	LevelIDC        int
+	ChromaFormatIDC int
+	Width           int
+	Height          int
+}
+
+// profiles whose SPS carries the chroma format and bit depths (ITU-T H.264 7.3.2.1.1)
+var h264HighProfiles = map[uint32]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// ParseH264SPS reads the profile, level and picture size from an SPS NAL unit
+func ParseH264SPS(nal []byte) (H264SPSInfo, error) {
+	info := H264SPSInfo{ChromaFormatIDC: 1}
+	if len(nal) < 4 || H264NALType(nal) != H264NALSPS {
+		return info, fmt.Errorf("h264 sps is too short or not an sps")
+	}
+
+	info.ProfileIDC = int(nal[1])
+	info.ConstraintFlags = int(nal[2])
+	info.LevelIDC = int(nal[3])
+
+	br := newBitReader(RemoveEmulationPrevention(nal[4:]))
+	if _, err := br.readUE(); err != nil { // seq_parameter_set_id
+		return info, err
+	}
+
+	frameCropUnitX, frameCropUnitY := 2, 2
+	if h264HighProfiles[uint32(info.ProfileIDC)] {
+		chromaFormatIDC, err := br.readUE()
+		if err != nil {
+			return info, err
+		}
+		info.ChromaFormatIDC = int(chromaFormatIDC)
+
+		if chromaFormatIDC == 3 {
+			if err = br.skipBits(1); err != nil { // separate_colour_plane_flag
+				return info, err
+			}
+		}
+		for i := 0; i < 2; i++ { // bit_depth_luma_minus8, bit_depth_chroma_minus8
+			if _, err = br.readUE(); err != nil {
+				return info, err
+			}
+		}
+		if err = br.skipBits(1); err != nil { // qpprime_y_zero_transform_bypass_flag
+			return info, err
+		}
+
+		scalingMatrixPresent, err := br.readFlag()
+		if err != nil {
+			return info, err
+		}
+		if scalingMatrixPresent {
+			lists := 8
+			if chromaFormatIDC == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				present, err := br.readFlag()
+				if err != nil {
+					return info, err
+				}
+				if !present {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				if err = skipScalingList(br, size); err != nil {
+					return info, err
+				}
+			}
+		}
+
+		switch chromaFormatIDC {
+		case 0:
+			frameCropUnitX, frameCropUnitY = 1, 1
+		case 2:
+			frameCropUnitX, frameCropUnitY = 2, 1
+		case 3:
+			frameCropUnitX, frameCropUnitY = 1, 1
+		}
+	}
+
+	if _, err := br.readUE(); err != nil { // log2_max_frame_num_minus4
+		return info, err
+	}
+	pocType, err := br.readUE()
+	if err != nil {
+		return info, err
+	}
+	switch pocType {
+	case 0:
+		if _, err = br.readUE(); err != nil { // log2_max_pic_order_cnt_lsb_minus4
+			return info, err
+		}
+	case 1:
+		if err = br.skipBits(1); err != nil { // delta_pic_order_always_zero_flag
+			return info, err
+		}
+		for i := 0; i < 2; i++ { // offset_for_non_ref_pic, offset_for_top_to_bottom_field
+			if _, err = br.readSE(); err != nil {
+				return info, err
+			}
+		}
+		cycle, err := br.readUE()
+		if err != nil {
+			return info, err
+		}
+		for i := uint32(0); i < cycle; i++ {
+			if _, err = br.readSE(); err != nil {
+				return info, err
+			}
+		}
+	}
+
+	if _, err = br.readUE(); err != nil { // max_num_ref_frames
+		return info, err
+	}
+	if err = br.skipBits(1); err != nil { // gaps_in_frame_num_value_allowed_flag
+		return info, err
+	}
+
+	widthInMbs, err := br.readUE()
+	if err != nil {
+		return info, err
+	}
+	heightInMapUnits, err := br.readUE()
+	if err != nil {
+		return info, err
+	}
+	frameMbsOnly, err := br.readFlag()
+	if err != nil {
+		return info, err
+	}
+	if !frameMbsOnly {
+		if err = br.skipBits(1); err != nil { // mb_adaptive_frame_field_flag
+			return info, err
+		}
+	}
+	if err = br.skipBits(1); err != nil { // direct_8x8_inference_flag
+		return info, err
+	}
+
+	fieldFactor := 1
+	if !frameMbsOnly {
+		fieldFactor = 2
+	}
+	info.Width = int(widthInMbs+1) * 16
+	info.Height = fieldFactor * int(heightInMapUnits+1) * 16
+
+	cropping, err := br.readFlag()
+	if err != nil {
+		return info, err
+	}
+	if cropping {
+		var crop [4]uint32
+		for i := range crop {
+			if crop[i], err = br.readUE(); err != nil {
+				return info, err
+			}
+		}
+		cropWidth := frameCropUnitX * (int(crop[0]) + int(crop[1]))
+		cropHeight := frameCropUnitY * fieldFactor * (int(crop[2]) + int(crop[3]))
+		if cropWidth >= info.Width || cropHeight >= info.Height {
+			return info, fmt.Errorf("h264 frame cropping of %dx%d is larger than the picture of %dx%d", cropWidth, cropHeight, info.Width, info.Height)
+		}
+		info.Width -= cropWidth
+		info.Height -= cropHeight
+	}
+
+	return info, nil
+}
+
+// skipScalingList reads over a scaling list of the given size (ITU-T H.264 7.3.2.1.1.1)
+func skipScalingList(br *bitReader, size int) error {
+	lastScale, nextScale := int32(8), int32(8)
+	for i := 0; i < size && nextScale != 0; i++ {
+		delta, err := br.readSE()
+		if err != nil {
+			return err
+		}
+		nextScale = (lastScale + delta + 256) % 256
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+
+	return nil
+}
+
+// CodecString returns the RFC 6381 codecs parameter, e.g. avc1.42E01E
+func (info H264SPSInfo) CodecString() string {
+	return fmt.Sprintf("avc1.%02X%02X%02X", info.ProfileIDC, info.ConstraintFlags, info.LevelIDC)
+}
+
+// Parameters returns the codec parameters in the form used by the stream metadata
+func (ps *H264ParameterSets) Parameters() map[string]string {
+	params := make(map[string]string)
+	for name, nal := range map[string][]byte{"sps": ps.SPS, "pps": ps.PPS} {
+		if nal != nil {
+			params[name] = base64.StdEncoding.EncodeToString(nal)
+		}
+	}
+
+	if ps.SPS == nil {
+		return params
+	}
+
+	info, err := ParseH264SPS(ps.SPS)
+	if err != nil {
+		return params
+	}
+
+	params["codecs"] = info.CodecString()
+	params["profile"] = strconv.Itoa(info.ProfileIDC)
+	params["level"] = strconv.Itoa(info.LevelIDC)
+	params["width"] = strconv.Itoa(info.Width)
+	params["height"] = strconv.Itoa(info.Height)
+	return params
+}
+
+// H264Inspector tracks the parameter sets and keyframes of an H.264 stream
+type H264Inspector struct {
+	params H264ParameterSets
+}
+
+// Inspect caches the parameter sets found in the frame and reports whether it holds an IDR slice
+func (hi *H264Inspector) Inspect(frame []byte) bool {
+	keyframe := false
+	for _, nal := range SplitAnnexB(frame) {
+		if hi.params.Observe(nal) {
+			continue
+		}
+		if H264NALType(nal) == H264NALIDR {
+			keyframe = true
+		}
+	}
+
+	return keyframe
+}
+
+func (hi *H264Inspector) Parameters() map[string]string {
+	return hi.params.Parameters()
+}
+
+// ParameterSets returns the cached SPS and PPS with start codes
+func (hi *H264Inspector) ParameterSets() [][]byte {
+	var parameterSets [][]byte
+	for _, nal := range [][]byte{hi.params.SPS, hi.params.PPS} {
+		if nal != nil {
+			parameterSets = append(parameterSets, WithStartCode(nal))
+		}
+	}
+
+	return parameterSets
+}
+
+// H264AccessUnit is the NAL units of one picture, without start codes
+type H264AccessUnit struct {
+	NALs     [][]byte
+	Keyframe bool
+}
+
+// H264AccessUnitAssembler groups the NAL units of an H.264 stream into access units (ITU-T H.264 7.4.1.2.3)
+type H264AccessUnitAssembler struct {
+	current  H264AccessUnit
+	hasSlice bool
+}
+
+// Push adds the NAL units of a frame and returns the access units that are complete
+func (aa *H264AccessUnitAssembler) Push(frame []byte) []H264AccessUnit {
+	var units []H264AccessUnit
+	for _, nal := range SplitAnnexB(frame) {
+		if len(nal) == 0 {
+			continue
+		}
+
+		if aa.hasSlice && startsH264AccessUnit(nal) {
+			units = append(units, aa.current)
+			aa.current = H264AccessUnit{}
+			aa.hasSlice = false
+		}
+
+		nalType := H264NALType(nal)
+		if nalType == H264NALSlice || nalType == H264NALIDR {
+			aa.hasSlice = true
+		}
+		if nalType == H264NALIDR {
+			aa.current.Keyframe = true
+		}
+		aa.current.NALs = append(aa.current.NALs, nal)
+	}
+
+	return units
+}
+
+// startsH264AccessUnit reports whether the NAL unit belongs to the next access unit if the current one has a slice
+func startsH264AccessUnit(nal []byte) bool {
+	switch nalType := H264NALType(nal); {
+	case nalType == H264NALSEI || nalType == H264NALSPS || nalType == H264NALPPS || nalType == H264NALAUD:
+		return true
+	case nalType >= 14 && nalType <= 18:
+		return true
+	case nalType == H264NALSlice || nalType == H264NALIDR:
+		// first_mb_in_slice is 0, which is a single 1 bit in exp-golomb
+		return len(nal) > 1 && nal[1]&0x80 != 0
+	}
+
+	return false
+}
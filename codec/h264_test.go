@@ -0,0 +1,49 @@
+package codec
+
+import "testing"
+
+// h264SPS is a baseline profile level 4.0 sps of a 1920x1088 picture with the given frame cropping
+func h264SPS(crop [4]uint32) []byte {
+	var bw bitWriter
+	bw.writeUE(0)      // seq_parameter_set_id
+	bw.writeUE(0)      // log2_max_frame_num_minus4
+	bw.writeUE(2)      // pic_order_cnt_type
+	bw.writeUE(1)      // max_num_ref_frames
+	bw.writeBits(0, 1) // gaps_in_frame_num_value_allowed_flag
+	bw.writeUE(119)    // pic_width_in_mbs_minus1
+	bw.writeUE(67)     // pic_height_in_map_units_minus1
+	bw.writeBits(1, 1) // frame_mbs_only_flag
+	bw.writeBits(1, 1) // direct_8x8_inference_flag
+	bw.writeBits(1, 1) // frame_cropping_flag
+	for _, offset := range crop {
+		bw.writeUE(offset)
+	}
+	bw.writeBits(0, 1) // vui_parameters_present_flag
+	return bw.nal(0x67, 66, 0xc0, 40)
+}
+
+func TestParseH264SPS(t *testing.T) {
+	info, err := ParseH264SPS(h264SPS([4]uint32{0, 0, 0, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("picture is %dx%d, want 1920x1080", info.Width, info.Height)
+	}
+	if info.ProfileIDC != 66 || info.LevelIDC != 40 {
+		t.Errorf("profile %d level %d, want 66 and 40", info.ProfileIDC, info.LevelIDC)
+	}
+}
+
+func TestParseH264SPSRejectsCropsLargerThanThePicture(t *testing.T) {
+	for _, crop := range [][4]uint32{
+		{480, 480, 0, 0},
+		{0, 0, 0, 544},
+		{0xfffffffe, 0xfffffffe, 0, 0},
+	} {
+		if info, err := ParseH264SPS(h264SPS(crop)); err == nil {
+			t.Errorf("crop %v was accepted as a %dx%d picture", crop, info.Width, info.Height)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package codec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HEVC NAL unit types (ITU-T H.265 table 7-1)
+const (
+	HEVCNALBLAWLP    = 16
+	HEVCNALRSVIRAP23 = 23
+	HEVCNALVPS       = 32
+	HEVCNALSPS       = 33
+	HEVCNALPPS       = 34
+	HEVCNALAUD       = 35
+)
+
+// HEVCNALHeader is the 2 byte header in front of every HEVC NAL unit
+type HEVCNALHeader struct {
+	Type         int
+	LayerID      int
+	TemporalID   int
+	ForbiddenBit bool
+}
+
+// ParseHEVCNALHeader reads the header of a NAL unit without start code
+func ParseHEVCNALHeader(nal []byte) (HEVCNALHeader, error) {
+	if len(nal) < 2 {
+		return HEVCNALHeader{}, fmt.Errorf("hevc nal unit is too short: %d bytes", len(nal))
+	}
+
+	header := HEVCNALHeader{
+		ForbiddenBit: nal[0]&0x80 != 0,
+		Type:         int(nal[0]>>1) & 0x3f,
+		LayerID:      int(nal[0]&0x01)<<5 | int(nal[1]>>3),
+		TemporalID:   int(nal[1]&0x07) - 1,
+	}
+
+	if header.TemporalID < 0 {
+		return header, fmt.Errorf("hevc nal unit has invalid temporal id")
+	}
+
+	return header, nil
+}
+
+// IsIRAP reports whether the NAL unit type is an intra random access point, where decoding can start
+func (h HEVCNALHeader) IsIRAP() bool {
+	return h.Type >= HEVCNALBLAWLP && h.Type <= HEVCNALRSVIRAP23
+}
+
+// IsParameterSet reports whether the NAL unit is a VPS, SPS or PPS
+func (h HEVCNALHeader) IsParameterSet() bool {
+	return h.Type == HEVCNALVPS || h.Type == HEVCNALSPS || h.Type == HEVCNALPPS
+}
+
+// HEVCParameterSets caches the latest VPS, SPS and PPS seen in a stream
+type HEVCParameterSets struct {
+	VPS []byte
+	SPS []byte
+	PPS []byte
+}
+
+// Observe caches the NAL unit if it is a parameter set.
+// It returns true if the NAL unit was one.
+func (ps *HEVCParameterSets) Observe(nal []byte) bool {
+	header, err := ParseHEVCNALHeader(nal)
+	if err != nil {
+		return false
+	}
+
+	nalCopy := append([]byte(nil), nal...)
+	switch header.Type {
+	case HEVCNALVPS:
+		ps.VPS = nalCopy
+	case HEVCNALSPS:
+		ps.SPS = nalCopy
+	case HEVCNALPPS:
+		ps.PPS = nalCopy
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Complete reports whether all three parameter sets have been seen
+func (ps *HEVCParameterSets) Complete() bool {
+	return ps.VPS != nil && ps.SPS != nil && ps.PPS != nil
+}
+
+// HEVCSPSInfo holds the codec parameters that can be read from a sequence parameter set
+type HEVCSPSInfo struct {
+	ProfileSpace    int
+	Tier            int
+	ProfileIDC      int
+	CompatFlags     uint32
+	ConstraintFlags [6]byte
+	LevelIDC        int
+	ChromaFormatIDC int
+	Width           int
+	Height          int
+}
+
+// ParseHEVCSPS reads the profile, tier, level and picture size from an SPS NAL unit
+func ParseHEVCSPS(nal []byte) (HEVCSPSInfo, error) {
+	var info HEVCSPSInfo
+	if len(nal) < 2 {
+		return info, fmt.Errorf("hevc sps is too short")
+	}
+
+	br := newBitReader(RemoveEmulationPrevention(nal[2:]))
+	if err := br.skipBits(4); err != nil { // sps_video_parameter_set_id
+		return info, err
+	}
+	maxSubLayersMinus1, err := br.readBits(3)
+	if err != nil {
+		return info, err
+	}
+	if err = br.skipBits(1); err != nil { // sps_temporal_id_nesting_flag
+		return info, err
+	}
+
+	// general profile_tier_level
+	fields := []*int{&info.ProfileSpace, &info.Tier, &info.ProfileIDC}
+	for i, size := range []int{2, 1, 5} {
+		val, err := br.readBits(size)
+		if err != nil {
+			return info, err
+		}
+		*fields[i] = int(val)
+	}
+	if info.CompatFlags, err = br.readBits(32); err != nil {
+		return info, err
+	}
+	for i := range info.ConstraintFlags {
+		val, err := br.readBits(8)
+		if err != nil {
+			return info, err
+		}
+		info.ConstraintFlags[i] = byte(val)
+	}
+	level, err := br.readBits(8)
+	if err != nil {
+		return info, err
+	}
+	info.LevelIDC = int(level)
+
+	// sub layer profile_tier_level
+	subLayerProfilePresent := make([]bool, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := range subLayerProfilePresent {
+		if subLayerProfilePresent[i], err = br.readFlag(); err != nil {
+			return info, err
+		}
+		if subLayerLevelPresent[i], err = br.readFlag(); err != nil {
+			return info, err
+		}
+	}
+	if maxSubLayersMinus1 > 0 {
+		if err = br.skipBits(2 * (8 - int(maxSubLayersMinus1))); err != nil {
+			return info, err
+		}
+	}
+	for i := range subLayerProfilePresent {
+		if subLayerProfilePresent[i] {
+			if err = br.skipBits(88); err != nil {
+				return info, err
+			}
+		}
+		if subLayerLevelPresent[i] {
+			if err = br.skipBits(8); err != nil {
+				return info, err
+			}
+		}
+	}
+
+	if _, err = br.readUE(); err != nil { // sps_seq_parameter_set_id
+		return info, err
+	}
+	chromaFormat, err := br.readUE()
+	if err != nil {
+		return info, err
+	}
+	info.ChromaFormatIDC = int(chromaFormat)
+	if chromaFormat == 3 {
+		if err = br.skipBits(1); err != nil { // separate_colour_plane_flag
+			return info, err
+		}
+	}
+
+	width, err := br.readUE()
+	if err != nil {
+		return info, err
+	}
+	height, err := br.readUE()
+	if err != nil {
+		return info, err
+	}
+
+	conformanceWindow, err := br.readFlag()
+	if err != nil {
+		return info, err
+	}
+	if conformanceWindow {
+		var offsets [4]uint32
+		for i := range offsets {
+			if offsets[i], err = br.readUE(); err != nil {
+				return info, err
+			}
+		}
+
+		subWidth, subHeight := uint32(1), uint32(1)
+		switch chromaFormat {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+		// the offsets are read as they are, a crafted sps could crop more than the picture has
+		cropWidth := uint64(subWidth) * (uint64(offsets[0]) + uint64(offsets[1]))
+		cropHeight := uint64(subHeight) * (uint64(offsets[2]) + uint64(offsets[3]))
+		if cropWidth >= uint64(width) || cropHeight >= uint64(height) {
+			return info, fmt.Errorf("hevc conformance window crops %dx%d of a %dx%d picture", cropWidth, cropHeight, width, height)
+		}
+		width -= uint32(cropWidth)
+		height -= uint32(cropHeight)
+	}
+
+	info.Width = int(width)
+	info.Height = int(height)
+	return info, nil
+}
+
+// CodecString returns the RFC 6381 codecs parameter, e.g. hvc1.1.6.L93.B0
+func (info HEVCSPSInfo) CodecString() string {
+	var sb strings.Builder
+	sb.WriteString("hvc1.")
+	if info.ProfileSpace > 0 {
+		sb.WriteByte(byte('A' + info.ProfileSpace - 1))
+	}
+	sb.WriteString(strconv.Itoa(info.ProfileIDC))
+
+	// the compatibility flags are written in reverse bit order
+	var reversed uint32
+	for i := uint(0); i < 32; i++ {
+		reversed |= ((info.CompatFlags >> i) & 1) << (31 - i)
+	}
+	sb.WriteString("." + strconv.FormatUint(uint64(reversed), 16))
+
+	tier := "L"
+	if info.Tier == 1 {
+		tier = "H"
+	}
+	sb.WriteString("." + tier + strconv.Itoa(info.LevelIDC))
+
+	// trailing zero constraint bytes are omitted
+	last := len(info.ConstraintFlags) - 1
+	for last >= 0 && info.ConstraintFlags[last] == 0 {
+		last--
+	}
+	for i := 0; i <= last; i++ {
+		sb.WriteString("." + strings.ToUpper(strconv.FormatUint(uint64(info.ConstraintFlags[i]), 16)))
+	}
+
+	return sb.String()
+}
+
+// Parameters returns the codec parameters in the form used by the stream metadata
+func (ps *HEVCParameterSets) Parameters() map[string]string {
+	params := make(map[string]string)
+	for name, nal := range map[string][]byte{"vps": ps.VPS, "sps": ps.SPS, "pps": ps.PPS} {
+		if nal != nil {
+			params[name] = base64.StdEncoding.EncodeToString(nal)
+		}
+	}
+
+	if ps.SPS == nil {
+		return params
+	}
+
+	info, err := ParseHEVCSPS(ps.SPS)
+	if err != nil {
+		return params
+	}
+
+	params["codecs"] = info.CodecString()
+	params["profile"] = strconv.Itoa(info.ProfileIDC)
+	params["tier"] = strconv.Itoa(info.Tier)
+	params["level"] = strconv.Itoa(info.LevelIDC)
+	params["width"] = strconv.Itoa(info.Width)
+	params["height"] = strconv.Itoa(info.Height)
+	return params
+}
+
+// HEVCInspector tracks the parameter sets and keyframes of an HEVC stream
+type HEVCInspector struct {
+	params HEVCParameterSets
+}
+
+// Inspect caches the parameter sets found in the frame and reports whether it holds an IRAP picture
+func (hi *HEVCInspector) Inspect(frame []byte) bool {
+	keyframe := false
+	for _, nal := range SplitAnnexB(frame) {
+		header, err := ParseHEVCNALHeader(nal)
+		if err != nil {
+			continue
+		}
+
+		if header.IsParameterSet() {
+			hi.params.Observe(nal)
+		} else if header.IsIRAP() {
+			keyframe = true
+		}
+	}
+
+	return keyframe
+}
+
+func (hi *HEVCInspector) Parameters() map[string]string {
+	return hi.params.Parameters()
+}
+
+// ParameterSets returns the cached VPS, SPS and PPS with start codes
+func (hi *HEVCInspector) ParameterSets() [][]byte {
+	var parameterSets [][]byte
+	for _, nal := range [][]byte{hi.params.VPS, hi.params.SPS, hi.params.PPS} {
+		if nal != nil {
+			parameterSets = append(parameterSets, WithStartCode(nal))
+		}
+	}
+
+	return parameterSets
+}
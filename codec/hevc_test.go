@@ -0,0 +1,101 @@
+package codec
+
+import "testing"
+
+// bitWriter builds the parameter sets of the tests
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (bw *bitWriter) writeBits(val uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if bw.bits%8 == 0 {
+			bw.data = append(bw.data, 0)
+		}
+		if val>>uint(i)&1 == 1 {
+			bw.data[len(bw.data)-1] |= 1 << uint(7-bw.bits%8)
+		}
+		bw.bits++
+	}
+}
+
+func (bw *bitWriter) writeUE(val uint32) {
+	code := uint64(val) + 1
+	size := 0
+	for code>>uint(size) > 1 {
+		size++
+	}
+	bw.writeBits(0, size)
+	bw.writeBits(code, size+1)
+}
+
+// nal returns the header followed by the payload with a stop bit and emulation prevention
+func (bw *bitWriter) nal(header ...byte) []byte {
+	bw.writeBits(1, 1)
+	nal := append([]byte{}, header...)
+	zeros := 0
+	for _, b := range bw.data {
+		if zeros >= 2 && b <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		nal = append(nal, b)
+	}
+	return nal
+}
+
+// hevcSPS is a main profile level 3.1 sps of a 1920x1088 picture with the given conformance window
+func hevcSPS(crop [4]uint32) []byte {
+	var bw bitWriter
+	bw.writeBits(0, 4)           // sps_video_parameter_set_id
+	bw.writeBits(0, 3)           // sps_max_sub_layers_minus1
+	bw.writeBits(1, 1)           // sps_temporal_id_nesting_flag
+	bw.writeBits(0, 2)           // general_profile_space
+	bw.writeBits(0, 1)           // general_tier_flag
+	bw.writeBits(1, 5)           // general_profile_idc
+	bw.writeBits(0x60000000, 32) // general_profile_compatibility_flags
+	bw.writeBits(0xb0, 8)        // progressive, interlaced, non packed and frame only constraint flags
+	bw.writeBits(0, 40)
+	bw.writeBits(93, 8) // general_level_idc
+	bw.writeUE(0)       // sps_seq_parameter_set_id
+	bw.writeUE(1)       // chroma_format_idc 4:2:0
+	bw.writeUE(1920)
+	bw.writeUE(1088)
+	bw.writeBits(1, 1) // conformance_window_flag
+	for _, offset := range crop {
+		bw.writeUE(offset)
+	}
+	return bw.nal(0x42, 0x01)
+}
+
+func TestParseHEVCSPS(t *testing.T) {
+	info, err := ParseHEVCSPS(hevcSPS([4]uint32{0, 0, 0, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("picture is %dx%d, want 1920x1080", info.Width, info.Height)
+	}
+	if codecs := info.CodecString(); codecs != "hvc1.1.6.L93.B0" {
+		t.Errorf("codecs is %s, want hvc1.1.6.L93.B0", codecs)
+	}
+}
+
+func TestParseHEVCSPSRejectsCropsLargerThanThePicture(t *testing.T) {
+	for _, crop := range [][4]uint32{
+		{600, 600, 0, 0},
+		{0, 0, 544, 0},
+		{0xfffffffe, 0, 0, 0},
+	} {
+		if info, err := ParseHEVCSPS(hevcSPS(crop)); err == nil {
+			t.Errorf("crop %v was accepted as a %dx%d picture", crop, info.Width, info.Height)
+		}
+	}
+}
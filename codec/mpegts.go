@@ -0,0 +1,296 @@
+package codec
+
+import (
+	"fmt"
+)
+
+const (
+	TSPacketSize = 188
+	TSSyncByte   = 0x47
+
+	tsPIDPAT  = 0x0000
+	tsPIDNull = 0x1fff
+)
+
+// Elementary stream types used in the PMT
+const (
+	TSStreamTypeMPEG1Audio = 0x03
+	TSStreamTypeMPEG2Audio = 0x04
+	TSStreamTypeAAC        = 0x0f
+	TSStreamTypeH264       = 0x1b
+	TSStreamTypeH265       = 0x24
+)
+
+// TSElementaryFrame is the payload of one PES packet
+type TSElementaryFrame struct {
+	PID        uint16
+	StreamType byte
+	// PTS in 90kHz units, -1 if the PES packet had none
+	PTS  int64
+	Data []byte
+}
+
+type tsPESBuffer struct {
+	streamType byte
+	data       []byte
+	started    bool
+}
+
+// TSDemuxerStats counts the problems the demuxer found in the transport stream
+type TSDemuxerStats struct {
+	Packets          uint64
+	ContinuityErrors uint64
+	TransportErrors  uint64
+	SyncErrors       uint64
+}
+
+// TSDemuxer follows the PAT and PMT of a transport stream and reassembles the PES packets
+// of the elementary streams of the first program
+type TSDemuxer struct {
+	pmtPID     uint16
+	streams    map[uint16]*tsPESBuffer
+	continuity map[uint16]byte
+	Stats      TSDemuxerStats
+}
+
+func NewTSDemuxer() *TSDemuxer {
+	return &TSDemuxer{
+		streams:    make(map[uint16]*tsPESBuffer),
+		continuity: make(map[uint16]byte),
+	}
+}
+
+// StreamTypes returns the elementary stream types announced in the PMT by PID
+func (d *TSDemuxer) StreamTypes() map[uint16]byte {
+	types := make(map[uint16]byte)
+	for pid, stream := range d.streams {
+		types[pid] = stream.streamType
+	}
+	return types
+}
+
+// Feed demuxes a buffer of whole transport stream packets and returns the PES packets that were completed
+func (d *TSDemuxer) Feed(data []byte) []TSElementaryFrame {
+	var frames []TSElementaryFrame
+	for len(data) >= TSPacketSize {
+		frame, err := d.feedPacket(data[:TSPacketSize])
+		if err == nil && frame != nil {
+			frames = append(frames, *frame)
+		}
+		data = data[TSPacketSize:]
+	}
+
+	return frames
+}
+
+func (d *TSDemuxer) feedPacket(packet []byte) (*TSElementaryFrame, error) {
+	d.Stats.Packets++
+	if packet[0] != TSSyncByte {
+		d.Stats.SyncErrors++
+		return nil, fmt.Errorf("ts packet does not start with sync byte")
+	}
+
+	if packet[1]&0x80 != 0 {
+		d.Stats.TransportErrors++
+		return nil, fmt.Errorf("ts packet has transport error indicator set")
+	}
+
+	payloadStart := packet[1]&0x40 != 0
+	pid := uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+	adaptationField := packet[3]&0x20 != 0
+	hasPayload := packet[3]&0x10 != 0
+	continuityCounter := packet[3] & 0x0f
+
+	if pid == tsPIDNull || !hasPayload {
+		return nil, nil
+	}
+
+	discontinuity := false
+	payload := packet[4:]
+	if adaptationField {
+		length := int(payload[0])
+		if length+1 > len(payload) {
+			return nil, fmt.Errorf("ts adaptation field too long")
+		}
+		if length > 0 {
+			discontinuity = payload[1]&0x80 != 0
+		}
+		payload = payload[length+1:]
+	}
+
+	if last, seen := d.continuity[pid]; seen && !discontinuity {
+		if continuityCounter == last {
+			// duplicate packet
+			return nil, nil
+		}
+		if continuityCounter != (last+1)&0x0f {
+			d.Stats.ContinuityErrors++
+			if stream, ok := d.streams[pid]; ok {
+				// the PES packet that is being collected is missing data
+				stream.data = stream.data[:0]
+				stream.started = false
+			}
+		}
+	}
+	d.continuity[pid] = continuityCounter
+
+	if pid == tsPIDPAT {
+		return nil, d.parsePAT(payload, payloadStart)
+	}
+
+	if d.pmtPID != tsPIDPAT && pid == d.pmtPID {
+		return nil, d.parsePMT(payload, payloadStart)
+	}
+
+	stream, ok := d.streams[pid]
+	if !ok {
+		return nil, nil
+	}
+
+	var frame *TSElementaryFrame
+	var err error
+	if payloadStart {
+		if stream.started && len(stream.data) > 0 {
+			frame, err = parsePES(pid, stream.streamType, stream.data)
+		}
+		stream.data = stream.data[:0]
+		stream.started = true
+	}
+
+	if !stream.started {
+		return frame, err
+	}
+	stream.data = append(stream.data, payload...)
+
+	// PES packets with a known length can be completed without waiting for the next one
+	if len(stream.data) >= 6 {
+		pesLength := int(stream.data[4])<<8 | int(stream.data[5])
+		if pesLength > 0 && len(stream.data) >= 6+pesLength && frame == nil {
+			frame, err = parsePES(pid, stream.streamType, stream.data[:6+pesLength])
+			stream.data = stream.data[:0]
+			stream.started = false
+		}
+	}
+
+	return frame, err
+}
+
+// sectionData returns the section that starts in the payload, without the pointer field and CRC
+func sectionData(payload []byte, payloadStart bool) ([]byte, error) {
+	if !payloadStart {
+		return nil, fmt.Errorf("sections spanning multiple packets are not supported")
+	}
+
+	pointer := int(payload[0])
+	if pointer+1 >= len(payload) {
+		return nil, fmt.Errorf("invalid section pointer")
+	}
+	payload = payload[pointer+1:]
+
+	if len(payload) < 3 {
+		return nil, fmt.Errorf("section too short")
+	}
+	sectionLength := int(payload[1]&0x0f)<<8 | int(payload[2])
+	if sectionLength < 9 || 3+sectionLength > len(payload) {
+		return nil, fmt.Errorf("invalid section length %d", sectionLength)
+	}
+
+	// skip the crc at the end
+	return payload[:3+sectionLength-4], nil
+}
+
+func (d *TSDemuxer) parsePAT(payload []byte, payloadStart bool) error {
+	section, err := sectionData(payload, payloadStart)
+	if err != nil {
+		return err
+	}
+
+	for programs := section[8:]; len(programs) >= 4; programs = programs[4:] {
+		programNumber := uint16(programs[0])<<8 | uint16(programs[1])
+		if programNumber == 0 {
+			// network information table
+			continue
+		}
+
+		pmtPID := uint16(programs[2]&0x1f)<<8 | uint16(programs[3])
+		d.pmtPID = pmtPID
+		return nil
+	}
+
+	return fmt.Errorf("pat has no program")
+}
+
+func (d *TSDemuxer) parsePMT(payload []byte, payloadStart bool) error {
+	section, err := sectionData(payload, payloadStart)
+	if err != nil {
+		return err
+	}
+
+	if len(section) < 12 {
+		return fmt.Errorf("pmt too short")
+	}
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	if 12+programInfoLength > len(section) {
+		return fmt.Errorf("invalid program info length")
+	}
+
+	streams := make(map[uint16]*tsPESBuffer)
+	for entries := section[12+programInfoLength:]; len(entries) >= 5; {
+		streamType := entries[0]
+		pid := uint16(entries[1]&0x1f)<<8 | uint16(entries[2])
+		infoLength := int(entries[3]&0x0f)<<8 | int(entries[4])
+
+		stream, exists := d.streams[pid]
+		if !exists || stream.streamType != streamType {
+			stream = &tsPESBuffer{streamType: streamType}
+		}
+		streams[pid] = stream
+
+		if 5+infoLength > len(entries) {
+			break
+		}
+		entries = entries[5+infoLength:]
+	}
+
+	d.streams = streams
+	return nil
+}
+
+// parsePES strips the PES header and reads the presentation timestamp
+func parsePES(pid uint16, streamType byte, data []byte) (*TSElementaryFrame, error) {
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return nil, fmt.Errorf("invalid pes start code on pid %d", pid)
+	}
+
+	headerLength := int(data[8])
+	if 9+headerLength > len(data) {
+		return nil, fmt.Errorf("invalid pes header length on pid %d", pid)
+	}
+
+	pts := int64(-1)
+	if data[7]&0x80 != 0 && headerLength >= 5 {
+		pts = int64(data[9]&0x0e)<<29 | int64(data[10])<<22 | int64(data[11]&0xfe)<<14 | int64(data[12])<<7 | int64(data[13])>>1
+	}
+
+	payload := make([]byte, len(data)-9-headerLength)
+	copy(payload, data[9+headerLength:])
+	return &TSElementaryFrame{PID: pid, StreamType: streamType, PTS: pts, Data: payload}, nil
+}
+
+// Flush returns the PES packets that are still being collected, e.g. when the stream ended
+func (d *TSDemuxer) Flush() []TSElementaryFrame {
+	var frames []TSElementaryFrame
+	for pid, stream := range d.streams {
+		if stream.started && len(stream.data) > 0 {
+			frame, err := parsePES(pid, stream.streamType, stream.data)
+			if err == nil {
+				frames = append(frames, *frame)
+			}
+		}
+		stream.data = stream.data[:0]
+		stream.started = false
+	}
+
+	return frames
+}
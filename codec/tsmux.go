@@ -0,0 +1,209 @@
+package codec
+
+const (
+	tsPacketSize   = 188
+	tsPayloadSize  = tsPacketSize - 4
+	tsPATPID       = 0x0000
+	tsPMTPID       = 0x1000
+	tsVideoPID     = 0x0100
+	tsStreamH264   = 0x1b
+	tsVideoPESID   = 0xe0
+	tsProgramID    = 1
+	tsTransportID  = 1
+	tsStuffingByte = 0xff
+
+	// tsPTSDelay puts the presentation times behind the program clock so decoders have time to buffer
+	tsPTSDelay = 9000
+)
+
+// TSMuxer writes H.264 access units as an MPEG transport stream with a single video program
+type TSMuxer struct {
+	continuity map[uint16]byte
+}
+
+// NewTSMuxer creates a muxer, every muxer counts the continuity of its packets on its own
+func NewTSMuxer() *TSMuxer {
+	return &TSMuxer{continuity: make(map[uint16]byte)}
+}
+
+// Tables returns the PAT and PMT packets that every segment starts with
+func (m *TSMuxer) Tables() []byte {
+	pat := []byte{
+		0x00,       // table_id
+		0xb0, 0x0d, // section_syntax_indicator and section_length
+		byte(tsTransportID >> 8), tsTransportID, // transport_stream_id
+		0xc1,       // version 0, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		byte(tsProgramID >> 8), tsProgramID,
+		0xe0 | byte(tsPMTPID>>8), byte(tsPMTPID & 0xff),
+	}
+
+	pmt := []byte{
+		0x02,       // table_id
+		0xb0, 0x12, // section_syntax_indicator and section_length
+		byte(tsProgramID >> 8), tsProgramID,
+		0xc1,       // version 0, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0xe0 | byte(tsVideoPID>>8), byte(tsVideoPID & 0xff), // PCR_PID
+		0xf0, 0x00, // program_info_length
+		tsStreamH264,
+		0xe0 | byte(tsVideoPID>>8), byte(tsVideoPID & 0xff),
+		0xf0, 0x00, // ES_info_length
+	}
+
+	var packets []byte
+	for _, table := range []struct {
+		pid     uint16
+		section []byte
+	}{{tsPATPID, pat}, {tsPMTPID, pmt}} {
+		section := appendCRC32MPEG(table.section)
+		packet := make([]byte, tsPacketSize)
+		for i := range packet {
+			packet[i] = tsStuffingByte
+		}
+		m.writeHeader(packet, table.pid, true, false)
+		packet[4] = 0x00 // pointer_field
+		copy(packet[5:], section)
+		packets = append(packets, packet...)
+	}
+
+	return packets
+}
+
+// AccessUnit returns the packets of the access unit, pts is in 90 kHz units.
+// The NAL units have no start codes, an access unit delimiter is added if the access unit has none.
+func (m *TSMuxer) AccessUnit(nals [][]byte, pts int64, keyframe bool) []byte {
+	var elementary []byte
+	if len(nals) == 0 || H264NALType(nals[0]) != H264NALAUD {
+		elementary = append(elementary, NALStartCode...)
+		elementary = append(elementary, H264NALAUD, 0xf0)
+	}
+	for _, nal := range nals {
+		elementary = append(elementary, NALStartCode...)
+		elementary = append(elementary, nal...)
+	}
+
+	pes := []byte{
+		0x00, 0x00, 0x01, tsVideoPESID,
+		0x00, 0x00, // PES_packet_length 0 is unbounded, which is allowed for video
+		0x80, // marker bits
+		0x80, // PTS only
+		0x05, // PES_header_data_length
+	}
+	pes = append(pes, encodePTS(pts+tsPTSDelay)...)
+	pes = append(pes, elementary...)
+
+	return m.packetize(tsVideoPID, pes, pts, keyframe)
+}
+
+// packetize splits a PES packet into transport packets, the first one carries the PCR
+func (m *TSMuxer) packetize(pid uint16, payload []byte, pcr int64, randomAccess bool) []byte {
+	var packets []byte
+	for first := true; len(payload) > 0; first = false {
+		packet := make([]byte, tsPacketSize)
+
+		// the adaptation field without its length byte
+		var adaptation []byte
+		if first {
+			flags := byte(0x10) // PCR_flag
+			if randomAccess {
+				flags |= 0x40
+			}
+			adaptation = append([]byte{flags}, encodePCR(pcr)...)
+		}
+
+		space := tsPayloadSize
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+		size := len(payload)
+		if size > space {
+			size = space
+		}
+
+		// the last packet is filled up with stuffing bytes in the adaptation field
+		if padding := space - size; padding > 0 {
+			if adaptation == nil {
+				padding--
+				if padding > 0 {
+					adaptation = []byte{0x00}
+					padding--
+				} else {
+					adaptation = []byte{}
+				}
+			}
+			for i := 0; i < padding; i++ {
+				adaptation = append(adaptation, tsStuffingByte)
+			}
+		}
+
+		m.writeHeader(packet, pid, first, adaptation != nil)
+		offset := 4
+		if adaptation != nil {
+			packet[4] = byte(len(adaptation))
+			copy(packet[5:], adaptation)
+			offset += 1 + len(adaptation)
+		}
+		copy(packet[offset:], payload[:size])
+		payload = payload[size:]
+		packets = append(packets, packet...)
+	}
+
+	return packets
+}
+
+func (m *TSMuxer) writeHeader(packet []byte, pid uint16, payloadStart bool, adaptation bool) {
+	packet[0] = 0x47
+	packet[1] = byte(pid>>8) & 0x1f
+	if payloadStart {
+		packet[1] |= 0x40
+	}
+	packet[2] = byte(pid)
+
+	control := byte(0x10)
+	if adaptation {
+		control = 0x30
+	}
+	packet[3] = control | m.continuity[pid]&0x0f
+	m.continuity[pid] = (m.continuity[pid] + 1) & 0x0f
+}
+
+// encodePTS writes a 33 bit time stamp with the '0010' prefix of a PTS without DTS
+func encodePTS(pts int64) []byte {
+	return []byte{
+		0x21 | byte(pts>>29)&0x0e,
+		byte(pts >> 22),
+		byte(pts>>14) | 0x01,
+		byte(pts >> 7),
+		byte(pts<<1) | 0x01,
+	}
+}
+
+// encodePCR writes the 33 bit base of the program clock reference, the extension is 0
+func encodePCR(pcr int64) []byte {
+	return []byte{
+		byte(pcr >> 25),
+		byte(pcr >> 17),
+		byte(pcr >> 9),
+		byte(pcr >> 1),
+		byte(pcr<<7) | 0x7e,
+		0x00,
+	}
+}
+
+// appendCRC32MPEG appends the CRC-32/MPEG-2 checksum of the PSI section
+func appendCRC32MPEG(section []byte) []byte {
+	crc := uint32(0xffffffff)
+	for _, b := range section {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
@@ -0,0 +1,93 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+)
+
+// ValidateJPEG checks the SOI and EOI markers of a JPEG frame and decodes it if fullDecode is set
+func ValidateJPEG(frame []byte, fullDecode bool) error {
+	if !LooksLikeJPEG(frame, false) {
+		return fmt.Errorf("jpeg frame does not start with a SOI marker")
+	}
+
+	if !bytes.HasSuffix(bytes.TrimRight(frame, "\x00"), jpegEOI) {
+		return fmt.Errorf("jpeg frame does not end with an EOI marker")
+	}
+
+	if fullDecode {
+		_, err := jpeg.Decode(bytes.NewReader(frame))
+		if err != nil {
+			return fmt.Errorf("jpeg frame cannot be decoded: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// RepairJPEG ends a truncated JPEG frame with an EOI marker so decoders show the part that was received
+func RepairJPEG(frame []byte) ([]byte, error) {
+	if !LooksLikeJPEG(frame, false) {
+		return nil, fmt.Errorf("jpeg frame without SOI marker cannot be repaired")
+	}
+
+	trimmed := bytes.TrimRight(frame, "\x00")
+	if bytes.HasSuffix(trimmed, jpegEOI) {
+		return trimmed, nil
+	}
+
+	// a marker that was cut after its 0xff byte would swallow the EOI
+	trimmed = bytes.TrimRight(trimmed, "\xff")
+	return append(append(make([]byte, 0, len(trimmed)+len(jpegEOI)), trimmed...), jpegEOI...), nil
+}
+
+// ValidateH264 checks that the frame is an Annex-B byte stream of NAL units with sane headers
+func ValidateH264(frame []byte, _ bool) error {
+	return validateAnnexB(frame, "h264", validH264NALHeader)
+}
+
+// RepairH264 drops the NAL units with broken headers from the frame
+func RepairH264(frame []byte) ([]byte, error) {
+	return repairAnnexB(frame, "h264", validH264NALHeader)
+}
+
+// ValidateH265 checks that the frame is an Annex-B byte stream of NAL units with sane headers
+func ValidateH265(frame []byte, _ bool) error {
+	return validateAnnexB(frame, "hevc", validHEVCNALHeader)
+}
+
+// RepairH265 drops the NAL units with broken headers from the frame
+func RepairH265(frame []byte) ([]byte, error) {
+	return repairAnnexB(frame, "hevc", validHEVCNALHeader)
+}
+
+func validateAnnexB(frame []byte, codecName string, valid func(nal []byte) bool) error {
+	nals := sniffNALs(frame, true)
+	if len(nals) == 0 {
+		return fmt.Errorf("%s frame does not start with a NAL unit", codecName)
+	}
+
+	for index, nal := range nals {
+		if !valid(nal) {
+			return fmt.Errorf("%s NAL unit %d of the frame has an invalid header", codecName, index)
+		}
+	}
+
+	return nil
+}
+
+func repairAnnexB(frame []byte, codecName string, valid func(nal []byte) bool) ([]byte, error) {
+	var repaired []byte
+	for _, nal := range SplitAnnexB(frame) {
+		if valid(nal) {
+			repaired = append(append(repaired, NALStartCode...), nal...)
+		}
+	}
+
+	if len(repaired) == 0 {
+		return nil, fmt.Errorf("%s frame has no valid NAL unit", codecName)
+	}
+
+	return repaired, nil
+}
@@ -14,8 +14,13 @@ const (
 	LowQuality  Quality = 0
 
 	// Stream Type Consts
-	StreamH264 StreamType = "h264"
-	StreamMJPG StreamType = "mjpg"
+	StreamH264   StreamType = "h264"
+	StreamH265   StreamType = "h265"
+	StreamMJPG   StreamType = "mjpg"
+	StreamMPEGTS StreamType = "mpegts"
+
+	// StreamAuto lets the server detect the stream type from the first frame
+	StreamAuto StreamType = "auto"
 )
 
 var (
@@ -23,16 +28,6 @@ var (
 		LowQuality:  true,
 		HighQuality: true,
 	}
-
-	StreamTypeIDs = map[int]StreamType{
-		1: StreamH264,
-		0: StreamMJPG,
-	}
-
-	StreamTypes = map[StreamType]bool{
-		StreamH264: true,
-		StreamMJPG: true,
-	}
 )
 
 func GetQualityFromString(qual string) (Quality, error) {
@@ -45,3 +40,15 @@ func GetQualityFromString(qual string) (Quality, error) {
 		return -1, errors.New(fmt.Sprintf("No Quality index for: %s", qual))
 	}
 }
+
+// GetStringFromQuality returns the name of a quality as used in topic names
+func GetStringFromQuality(quality Quality) (string, error) {
+	switch quality {
+	case LowQuality:
+		return "low", nil
+	case HighQuality:
+		return "high", nil
+	default:
+		return "", fmt.Errorf("No name for quality: %d", quality)
+	}
+}
@@ -0,0 +1,108 @@
+package consumer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CompositeChild is one of the consumers of a CompositeConsumer
+type CompositeChild struct {
+	Name string
+	// Prefix namespaces the streams of the consumer, e.g. "kafka/".
+	// Only stream ids that start with it are looked up in the consumer, without the prefix.
+	Prefix   string
+	Consumer StreamConsumer
+}
+
+// CompositeConsumer serves the streams of several consumers, e.g. TCP cameras and Kafka topics, as one
+type CompositeConsumer struct {
+	// children are in the order of precedence, the first one that has a stream serves it
+	children []CompositeChild
+	sync.Mutex
+}
+
+// NewCompositeConsumer combines the consumers, a stream id that several of them know is served by the first one
+func NewCompositeConsumer(children ...CompositeChild) (*CompositeConsumer, error) {
+	if len(children) == 0 {
+		return nil, fmt.Errorf("a composite consumer needs at least one consumer")
+	}
+
+	names := make(map[string]bool)
+	for _, child := range children {
+		if child.Consumer == nil {
+			return nil, fmt.Errorf("consumer %s is nil", child.Name)
+		}
+		if names[child.Name] {
+			return nil, fmt.Errorf("consumer %s exists twice", child.Name)
+		}
+		names[child.Name] = true
+	}
+
+	return &CompositeConsumer{children: children}, nil
+}
+
+// GetStream asks the consumers whose prefix the stream id starts with in the order of precedence
+func (cc *CompositeConsumer) GetStream(streamID string) (StreamConnection, error) {
+	for _, child := range cc.children {
+		if !strings.HasPrefix(streamID, child.Prefix) {
+			continue
+		}
+
+		stream, err := child.Consumer.GetStream(strings.TrimPrefix(streamID, child.Prefix))
+		if err == nil {
+			return stream, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No stream registered with id '%s' in any consumer", streamID)
+}
+
+// Start starts all consumers at once and returns once all of them returned.
+// If one of them fails the others are stopped.
+func (cc *CompositeConsumer) Start() error {
+	errs := make(chan error, len(cc.children))
+	var wg sync.WaitGroup
+	for _, child := range cc.children {
+		wg.Add(1)
+		go func(child CompositeChild) {
+			defer wg.Done()
+			err := child.Consumer.Start()
+			if err != nil {
+				errs <- fmt.Errorf("consumer %s: %s", child.Name, err)
+			}
+		}(child)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	var firstErr error
+	for err := range errs {
+		fmt.Println("Error starting consumer", err)
+		if firstErr == nil {
+			firstErr = err
+			cc.Stop()
+		}
+	}
+
+	return firstErr
+}
+
+// Stop stops all consumers, it returns the first error
+func (cc *CompositeConsumer) Stop() error {
+	cc.Lock()
+	defer cc.Unlock()
+
+	var firstErr error
+	for _, child := range cc.children {
+		err := child.Consumer.Stop()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("consumer %s: %s", child.Name, err)
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,196 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"StreamingServer/consumer/grpc/ingestpb"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// GRPCStreamConnection is a stream that a publisher sends over a gRPC Publish call.
+// The frames are pushed into it by the GRPCConsumer, the control messages for the
+// publisher are queued on the control channel.
+type GRPCStreamConnection struct {
+	consumer.BaseStreamConnection
+	streamChanMap map[consts.Quality](chan []byte)
+	control       chan *ingestpb.PublishResponse
+	wanted        map[consts.Quality]bool
+	paused        bool
+	isOpen        bool
+	sync.Mutex
+}
+
+func NewGRPCStreamConnection(streamID string, streamType consts.StreamType, qualities []consts.Quality) *GRPCStreamConnection {
+	sc := &GRPCStreamConnection{
+		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
+		streamChanMap:        make(map[consts.Quality](chan []byte)),
+		control:              make(chan *ingestpb.PublishResponse, 16),
+		wanted:               make(map[consts.Quality]bool),
+	}
+
+	for _, quality := range qualities {
+		sc.streamChanMap[quality] = make(chan []byte, 4)
+	}
+	return sc
+}
+
+// AddConnection adds another quality to the stream, the second argument is not used
+func (sc *GRPCStreamConnection) AddConnection(quality consts.Quality, _ interface{}) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, exists := sc.streamChanMap[quality]; exists {
+		return fmt.Errorf("stream %s already has quality %d", sc.GetID(), quality)
+	}
+
+	sc.streamChanMap[quality] = make(chan []byte, 4)
+	return nil
+}
+
+func (sc *GRPCStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	ch, ok := sc.streamChanMap[quality]
+	if !ok {
+		return nil, fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	return ch, nil
+}
+
+// AddDataToStream sends a frame of the publisher to the viewers of the quality
+func (sc *GRPCStreamConnection) AddDataToStream(data []byte, info consumer.FrameInfo, quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	qualityChannel, ok := sc.streamChanMap[quality]
+	if !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	data, valid := sc.ValidateFrame(data)
+	if !valid {
+		return nil
+	}
+
+	sc.ObserveFrameInfo(quality, data, info)
+	select {
+	case qualityChannel <- data:
+	default:
+		<-qualityChannel
+		qualityChannel <- data
+	}
+	return nil
+}
+
+// HandleStream marks the quality as open, the frames are pushed by the publisher
+func (sc *GRPCStreamConnection) HandleStream(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, ok := sc.streamChanMap[quality]; !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	sc.isOpen = true
+	return nil
+}
+
+// SetViewerCounts tells the publisher to pause when nobody watches the stream,
+// to resume when viewers come back and which renditions are watched.
+func (sc *GRPCStreamConnection) SetViewerCounts(counts map[consts.Quality]int) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	wanted := make(map[consts.Quality]bool)
+	for quality, count := range counts {
+		if count > 0 {
+			wanted[quality] = true
+		}
+	}
+
+	if len(wanted) == 0 {
+		if !sc.paused {
+			sc.paused = true
+			sc.sendControl(&ingestpb.PublishResponse{Message: &ingestpb.PublishResponse_Pause{Pause: &ingestpb.Pause{}}})
+		}
+		sc.wanted = wanted
+		return
+	}
+
+	if sc.paused {
+		sc.paused = false
+		sc.sendControl(&ingestpb.PublishResponse{Message: &ingestpb.PublishResponse_Resume{Resume: &ingestpb.Resume{}}})
+	}
+
+	changed := len(wanted) != len(sc.wanted)
+	for quality := range wanted {
+		if !sc.wanted[quality] {
+			changed = true
+		}
+	}
+	sc.wanted = wanted
+	if !changed {
+		return
+	}
+
+	var renditions []ingestpb.Rendition
+	for quality := range wanted {
+		renditions = append(renditions, ingestpb.Rendition(quality))
+	}
+	sort.Slice(renditions, func(i, j int) bool { return renditions[i] < renditions[j] })
+	sc.sendControl(&ingestpb.PublishResponse{
+		Message: &ingestpb.PublishResponse_RenditionRequest{
+			RenditionRequest: &ingestpb.RenditionRequest{Renditions: renditions},
+		},
+	})
+}
+
+// sendControl queues a control message for the publisher without blocking,
+// if the publisher does not read them fast enough the message is dropped
+func (sc *GRPCStreamConnection) sendControl(message *ingestpb.PublishResponse) {
+	select {
+	case sc.control <- message:
+	default:
+		fmt.Println("Dropping control message for publisher of stream", sc.GetID())
+	}
+}
+
+func (sc *GRPCStreamConnection) Close(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	channel, ok := sc.streamChanMap[quality]
+	if !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	close(channel)
+	delete(sc.streamChanMap, quality)
+	if len(sc.streamChanMap) == 0 {
+		sc.isOpen = false
+	}
+
+	return nil
+}
+
+// closeAll closes every quality of the stream
+func (sc *GRPCStreamConnection) closeAll() {
+	sc.Lock()
+	defer sc.Unlock()
+
+	for quality, channel := range sc.streamChanMap {
+		close(channel)
+		delete(sc.streamChanMap, quality)
+	}
+	sc.isOpen = false
+}
+
+func (sc *GRPCStreamConnection) IsOpen() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.isOpen
+}
@@ -0,0 +1,240 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"StreamingServer/consumer/grpc/ingestpb"
+	"StreamingServer/streamtype"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ProtocolVersion is the version of the ingest protocol this server implements
+const ProtocolVersion = 1
+
+// GRPCConsumer accepts streams from publishers that call the Publish method of the IngestService.
+// Every call carries one stream, the stream id is chosen by the publisher in its hello.
+type GRPCConsumer struct {
+	ingestpb.UnimplementedIngestServiceServer
+	address         string
+	server          *grpc.Server
+	activeStreamers map[string]*GRPCStreamConnection
+	sync.Mutex
+}
+
+func NewGRPCConsumer(ip string, port int) *GRPCConsumer {
+	return &GRPCConsumer{
+		address:         net.JoinHostPort(ip, strconv.Itoa(port)),
+		activeStreamers: make(map[string]*GRPCStreamConnection),
+	}
+}
+
+func (sc *GRPCConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	stream, ok := sc.activeStreamers[streamID]
+	if !ok {
+		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
+	}
+
+	return stream, nil
+}
+
+// Start serves the IngestService until Stop is called
+func (sc *GRPCConsumer) Start() error {
+	listener, err := net.Listen("tcp", sc.address)
+	if err != nil {
+		fmt.Printf("Unable to start gRPC Server on %s. Aborting due to error: %s\n", sc.address, err)
+		return err
+	}
+
+	sc.Lock()
+	sc.server = grpc.NewServer()
+	ingestpb.RegisterIngestServiceServer(sc.server, sc)
+	server := sc.server
+	sc.Unlock()
+
+	fmt.Println("Listening for gRPC publishers on", sc.address)
+	return server.Serve(listener)
+}
+
+func (sc *GRPCConsumer) Stop() error {
+	sc.Lock()
+	server := sc.server
+	sc.Unlock()
+
+	if server != nil {
+		server.Stop()
+	}
+	return nil
+}
+
+// Publish handles the call of a single publisher
+func (sc *GRPCConsumer) Publish(stream ingestpb.IngestService_PublishServer) error {
+	request, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	hello := request.GetHello()
+	connection, err := sc.register(hello)
+	if err != nil {
+		s := status.Convert(err)
+		code := ingestpb.Error_CODE_INVALID_HELLO
+		switch s.Code() {
+		case codes.FailedPrecondition:
+			code = ingestpb.Error_CODE_UNSUPPORTED_VERSION
+		case codes.AlreadyExists:
+			code = ingestpb.Error_CODE_STREAM_EXISTS
+		}
+		stream.Send(errorResponse(code, s.Message()))
+		return err
+	}
+	defer sc.unregister(connection)
+
+	fmt.Println("Registering gRPC stream with id:", connection.GetID())
+	err = stream.Send(&ingestpb.PublishResponse{
+		Message: &ingestpb.PublishResponse_Accepted{
+			Accepted: &ingestpb.Accepted{ProtocolVersion: ProtocolVersion, StreamId: connection.GetID()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// gRPC streams must not be sent on concurrently, so all control messages go through one goroutine
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case message := <-connection.control:
+				if err := stream.Send(message); err != nil {
+					fmt.Println("Error sending control message to publisher of", connection.GetID(), err)
+					return
+				}
+			case <-done:
+				return
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	def, err := streamtype.Lookup(connection.GetType())
+	if err != nil {
+		return err
+	}
+
+	confirmed := make(map[ingestpb.Rendition]bool)
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			fmt.Println("Publisher closed the stream", connection.GetID())
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("Error while reading from publisher of %s: %s\n", connection.GetID(), err)
+			return err
+		}
+
+		frame := request.GetFrame()
+		if frame == nil {
+			connection.sendControl(errorResponse(ingestpb.Error_CODE_INVALID_FRAME, "expected a frame after the hello"))
+			continue
+		}
+
+		// the first frame of every rendition must match the stream type of the hello
+		if !confirmed[frame.GetRendition()] {
+			err = streamtype.Confirm(def, frame.GetData(), true)
+			if err != nil {
+				fmt.Println("Rejecting gRPC stream", connection.GetID(), err)
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			confirmed[frame.GetRendition()] = true
+		}
+
+		info := consumer.FrameInfo{Keyframe: frame.GetKeyframe(), Sequence: frame.GetSequence()}
+		if frame.GetTimestampUs() != 0 {
+			info.CaptureTime = time.Unix(0, frame.GetTimestampUs()*int64(time.Microsecond))
+		}
+
+		err = connection.AddDataToStream(frame.GetData(), info, consts.Quality(frame.GetRendition()))
+		if err != nil {
+			connection.sendControl(errorResponse(ingestpb.Error_CODE_INVALID_FRAME, err.Error()))
+		}
+	}
+}
+
+// register checks the hello of a publisher and adds its stream
+func (sc *GRPCConsumer) register(hello *ingestpb.Hello) (*GRPCStreamConnection, error) {
+	if hello == nil {
+		return nil, status.Error(codes.InvalidArgument, "the first message must be a hello")
+	}
+
+	if hello.GetProtocolVersion() != ProtocolVersion {
+		return nil, status.Errorf(codes.FailedPrecondition, "unsupported protocol version %d, the server implements %d", hello.GetProtocolVersion(), ProtocolVersion)
+	}
+
+	streamID := hello.GetStreamId()
+	if streamID == "" {
+		return nil, status.Error(codes.InvalidArgument, "stream_id must not be empty")
+	}
+
+	streamType := consts.StreamType(hello.GetStreamType())
+	if !streamtype.IsRegistered(streamType) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown stream type '%s'", streamType)
+	}
+
+	qualities := []consts.Quality{consts.LowQuality}
+	if len(hello.GetRenditions()) > 0 {
+		qualities = nil
+		for _, rendition := range hello.GetRenditions() {
+			quality := consts.Quality(rendition)
+			if _, ok := consts.Qualities[quality]; !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "unknown rendition %d", rendition)
+			}
+			qualities = append(qualities, quality)
+		}
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, exists := sc.activeStreamers[streamID]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "stream '%s' is already published", streamID)
+	}
+
+	connection := NewGRPCStreamConnection(streamID, streamType, qualities)
+	for _, quality := range qualities {
+		connection.HandleStream(quality)
+	}
+	sc.activeStreamers[streamID] = connection
+	return connection, nil
+}
+
+func (sc *GRPCConsumer) unregister(connection *GRPCStreamConnection) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	fmt.Printf("Removing handler for %s\n", connection.GetID())
+	delete(sc.activeStreamers, connection.GetID())
+	connection.closeAll()
+}
+
+func errorResponse(code ingestpb.Error_Code, message string) *ingestpb.PublishResponse {
+	return &ingestpb.PublishResponse{
+		Message: &ingestpb.PublishResponse_Error{
+			Error: &ingestpb.Error{Code: code, Message: message},
+		},
+	}
+}
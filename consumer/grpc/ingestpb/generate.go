@@ -0,0 +1,4 @@
+// Package ingestpb holds the protocol of the gRPC ingest service.
+package ingestpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ingest.proto
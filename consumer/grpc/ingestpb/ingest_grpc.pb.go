@@ -0,0 +1,147 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: ingest.proto
+
+package ingestpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	IngestService_Publish_FullMethodName = "/streamingserver.ingest.v1.IngestService/Publish"
+)
+
+// IngestServiceClient is the client API for IngestService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type IngestServiceClient interface {
+	// Publish is opened by a publisher for a single stream.
+	// The first message must be a Hello, every following message a Frame.
+	// The server answers the Hello with Accepted (or an Error) and then sends control messages.
+	Publish(ctx context.Context, opts ...grpc.CallOption) (IngestService_PublishClient, error)
+}
+
+type ingestServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIngestServiceClient(cc grpc.ClientConnInterface) IngestServiceClient {
+	return &ingestServiceClient{cc}
+}
+
+func (c *ingestServiceClient) Publish(ctx context.Context, opts ...grpc.CallOption) (IngestService_PublishClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IngestService_ServiceDesc.Streams[0], IngestService_Publish_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ingestServicePublishClient{stream}
+	return x, nil
+}
+
+type IngestService_PublishClient interface {
+	Send(*PublishRequest) error
+	Recv() (*PublishResponse, error)
+	grpc.ClientStream
+}
+
+type ingestServicePublishClient struct {
+	grpc.ClientStream
+}
+
+func (x *ingestServicePublishClient) Send(m *PublishRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *ingestServicePublishClient) Recv() (*PublishResponse, error) {
+	m := new(PublishResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// IngestServiceServer is the server API for IngestService service.
+// All implementations must embed UnimplementedIngestServiceServer
+// for forward compatibility
+type IngestServiceServer interface {
+	// Publish is opened by a publisher for a single stream.
+	// The first message must be a Hello, every following message a Frame.
+	// The server answers the Hello with Accepted (or an Error) and then sends control messages.
+	Publish(IngestService_PublishServer) error
+	mustEmbedUnimplementedIngestServiceServer()
+}
+
+// UnimplementedIngestServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedIngestServiceServer struct {
+}
+
+func (UnimplementedIngestServiceServer) Publish(IngestService_PublishServer) error {
+	return status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedIngestServiceServer) mustEmbedUnimplementedIngestServiceServer() {}
+
+// UnsafeIngestServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IngestServiceServer will
+// result in compilation errors.
+type UnsafeIngestServiceServer interface {
+	mustEmbedUnimplementedIngestServiceServer()
+}
+
+func RegisterIngestServiceServer(s grpc.ServiceRegistrar, srv IngestServiceServer) {
+	s.RegisterService(&IngestService_ServiceDesc, srv)
+}
+
+func _IngestService_Publish_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IngestServiceServer).Publish(&ingestServicePublishServer{stream})
+}
+
+type IngestService_PublishServer interface {
+	Send(*PublishResponse) error
+	Recv() (*PublishRequest, error)
+	grpc.ServerStream
+}
+
+type ingestServicePublishServer struct {
+	grpc.ServerStream
+}
+
+func (x *ingestServicePublishServer) Send(m *PublishResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *ingestServicePublishServer) Recv() (*PublishRequest, error) {
+	m := new(PublishRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// IngestService_ServiceDesc is the grpc.ServiceDesc for IngestService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IngestService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "streamingserver.ingest.v1.IngestService",
+	HandlerType: (*IngestServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Publish",
+			Handler:       _IngestService_Publish_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "ingest.proto",
+}
@@ -1,6 +1,11 @@
 package consumer
 
-import "StreamingServer/consts"
+import (
+	"StreamingServer/consts"
+	"StreamingServer/streamtype"
+	"fmt"
+	"time"
+)
 
 // StreamConsumer must be implemented by strcuts representing stream consumers
 type StreamConsumer interface {
@@ -18,11 +23,37 @@ type StreamConnection interface {
 	AddConnection(consts.Quality, interface{}) error
 	Close(consts.Quality) error
 	IsOpen() bool
+	ValidateFrame(frame []byte) ([]byte, bool)
+	ObserveFrame(quality consts.Quality, frame []byte)
+	ObserveFrameInfo(quality consts.Quality, frame []byte, info FrameInfo) bool
+	GetMetadata() StreamMetadata
+}
+
+// ViewerObserver is implemented by stream connections that want to know how many clients watch each quality,
+// e.g. to tell the publisher which renditions to send
+type ViewerObserver interface {
+	SetViewerCounts(counts map[consts.Quality]int)
+}
+
+// TimeShifter is implemented by stream connections that can replay a quality from a point in the past
+type TimeShifter interface {
+	OpenTimeShift(quality consts.Quality, from time.Time) (TimeShiftStream, error)
+}
+
+// TimeShiftStream is the replay of a single viewer, the frames are sent at the pace they were recorded
+type TimeShiftStream interface {
+	Frames() <-chan []byte
+	// CatchUp stops pacing the frames so the replay reaches the live stream
+	CatchUp()
+	Close() error
 }
 
 type BaseStreamConnection struct {
 	streamID   string
 	streamType consts.StreamType
+	metadata   *metadataCollector
+	validator  *frameValidator
+	skipSink   bool
 }
 
 func (sc *BaseStreamConnection) GetID() string {
@@ -33,9 +64,72 @@ func (sc *BaseStreamConnection) GetType() consts.StreamType {
 	return sc.streamType
 }
 
+// ValidateFrame checks a frame with the validation configured for the stream.
+// It returns the frame that should be forwarded, which may be repaired, and false if the frame is dropped.
+func (sc *BaseStreamConnection) ValidateFrame(frame []byte) ([]byte, bool) {
+	if sc.validator == nil {
+		return frame, true
+	}
+
+	frame = sc.validator.check(frame, sc.metadata)
+	return frame, frame != nil
+}
+
+// ObserveFrame updates the stream metadata with a frame that was read from the stream and passes it to the frame sink
+func (sc *BaseStreamConnection) ObserveFrame(quality consts.Quality, frame []byte) {
+	sc.ObserveFrameInfo(quality, frame, FrameInfo{})
+}
+
+// ObserveFrameInfo is like ObserveFrame for frames where the publisher sent the capture time or keyframe flag.
+// It reports whether the frame is a keyframe.
+func (sc *BaseStreamConnection) ObserveFrameInfo(quality consts.Quality, frame []byte, info FrameInfo) bool {
+	keyframe := sc.metadata.observe(quality, frame, info)
+
+	if sink := currentFrameSink(); sink != nil && !sc.skipSink {
+		sink.WriteFrame(sc.streamID, sc.streamType, quality, frame, info)
+	}
+	return keyframe
+}
+
+// DisableFrameSink keeps the frames of the stream from the frame sink, e.g. because they are read from where the sink writes to
+func (sc *BaseStreamConnection) DisableFrameSink() {
+	sc.skipSink = true
+}
+
+// SetStat sets a counter that is shown in the stream metadata
+func (sc *BaseStreamConnection) SetStat(name string, value uint64) {
+	sc.metadata.setStat(name, value)
+}
+
+// IncrementStat adds delta to a counter that is shown in the stream metadata
+func (sc *BaseStreamConnection) IncrementStat(name string, delta uint64) {
+	sc.metadata.incrementStat(name, delta)
+}
+
+// SetState changes the state shown in the stream metadata and logs the transition
+func (sc *BaseStreamConnection) SetState(state StreamState) {
+	previous := sc.metadata.setState(state)
+	if previous == "" {
+		fmt.Printf("Stream %s is %s\n", sc.streamID, state)
+	} else if previous != state {
+		fmt.Printf("Stream %s changed from %s to %s\n", sc.streamID, previous, state)
+	}
+}
+
+func (sc *BaseStreamConnection) GetMetadata() StreamMetadata {
+	return sc.metadata.metadata(sc.streamID, sc.streamType)
+}
+
 func NewBaseStreamConnection(streamID string, streamType consts.StreamType) BaseStreamConnection {
+	var validator *frameValidator
+	if def, err := streamtype.Lookup(streamType); err == nil {
+		validator = newFrameValidator(def, lookupValidation(streamID))
+	}
+
 	return BaseStreamConnection{
 		streamID:   streamID,
 		streamType: streamType,
+		metadata:   newMetadataCollector(streamType),
+		validator:  validator,
 	}
 }
@@ -0,0 +1,192 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// A frame that is larger than the message size of the broker is split into chunks.
+// Every chunk starts with a header of 21 bytes, all numbers are big endian:
+//
+//	magic "FRCH" | version 1 (1 byte) | frame id (8 bytes) | chunk index (2 bytes) | chunk count (2 bytes) | crc32 of the frame (4 bytes)
+//
+// followed by the part of the frame. Messages without the magic are whole frames.
+const (
+	chunkVersion    = 1
+	chunkHeaderSize = 21
+
+	// maxPartialFrames is how many frames of a quality may be reassembled at the same time
+	maxPartialFrames    = 8
+	defaultChunkTimeout = 5 * time.Second
+
+	// Stats of the stream metadata
+	statChunkedFrames        = "chunked_frames"
+	statPartialFramesDropped = "partial_frames_dropped"
+	statCorruptChunks        = "corrupt_chunks"
+)
+
+var chunkMagic = []byte("FRCH")
+
+type chunkHeader struct {
+	frameID  uint64
+	index    uint16
+	count    uint16
+	checksum uint32
+}
+
+// IsChunk reports whether a message is a chunk of a frame
+func IsChunk(message []byte) bool {
+	return len(message) >= chunkHeaderSize && bytes.Equal(message[:len(chunkMagic)], chunkMagic)
+}
+
+// ChunkFrame splits a frame into chunks whose size including the header is at most maxMessageSize.
+// A frame that fits into a single message is returned as it is.
+func ChunkFrame(frame []byte, frameID uint64, maxMessageSize int) ([][]byte, error) {
+	if len(frame) <= maxMessageSize && !IsChunk(frame) {
+		return [][]byte{frame}, nil
+	}
+
+	chunkSize := maxMessageSize - chunkHeaderSize
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("message size must be larger than the chunk header of %d bytes", chunkHeaderSize)
+	}
+
+	count := (len(frame) + chunkSize - 1) / chunkSize
+	if count > 0xFFFF {
+		return nil, fmt.Errorf("frame of %d bytes needs more than %d chunks", len(frame), 0xFFFF)
+	}
+
+	checksum := crc32.ChecksumIEEE(frame)
+	chunks := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		end := (index + 1) * chunkSize
+		if end > len(frame) {
+			end = len(frame)
+		}
+		part := frame[index*chunkSize : end]
+
+		chunk := make([]byte, chunkHeaderSize, chunkHeaderSize+len(part))
+		copy(chunk, chunkMagic)
+		chunk[4] = chunkVersion
+		binary.BigEndian.PutUint64(chunk[5:], frameID)
+		binary.BigEndian.PutUint16(chunk[13:], uint16(index))
+		binary.BigEndian.PutUint16(chunk[15:], uint16(count))
+		binary.BigEndian.PutUint32(chunk[17:], checksum)
+		chunks = append(chunks, append(chunk, part...))
+	}
+
+	return chunks, nil
+}
+
+func parseChunk(message []byte) (chunkHeader, []byte, error) {
+	if !IsChunk(message) {
+		return chunkHeader{}, nil, fmt.Errorf("message is not a chunk")
+	}
+
+	if message[4] != chunkVersion {
+		return chunkHeader{}, nil, fmt.Errorf("unknown chunk version %d", message[4])
+	}
+
+	header := chunkHeader{
+		frameID:  binary.BigEndian.Uint64(message[5:]),
+		index:    binary.BigEndian.Uint16(message[13:]),
+		count:    binary.BigEndian.Uint16(message[15:]),
+		checksum: binary.BigEndian.Uint32(message[17:]),
+	}
+	if header.count == 0 || header.index >= header.count {
+		return chunkHeader{}, nil, fmt.Errorf("chunk %d of %d is out of range", header.index, header.count)
+	}
+
+	return header, message[chunkHeaderSize:], nil
+}
+
+// partialFrame collects the chunks of a frame until all arrived
+type partialFrame struct {
+	header   chunkHeader
+	parts    [][]byte
+	received int
+	started  time.Time
+}
+
+// chunkAssembler reassembles the chunked frames of one quality
+type chunkAssembler struct {
+	timeout time.Duration
+	partial map[uint64]*partialFrame
+}
+
+func newChunkAssembler(timeout time.Duration) *chunkAssembler {
+	return &chunkAssembler{
+		timeout: timeout,
+		partial: make(map[uint64]*partialFrame),
+	}
+}
+
+// add adds a chunk and returns the frame once it is complete.
+// It also returns how many partial frames were dropped because they timed out or too many were open.
+func (ca *chunkAssembler) add(message []byte, now time.Time) ([]byte, int, error) {
+	dropped := ca.expire(now)
+
+	header, part, err := parseChunk(message)
+	if err != nil {
+		return nil, dropped, err
+	}
+
+	frame, ok := ca.partial[header.frameID]
+	if !ok || frame.header.count != header.count || frame.header.checksum != header.checksum {
+		if ok {
+			// the frame id was reused for another frame
+			dropped++
+		} else if len(ca.partial) >= maxPartialFrames {
+			ca.dropOldest()
+			dropped++
+		}
+
+		frame = &partialFrame{header: header, parts: make([][]byte, header.count), started: now}
+		ca.partial[header.frameID] = frame
+	}
+
+	if frame.parts[header.index] == nil {
+		frame.parts[header.index] = part
+		frame.received++
+	}
+
+	if frame.received < int(header.count) {
+		return nil, dropped, nil
+	}
+
+	delete(ca.partial, header.frameID)
+	data := bytes.Join(frame.parts, nil)
+	if crc32.ChecksumIEEE(data) != header.checksum {
+		return nil, dropped, fmt.Errorf("checksum of frame %d does not match", header.frameID)
+	}
+
+	return data, dropped, nil
+}
+
+// expire drops the partial frames whose first chunk is older than the timeout
+func (ca *chunkAssembler) expire(now time.Time) int {
+	dropped := 0
+	for frameID, frame := range ca.partial {
+		if now.Sub(frame.started) > ca.timeout {
+			delete(ca.partial, frameID)
+			dropped++
+		}
+	}
+
+	return dropped
+}
+
+func (ca *chunkAssembler) dropOldest() {
+	var oldestID uint64
+	var oldest *partialFrame
+	for frameID, frame := range ca.partial {
+		if oldest == nil || frame.started.Before(oldest.started) {
+			oldestID, oldest = frameID, frame
+		}
+	}
+
+	delete(ca.partial, oldestID)
+}
@@ -0,0 +1,428 @@
+package consumer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+const (
+	// Security Keyword Args Keys
+	versionKey       = "version"
+	clientIDKey      = "client_id"
+	tlsKey           = "tls"
+	tlsCAKey         = "tls_ca"
+	tlsCertKey       = "tls_cert"
+	tlsKeyKey        = "tls_key"
+	tlsSkipVerifyKey = "tls_skip_verify"
+	saslMechanismKey = "sasl_mechanism"
+	saslUserKey      = "sasl_user"
+	saslPasswordKey  = "sasl_password"
+
+	// Fetch Keyword Args Keys, sizes in bytes
+	fetchMinKey     = "fetch_min"
+	fetchDefaultKey = "fetch_default"
+	fetchMaxKey     = "fetch_max"
+
+	// chunkTimeoutKey is how long the chunks of a frame may take to arrive
+	chunkTimeoutKey = "chunk_timeout"
+	// stallTimeoutKey is how long a topic may not deliver before its stream is stalled
+	stallTimeoutKey = "stall_timeout"
+
+	// onDemandKey pauses the topics of qualities nobody watches for lingerKey
+	onDemandKey = "on_demand"
+	lingerKey   = "linger"
+
+	// maxMessageSizeKey is the size of the largest message a producer sends, larger frames are chunked
+	maxMessageSizeKey     = "max_message_size"
+	defaultMaxMessageSize = 1000000
+
+	defaultClientID     = "streaming-server"
+	defaultFetchDefault = 1024 * 1024 * 2
+)
+
+var (
+	// connectionKeys are the keys of consumers and producers
+	connectionKeys = []string{
+		topicTemplateKey, streamIDTemplateKey, versionKey, clientIDKey,
+		tlsKey, tlsCAKey, tlsCertKey, tlsKeyKey, tlsSkipVerifyKey,
+		saslMechanismKey, saslUserKey, saslPasswordKey,
+	}
+	consumerKeys = []string{
+		topicsKey, topicPatternKey, refreshIntervalKey, streamPrefixKey,
+		groupIDKey, consumerOffsetKey, backOffTimeKey,
+		fetchMinKey, fetchDefaultKey, fetchMaxKey,
+		chunkTimeoutKey, stallTimeoutKey, onDemandKey, lingerKey,
+	}
+	producerKeys = []string{maxMessageSizeKey}
+)
+
+// KafkaConfig is the validated form of KafkaArgs
+type KafkaConfig struct {
+	Topics           []string
+	TopicPattern     string
+	RefreshInterval  time.Duration
+	TopicTemplate    string
+	StreamIDTemplate string
+	StreamPrefix     string
+
+	GroupID       string
+	ClientID      string
+	Version       sarama.KafkaVersion
+	VersionSet    bool
+	InitialOffset int64
+	Backoff       time.Duration
+
+	FetchMin     int32
+	FetchDefault int32
+	FetchMax     int32
+	ChunkTimeout time.Duration
+	StallTimeout time.Duration
+	OnDemand     bool
+	Linger       time.Duration
+
+	TLS           bool
+	TLSCA         string
+	TLSCert       string
+	TLSKey        string
+	TLSSkipVerify bool
+
+	SASLMechanism sarama.SASLMechanism
+	SASLUser      string
+	SASLPassword  string
+
+	MaxMessageSize int32
+}
+
+// ParseKafkaArgs checks the keys and values of the args of a consumer, unknown keys are errors
+func ParseKafkaArgs(args KafkaArgs) (KafkaConfig, error) {
+	err := checkKeys(args, connectionKeys, consumerKeys)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc, err := parseConnection(args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.TopicPattern = getOrDefault(topicPatternKey, "", args)
+	kc.StreamPrefix = getOrDefault(streamPrefixKey, "", args)
+	kc.GroupID = getOrDefault(groupIDKey, randomGroupID(6), args)
+
+	if topics := getOrDefault(topicsKey, "", args); topics != "" {
+		for _, topic := range strings.Split(topics, ",") {
+			topic = strings.TrimSpace(topic)
+			if topic == "" {
+				return KafkaConfig{}, fmt.Errorf("topics must not contain empty topic names")
+			}
+			kc.Topics = append(kc.Topics, topic)
+		}
+	}
+	if len(kc.Topics) == 0 && kc.TopicPattern == "" {
+		return KafkaConfig{}, fmt.Errorf("topics must exist in KafkaArgs as comma seperated string or topic_pattern as regular expression")
+	}
+
+	switch getOrDefault(consumerOffsetKey, defaultOffset, args) {
+	case offsetOldest:
+		kc.InitialOffset = sarama.OffsetOldest
+	case offsetNewest:
+		kc.InitialOffset = sarama.OffsetNewest
+	default:
+		return KafkaConfig{}, fmt.Errorf("Offset must be either newest or oldest")
+	}
+
+	kc.Backoff, err = parseDuration(backOffTimeKey, "100ms", args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.RefreshInterval, err = parseDuration(refreshIntervalKey, "30s", args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.ChunkTimeout, err = parseDuration(chunkTimeoutKey, defaultChunkTimeout.String(), args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.StallTimeout, err = parseDuration(stallTimeoutKey, "10s", args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.OnDemand, err = parseBool(onDemandKey, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	kc.Linger, err = parseDuration(lingerKey, "10s", args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.FetchMin, err = parseBytes(fetchMinKey, 1, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	kc.FetchDefault, err = parseBytes(fetchDefaultKey, defaultFetchDefault, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	kc.FetchMax, err = parseBytes(fetchMaxKey, 0, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	if kc.FetchDefault < kc.FetchMin || (kc.FetchMax > 0 && kc.FetchDefault > kc.FetchMax) {
+		return KafkaConfig{}, fmt.Errorf("fetch sizes must be fetch_min <= fetch_default <= fetch_max")
+	}
+
+	return kc, nil
+}
+
+// ParseKafkaProducerArgs checks the keys and values of the args of a producer, unknown keys are errors
+func ParseKafkaProducerArgs(args KafkaArgs) (KafkaConfig, error) {
+	err := checkKeys(args, connectionKeys, producerKeys)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc, err := parseConnection(args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+
+	kc.MaxMessageSize, err = parseBytes(maxMessageSizeKey, defaultMaxMessageSize, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	if kc.MaxMessageSize <= chunkHeaderSize {
+		return KafkaConfig{}, fmt.Errorf("%s must be larger than %d bytes", maxMessageSizeKey, chunkHeaderSize)
+	}
+
+	return kc, nil
+}
+
+// checkKeys returns an error for the keys of args that are not in any of the lists
+func checkKeys(args KafkaArgs, keyLists ...[]string) error {
+	known := make(map[string]bool)
+	for _, keys := range keyLists {
+		for _, key := range keys {
+			known[key] = true
+		}
+	}
+
+	var unknown []string
+	for key := range args {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown KafkaArgs keys: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// parseConnection reads the naming, version and security keys
+func parseConnection(args KafkaArgs) (KafkaConfig, error) {
+	kc := KafkaConfig{
+		TopicTemplate:    getOrDefault(topicTemplateKey, "", args),
+		StreamIDTemplate: getOrDefault(streamIDTemplateKey, "", args),
+		ClientID:         getOrDefault(clientIDKey, defaultClientID, args),
+		TLSCA:            getOrDefault(tlsCAKey, "", args),
+		TLSCert:          getOrDefault(tlsCertKey, "", args),
+		TLSKey:           getOrDefault(tlsKeyKey, "", args),
+		SASLUser:         getOrDefault(saslUserKey, "", args),
+		SASLPassword:     getOrDefault(saslPasswordKey, "", args),
+	}
+
+	var err error
+	_, kc.VersionSet = args[versionKey]
+	kc.Version, err = sarama.ParseKafkaVersion(getOrDefault(versionKey, version0_10_1, args))
+	if err != nil {
+		return KafkaConfig{}, fmt.Errorf("version must be a kafka version like %s: %s", version1_1_0, err)
+	}
+
+	kc.TLS, err = parseBool(tlsKey, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	kc.TLSSkipVerify, err = parseBool(tlsSkipVerifyKey, args)
+	if err != nil {
+		return KafkaConfig{}, err
+	}
+	if (kc.TLSCert == "") != (kc.TLSKey == "") {
+		return KafkaConfig{}, fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	// any of the tls files enables tls
+	kc.TLS = kc.TLS || kc.TLSCA != "" || kc.TLSCert != "" || kc.TLSSkipVerify
+
+	if mechanism, ok := args[saslMechanismKey]; ok {
+		kc.SASLMechanism = sarama.SASLMechanism(strings.ToUpper(mechanism))
+		switch kc.SASLMechanism {
+		case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		default:
+			return KafkaConfig{}, fmt.Errorf("sasl_mechanism must be one of %s, %s or %s", sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512)
+		}
+
+		if kc.SASLUser == "" || kc.SASLPassword == "" {
+			return KafkaConfig{}, fmt.Errorf("sasl_user and sasl_password must be set for sasl_mechanism %s", kc.SASLMechanism)
+		}
+	} else if kc.SASLUser != "" || kc.SASLPassword != "" {
+		return KafkaConfig{}, fmt.Errorf("sasl_user and sasl_password need a sasl_mechanism")
+	}
+
+	return kc, nil
+}
+
+// clusterConfig returns the sarama configuration of the consumers
+func (kc KafkaConfig) clusterConfig() (*cluster.Config, error) {
+	config := cluster.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Group.Return.Notifications = true
+	config.Consumer.Offsets.Initial = kc.InitialOffset
+	config.Consumer.Retry.Backoff = kc.Backoff
+	config.Consumer.Fetch.Min = kc.FetchMin
+	config.Consumer.Fetch.Default = kc.FetchDefault
+	config.Consumer.Fetch.Max = kc.FetchMax
+
+	err := kc.connectionConfig(&config.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// producerConfig returns the sarama configuration of the producer
+func (kc KafkaConfig) producerConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Errors = true
+	config.Producer.Return.Successes = false
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.MaxMessageBytes = int(kc.MaxMessageSize)
+
+	err := kc.connectionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// connectionConfig sets the version, client id, tls and sasl of a sarama configuration
+func (kc KafkaConfig) connectionConfig(config *sarama.Config) error {
+	config.ClientID = kc.ClientID
+	config.Version = kc.Version
+
+	if kc.TLS {
+		tlsConfig, err := kc.tlsConfig()
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if kc.SASLMechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.Mechanism = kc.SASLMechanism
+		config.Net.SASL.User = kc.SASLUser
+		config.Net.SASL.Password = kc.SASLPassword
+
+		switch kc.SASLMechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hashGenerator: scramSHA256} }
+		case sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hashGenerator: scramSHA512} }
+		}
+	}
+
+	return nil
+}
+
+// tlsConfig loads the CA and the client certificate, without CA the system roots are used
+func (kc KafkaConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: kc.TLSSkipVerify}
+
+	if kc.TLSCA != "" {
+		caCert, err := ioutil.ReadFile(kc.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read tls_ca: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("tls_ca %s contains no PEM certificate", kc.TLSCA)
+		}
+	}
+
+	if kc.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(kc.TLSCert, kc.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load tls_cert and tls_key: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func parseDuration(key, defaultVal string, args KafkaArgs) (time.Duration, error) {
+	duration, err := time.ParseDuration(getOrDefault(key, defaultVal, args))
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 100ms", key)
+	}
+
+	return duration, nil
+}
+
+func parseBytes(key string, defaultVal int32, args KafkaArgs) (int32, error) {
+	val, ok := args[key]
+	if !ok {
+		return defaultVal, nil
+	}
+
+	size, err := strconv.ParseInt(val, 10, 32)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%s must be a number of bytes", key)
+	}
+
+	return int32(size), nil
+}
+
+func parseBool(key string, args KafkaArgs) (bool, error) {
+	val, ok := args[key]
+	if !ok {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", key)
+	}
+
+	return b, nil
+}
@@ -4,114 +4,335 @@ import (
 	"StreamingServer/consts"
 	"StreamingServer/consumer"
 	"fmt"
-
-	cluster "github.com/bsm/sarama-cluster"
+	"sync"
+	"time"
 )
 
+// KafkaStreamConnection is a stream whose qualities are read from one or more topics.
+// The frames are pushed into it by the topic readers of the KafkaConsumer.
 type KafkaStreamConnection struct {
 	consumer.BaseStreamConnection
-	kafkaConsumers map[consts.Quality]*cluster.Consumer
-	streamChanMap  map[consts.Quality](chan []byte)
-	isOpen         bool
+	streamChanMap map[consts.Quality](chan []byte)
+	source        *KafkaConsumer
+	topicReaders  map[consts.Quality]*topicReader
+	assemblers    map[consts.Quality]*chunkAssembler
+	chunkTimeout  time.Duration
+	isOpen        bool
+
+	// lastFrames and lastKeyframes are sent to the first viewers of a paused quality
+	lastFrames    map[consts.Quality][]byte
+	lastKeyframes map[consts.Quality][]byte
+	viewerCounts  map[consts.Quality]int
+	// lingerTimers pause the topics nobody watches
+	lingerTimers map[*topicReader]*time.Timer
+	sync.Mutex
 }
 
-func NewKafkaStreamConnection(streamID string, streamType consts.StreamType, kafkaConsumer *cluster.Consumer, quality consts.Quality) *KafkaStreamConnection {
-	streamConnection := KafkaStreamConnection{
+// NewKafkaStreamConnection creates a stream, chunked frames that are not complete after chunkTimeout are dropped
+func NewKafkaStreamConnection(streamID string, streamType consts.StreamType, chunkTimeout time.Duration) *KafkaStreamConnection {
+	sc := &KafkaStreamConnection{
 		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
-		kafkaConsumers:       make(map[consts.Quality]*cluster.Consumer),
 		streamChanMap:        make(map[consts.Quality](chan []byte)),
+		topicReaders:         make(map[consts.Quality]*topicReader),
+		assemblers:           make(map[consts.Quality]*chunkAssembler),
+		chunkTimeout:         chunkTimeout,
+		lastFrames:           make(map[consts.Quality][]byte),
+		lastKeyframes:        make(map[consts.Quality][]byte),
+		viewerCounts:         make(map[consts.Quality]int),
+		lingerTimers:         make(map[*topicReader]*time.Timer),
+	}
+
+	// frames read from kafka are not produced to kafka again
+	sc.DisableFrameSink()
+	return sc
+}
+
+// setTopic remembers the topic a quality is read from for time shifting and pausing
+func (sc *KafkaStreamConnection) setTopic(quality consts.Quality, source *KafkaConsumer, reader *topicReader) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.source = source
+	sc.topicReaders[quality] = reader
+	sc.updateDemand()
+}
+
+// SetViewerCounts resumes the topics of the watched qualities and pauses the others once nobody watched them
+// for the linger time, if the KafkaConsumer reads on demand
+func (sc *KafkaStreamConnection) SetViewerCounts(counts map[consts.Quality]int) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.viewerCounts = counts
+	sc.updateDemand()
+}
+
+// updateDemand resumes or schedules the pause of every topic of the stream, sc must be locked
+func (sc *KafkaStreamConnection) updateDemand() {
+	if sc.source == nil || !sc.source.onDemand {
+		return
+	}
+
+	// a topic that feeds several qualities is watched if any of them is
+	watched := make(map[*topicReader]bool)
+	for quality, reader := range sc.topicReaders {
+		watched[reader] = watched[reader] || sc.viewerCounts[quality] > 0
+	}
+
+	for reader, isWatched := range watched {
+		timer, lingering := sc.lingerTimers[reader]
+		if isWatched {
+			if lingering {
+				timer.Stop()
+				delete(sc.lingerTimers, reader)
+			}
+			if reader.resume() {
+				sc.sendLastFrames(reader)
+			}
+			continue
+		}
+
+		if !lingering && !reader.isPaused() {
+			sc.lingerTimers[reader] = sc.lingerTimer(reader)
+		}
+	}
+}
+
+// lingerTimer pauses the topic after the linger time unless the timer is stopped or replaced before, sc must be locked
+func (sc *KafkaStreamConnection) lingerTimer(reader *topicReader) *time.Timer {
+	var timer *time.Timer
+	timer = time.AfterFunc(sc.source.linger, func() {
+		sc.Lock()
+		current, ok := sc.lingerTimers[reader]
+		if !ok || current != timer {
+			sc.Unlock()
+			return
+		}
+		delete(sc.lingerTimers, reader)
+		sc.Unlock()
+
+		if reader.pause() {
+			fmt.Println("Nobody watches topic", reader.topic, "of stream", sc.GetID(), "pausing")
+		}
+	})
+
+	return timer
+}
+
+// sendLastFrames sends the last keyframe, or the last frame if the stream has no keyframes,
+// of every quality the topic feeds so resumed viewers see a picture right away, sc must be locked
+func (sc *KafkaStreamConnection) sendLastFrames(reader *topicReader) {
+	for quality, qualityReader := range sc.topicReaders {
+		if qualityReader != reader {
+			continue
+		}
+
+		frame, ok := sc.lastKeyframes[quality]
+		if !ok {
+			frame, ok = sc.lastFrames[quality]
+		}
+		qualityChannel, open := sc.streamChanMap[quality]
+		if !ok || !open {
+			continue
+		}
+
+		select {
+		case qualityChannel <- frame:
+		default:
+			<-qualityChannel
+			qualityChannel <- frame
+		}
+	}
+}
+
+// OpenTimeShift replays the topic of the quality from the given time with a consumer of its own
+func (sc *KafkaStreamConnection) OpenTimeShift(quality consts.Quality, from time.Time) (consumer.TimeShiftStream, error) {
+	sc.Lock()
+	reader, ok := sc.topicReaders[quality]
+	source := sc.source
+	sc.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no topic for quality %d of stream %s", quality, sc.GetID())
 	}
 
-	streamConnection.kafkaConsumers[quality] = kafkaConsumer
-	streamConnection.streamChanMap[quality] = make(chan []byte, 4)
-	return &streamConnection
+	return source.openTimeShift(reader, quality, from)
 }
 
 func (sc *KafkaStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
 	ch, ok := sc.streamChanMap[quality]
 	if !ok {
-		return nil, fmt.Errorf("no stream for quality %s", quality)
+		return nil, fmt.Errorf("no stream for quality %d", quality)
 	}
 
 	return ch, nil
 }
 
-func (sc *KafkaStreamConnection) AddConnection(quality consts.Quality, kafkaConsumer interface{}) error {
-	consumer, ok := kafkaConsumer.(*cluster.Consumer)
-	if !ok {
-		return fmt.Errorf("second arfument must be of type (chan []byte)")
+// AddConnection adds another quality to the stream, the second argument is not used
+func (sc *KafkaStreamConnection) AddConnection(quality consts.Quality, _ interface{}) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, exists := sc.streamChanMap[quality]; exists {
+		return fmt.Errorf("stream %s already has quality %d", sc.GetID(), quality)
 	}
 
-	sc.kafkaConsumers[quality] = consumer
 	sc.streamChanMap[quality] = make(chan []byte, 4)
 	return nil
 }
 
-func (sc *KafkaStreamConnection) AddDataToStream(data []byte, quality consts.Quality) error {
+// AddDataToStream sends a message of a topic to the viewers of the quality.
+// Chunks are collected until their frame is complete, the info of the last chunk is the info of the frame.
+func (sc *KafkaStreamConnection) AddDataToStream(data []byte, info consumer.FrameInfo, quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
 	qualityChannel, ok := sc.streamChanMap[quality]
 	if !ok {
 		return fmt.Errorf("no stream for quality %d", quality)
 	}
 
-	qualityChannel <- data
+	if IsChunk(data) {
+		data = sc.reassemble(data, quality)
+		if data == nil {
+			return nil
+		}
+	}
+
+	data, valid := sc.ValidateFrame(data)
+	if !valid {
+		return nil
+	}
+
+	if sc.ObserveFrameInfo(quality, data, info) {
+		sc.lastKeyframes[quality] = data
+	}
+	sc.lastFrames[quality] = data
+
+	select {
+	case qualityChannel <- data:
+	default:
+		<-qualityChannel
+		qualityChannel <- data
+	}
 	return nil
 }
 
-func (sc *KafkaStreamConnection) HandleStream(quality consts.Quality) error {
-	sc.isOpen = true
-	consumer, ok := sc.kafkaConsumers[quality]
+// reassemble adds a chunk to the partial frames of the quality and returns the frame once it is complete, sc must be locked
+func (sc *KafkaStreamConnection) reassemble(chunk []byte, quality consts.Quality) []byte {
+	assembler, ok := sc.assemblers[quality]
 	if !ok {
-		return fmt.Errorf("no consumer for quality %s", quality)
+		assembler = newChunkAssembler(sc.chunkTimeout)
+		sc.assemblers[quality] = assembler
 	}
 
-	for msg := range consumer.Messages() {
-		select {
-		case sc.streamChanMap[quality] <- msg.Value:
-		default:
-			<-sc.streamChanMap[quality]
-			sc.streamChanMap[quality] <- msg.Value
-		}
+	frame, dropped, err := assembler.add(chunk, time.Now())
+	if dropped > 0 {
+		sc.IncrementStat(statPartialFramesDropped, uint64(dropped))
+	}
+	if err != nil {
+		fmt.Println("Dropping chunked frame of stream", sc.GetID(), err)
+		sc.IncrementStat(statCorruptChunks, 1)
+		return nil
+	}
+	if frame != nil {
+		sc.IncrementStat(statChunkedFrames, 1)
+	}
+
+	return frame
+}
+
+// HandleStream marks the quality as open, the messages are pushed by the topic readers
+func (sc *KafkaStreamConnection) HandleStream(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, ok := sc.streamChanMap[quality]; !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
 	}
+
+	sc.isOpen = true
 	return nil
 }
 
 func (sc *KafkaStreamConnection) Close(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
 	channel, ok := sc.streamChanMap[quality]
-	consumer, ok := sc.kafkaConsumers[quality]
 	if !ok {
 		return fmt.Errorf("No stream kafka consumer with quality %d", quality)
 	}
 
 	close(channel)
-	err := consumer.Close()
-	if err != nil {
-		return err
+	delete(sc.streamChanMap, quality)
+	delete(sc.topicReaders, quality)
+	delete(sc.assemblers, quality)
+	delete(sc.lastFrames, quality)
+	delete(sc.lastKeyframes, quality)
+
+	// stop the timers of topics that no longer feed the stream
+	for reader, timer := range sc.lingerTimers {
+		if !sc.readsTopic(reader) {
+			timer.Stop()
+			delete(sc.lingerTimers, reader)
+		}
+	}
+
+	if len(sc.streamChanMap) == 0 {
+		sc.isOpen = false
 	}
 
 	return nil
 }
 
 func (sc *KafkaStreamConnection) CloseAll() error {
-	for quality, _ := range sc.streamChanMap {
+	for _, quality := range sc.GetQualities() {
 		err := sc.Close(quality)
 		if err != nil {
 			return err
 		}
 	}
 
-	sc.isOpen = false
 	return nil
 }
 
 func (sc *KafkaStreamConnection) GetQualities() []consts.Quality {
+	sc.Lock()
+	defer sc.Unlock()
+
 	var qualities []consts.Quality
-	for key, _ := range sc.streamChanMap {
-		qualities = append(qualities, key)
+	for quality := range sc.streamChanMap {
+		qualities = append(qualities, quality)
 	}
 
 	return qualities
 }
 
+// readsTopic reports whether a quality is read from the topic, sc must be locked
+func (sc *KafkaStreamConnection) readsTopic(reader *topicReader) bool {
+	for _, qualityReader := range sc.topicReaders {
+		if qualityReader == reader {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasQuality reports whether the stream has a channel for the quality
+func (sc *KafkaStreamConnection) hasQuality(quality consts.Quality) bool {
+	sc.Lock()
+	defer sc.Unlock()
+
+	_, ok := sc.streamChanMap[quality]
+	return ok
+}
+
 func (sc *KafkaStreamConnection) IsOpen() bool {
+	sc.Lock()
+	defer sc.Unlock()
 	return sc.isOpen
 }
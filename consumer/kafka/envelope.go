@@ -0,0 +1,104 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record headers of the frame envelope, every header is optional.
+// Messages without headers are plain frames of older producers.
+const (
+	// headerTimestamp is the capture time in unix milliseconds or RFC3339
+	headerTimestamp = "timestamp"
+	// headerSequence counts the frames of the producer starting at 1
+	headerSequence = "sequence"
+	// headerKeyframe is true or false
+	headerKeyframe = "keyframe"
+	// headerResolution is <width>x<height>, e.g. 1920x1080
+	headerResolution = "resolution"
+)
+
+// checkCodec makes sure the codec header, if there is one, matches the stream type of the message
+func checkCodec(headers map[string]string, streamType consts.StreamType) error {
+	if codec, ok := headers[headerCodec]; ok && consts.StreamType(codec) != streamType {
+		return fmt.Errorf("%s header %s does not match the stream type %s", headerCodec, codec, streamType)
+	}
+	return nil
+}
+
+// frameInfo reads the envelope headers of a message. A malformed header leaves its
+// field at the zero value and is returned as an error, the other fields are still filled.
+func frameInfo(headers map[string]string) (consumer.FrameInfo, []error) {
+	var info consumer.FrameInfo
+	var errs []error
+
+	if timestamp, ok := headers[headerTimestamp]; ok {
+		captureTime, err := parseTimestamp(timestamp)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			info.CaptureTime = captureTime
+		}
+	}
+
+	if sequence, ok := headers[headerSequence]; ok {
+		number, err := strconv.ParseUint(sequence, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s header must be a positive number", headerSequence))
+		} else {
+			info.Sequence = number
+		}
+	}
+
+	if keyframe, ok := headers[headerKeyframe]; ok {
+		isKeyframe, err := strconv.ParseBool(keyframe)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s header must be true or false", headerKeyframe))
+		} else {
+			info.Keyframe = isKeyframe
+		}
+	}
+
+	if resolution, ok := headers[headerResolution]; ok {
+		width, height, err := parseResolution(resolution)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			info.Width, info.Height = width, height
+		}
+	}
+
+	return info, errs
+}
+
+func parseResolution(resolution string) (int, int, error) {
+	size := strings.SplitN(strings.ToLower(resolution), "x", 2)
+	if len(size) != 2 {
+		return 0, 0, fmt.Errorf("%s header must look like 1920x1080", headerResolution)
+	}
+
+	width, widthErr := strconv.Atoi(size[0])
+	height, heightErr := strconv.Atoi(size[1])
+	if widthErr != nil || heightErr != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("%s header must look like 1920x1080", headerResolution)
+	}
+
+	return width, height, nil
+}
+
+func parseTimestamp(timestamp string) (time.Time, error) {
+	if millis, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)), nil
+	}
+
+	captureTime, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s header must be unix milliseconds or RFC3339", headerTimestamp)
+	}
+
+	return captureTime, nil
+}
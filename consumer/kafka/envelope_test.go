@@ -0,0 +1,28 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameInfoKeepsValidHeadersNextToMalformedOnes(t *testing.T) {
+	info, errs := frameInfo(map[string]string{
+		headerTimestamp:  "1700000000000",
+		headerSequence:   "-1",
+		headerKeyframe:   "true",
+		headerResolution: "wide",
+	})
+
+	if len(errs) != 2 {
+		t.Fatalf("got errors %v, want one for the sequence and one for the resolution", errs)
+	}
+	if !info.CaptureTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("capture time is %s", info.CaptureTime)
+	}
+	if !info.Keyframe {
+		t.Error("keyframe header was ignored")
+	}
+	if info.Sequence != 0 || info.Width != 0 || info.Height != 0 {
+		t.Errorf("malformed headers were not left at zero: %+v", info)
+	}
+}
@@ -1,11 +1,12 @@
 package consumer
 
 import (
-	"StreamingServer/consts"
 	"StreamingServer/consumer"
 	"fmt"
 	"math/rand"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -20,14 +21,19 @@ const (
 	consumerOffsets = "__consumer_offsets"
 
 	// Version Keywords
-	version0_10_1 = "0.10.1"
+	version0_10_1 = "0.10.1.0"
 	version1_1_0  = "1.1.0"
 
 	// Keyword Args Keys
-	topicsKey         = "topics"
-	groupIDKey        = "group_id"
-	consumerOffsetKey = "consumer_offset"
-	backOffTimeKey    = "backoff_time"
+	topicsKey           = "topics"
+	topicPatternKey     = "topic_pattern"
+	refreshIntervalKey  = "refresh_interval"
+	topicTemplateKey    = "topic_template"
+	streamIDTemplateKey = "stream_id_template"
+	streamPrefixKey     = "stream_prefix"
+	groupIDKey          = "group_id"
+	consumerOffsetKey   = "consumer_offset"
+	backOffTimeKey      = "backoff_time"
 )
 
 type KafkaArgs map[string]string
@@ -49,90 +55,301 @@ func randomGroupID(len int) string {
 	return string(bytes)
 }
 
+// KafkaConsumer reads the streams from topics.
+// The topics are either listed in KafkaArgs["topics"] or discovered with the regular expression
+// in KafkaArgs["topic_pattern"], which is matched against the topics of the cluster every refresh_interval.
+// Topic names are mapped to streams with the template in KafkaArgs["topic_template"], see TopicNaming.
+// With KafkaArgs["on_demand"] a topic is only read while someone watches its qualities.
+// KafkaArgs["stream_prefix"] is put in front of every stream id, e.g. to tell the streams of several clusters apart.
 type KafkaConsumer struct {
-	topicStreams map[string]*KafkaStreamConnection
+	brokers         []string
+	groupID         string
+	config          *cluster.Config
+	liveConfig      *cluster.Config
+	naming          *TopicNaming
+	streamPrefix    string
+	topicPattern    *regexp.Regexp
+	refreshInterval time.Duration
+	chunkTimeout    time.Duration
+	stallTimeout    time.Duration
+	onDemand        bool
+	linger          time.Duration
+	client          sarama.Client
+	topicReaders    map[string]*topicReader
+	topicStreams    map[string]*KafkaStreamConnection
+	running         bool
+	stop            chan struct{}
+	sync.Mutex
 }
 
-// TODO add support for TLS Config
+// NewKafkaConsumer connects to the comma separated brokers, the args are checked with ParseKafkaArgs
 func NewKafkaConsumer(kafkaBrokers string, kwargs ...KafkaArgs) (*KafkaConsumer, error) {
 	var args KafkaArgs
 	if len(kwargs) > 0 {
 		args = kwargs[0]
 	}
 
-	config := cluster.NewConfig()
-	config.Config.Version = sarama.V0_10_1_0 // TODO add config key
-	config.Consumer.Return.Errors = false
-	config.Consumer.Fetch.Default = 1024 * 1024 * 2
+	kc, err := ParseKafkaArgs(args)
+	if err != nil {
+		return nil, err
+	}
 
-	consumerOffset := getOrDefault(consumerOffsetKey, defaultOffset, args)
-	switch consumerOffset {
-	case offsetOldest:
-		config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	case offsetNewest:
-		config.Consumer.Offsets.Initial = sarama.OffsetNewest
-	default:
-		return nil, fmt.Errorf("Offset must be either newest or oldest")
+	naming, err := NewTopicNaming(kc.TopicTemplate, kc.StreamIDTemplate)
+	if err != nil {
+		return nil, err
 	}
 
-	backoff, err := time.ParseDuration(getOrDefault(backOffTimeKey, "100ms", args))
+	// record headers exist since kafka 0.11
+	if naming.usesHeaders() && !kc.Version.IsAtLeast(sarama.V0_11_0_0) {
+		if kc.VersionSet {
+			return nil, fmt.Errorf("topic_template without {codec} or {rendition} reads record headers, which need version 0.11.0 or newer")
+		}
+		kc.Version = sarama.V0_11_0_0
+	}
+
+	config, err := kc.clusterConfig()
 	if err != nil {
-		config.Config.Consumer.Retry.Backoff = backoff
+		return nil, err
 	}
 
-	topics := getOrDefault(topicsKey, "", args)
-	if topics == "" {
-		return nil, fmt.Errorf("topics must exist in KafkaArgs as comma seperated string")
+	// resumed topics start at the newest messages
+	liveConfig := *config
+	liveConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+
+	sc := &KafkaConsumer{
+		brokers:      strings.Split(kafkaBrokers, ","),
+		groupID:      kc.GroupID,
+		config:       config,
+		liveConfig:   &liveConfig,
+		naming:       naming,
+		streamPrefix: kc.StreamPrefix,
+		chunkTimeout: kc.ChunkTimeout,
+		stallTimeout: kc.StallTimeout,
+		onDemand:     kc.OnDemand,
+		linger:       kc.Linger,
+		topicReaders: make(map[string]*topicReader),
+		topicStreams: make(map[string]*KafkaStreamConnection),
 	}
 
-	groupID := getOrDefault(groupIDKey, randomGroupID(6), args)
-	streamConnections := make(map[string]*KafkaStreamConnection)
-	for _, topic := range strings.Split(topics, ",") {
-		// Create Kafka Consumer
-		kafkaConsumer, err := cluster.NewConsumer(
-			strings.Split(kafkaBrokers, ","),
-			groupID,
-			[]string{topic},
-			config,
-		)
+	if kc.TopicPattern != "" {
+		sc.topicPattern, err = regexp.Compile(kc.TopicPattern)
+		if err != nil {
+			return nil, fmt.Errorf("topic_pattern is not a valid regular expression: %s", err)
+		}
+		sc.refreshInterval = kc.RefreshInterval
+
+		// the metadata client only lists the topics, every topic is read by its own consumer
+		sc.client, err = sarama.NewClient(sc.brokers, &config.Config)
+		if err != nil {
+			return nil, err
+		}
 
+		err = sc.refreshTopics()
 		if err != nil {
+			sc.client.Close()
 			return nil, err
 		}
+		return sc, nil
+	}
+
+	for _, topic := range kc.Topics {
+		err = sc.addTopic(topic)
+		if err != nil {
+			sc.Stop()
+			return nil, err
+		}
+	}
+
+	return sc, nil
+}
+
+// addTopic creates the reader of the topic.
+// The stream is registered right away if the topic name tells its type and quality,
+// otherwise once the first message arrives.
+func (sc *KafkaConsumer) addTopic(topic string) error {
+	name, err := sc.naming.Parse(topic)
+	if err != nil {
+		return err
+	}
+	name.StreamID = sc.streamPrefix + name.StreamID
+
+	// Create Kafka Consumer
+	kafkaConsumer, err := cluster.NewConsumer(sc.brokers, sc.groupID, []string{topic}, sc.config)
+	if err != nil {
+		return err
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	reader := newTopicReader(topic, name, kafkaConsumer)
+	sc.topicReaders[topic] = reader
+
+	if name.HasType && name.HasQuality {
+		_, err = sc.streamForName(reader, name)
+		if err != nil {
+			fmt.Println("Unable to add topic", topic, "to its stream", err)
+		}
+	}
+
+	if sc.running {
+		sc.startReader(reader)
+	}
+	return nil
+}
+
+// streamForName returns the stream of a topic name, it creates the stream and the quality if they do not exist yet.
+// sc must be locked.
+func (sc *KafkaConsumer) streamForName(reader *topicReader, name TopicName) (*KafkaStreamConnection, error) {
+	stream, ok := sc.topicStreams[name.StreamID]
+	if !ok {
+		stream = NewKafkaStreamConnection(name.StreamID, name.StreamType, sc.chunkTimeout)
+		sc.topicStreams[name.StreamID] = stream
+	}
+
+	if stream.GetType() != name.StreamType {
+		return nil, fmt.Errorf("stream %s is of type %s, not %s", name.StreamID, stream.GetType(), name.StreamType)
+	}
+
+	if !stream.hasQuality(name.Quality) {
+		stream.AddConnection(name.Quality, nil)
+		stream.HandleStream(name.Quality)
+		stream.setTopic(name.Quality, sc, reader)
+	}
+
+	return stream, nil
+}
+
+// removeTopic stops reading the topic and closes what was read from it.
+// A stream is removed once no topic is left that feeds it.
+func (sc *KafkaConsumer) removeTopic(topic string) {
+	sc.Lock()
+	reader, ok := sc.topicReaders[topic]
+	delete(sc.topicReaders, topic)
+	sc.Unlock()
+	if !ok {
+		return
+	}
+
+	fmt.Println("Removing topic", topic, "of stream", reader.name.StreamID)
+	err := reader.close()
+	if err != nil {
+		fmt.Println("Error closing consumer of topic", topic, err)
+	}
+	if reader.started {
+		<-reader.done
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	stream, ok := sc.topicStreams[reader.name.StreamID]
+	if !ok {
+		return
+	}
+
+	for _, other := range sc.topicReaders {
+		if other.name.StreamID != reader.name.StreamID {
+			continue
+		}
 
-		// Create/Add StreamConnection
-		streamInfo := strings.Split(topic, "_")
-		if len(streamInfo) < 2 {
-			return nil, fmt.Errorf("topic name needs to specify stream quality at the end seperated by '_'")
+		// the stream stays, only the quality of the topic is closed
+		if reader.name.HasQuality && other.name.HasQuality && other.name.Quality != reader.name.Quality {
+			stream.Close(reader.name.Quality)
 		}
+		return
+	}
 
-		streamName := strings.Join(streamInfo[:len(streamInfo)-2], "")
-		streamType := streamInfo[len(streamInfo)-2]
-		qualityString := streamInfo[len(streamInfo)-1]
+	stream.CloseAll()
+	delete(sc.topicStreams, reader.name.StreamID)
+}
 
-		_, exists := consts.StreamTypes[consts.StreamType(streamType)]
-		if !exists {
-			return nil, fmt.Errorf("no such stream type %s", streamType)
+// topics returns the topics that are read right now
+func (sc *KafkaConsumer) topics() map[string]bool {
+	sc.Lock()
+	defer sc.Unlock()
+
+	topics := make(map[string]bool)
+	for topic := range sc.topicReaders {
+		topics[topic] = true
+	}
+
+	return topics
+}
+
+// refreshTopics adds the topics matching the pattern that are not read yet and removes the deleted ones
+func (sc *KafkaConsumer) refreshTopics() error {
+	err := sc.client.RefreshMetadata()
+	if err != nil {
+		return err
+	}
+
+	clusterTopics, err := sc.client.Topics()
+	if err != nil {
+		return err
+	}
+
+	current := sc.topics()
+	matching := make(map[string]bool)
+	for _, topic := range clusterTopics {
+		if topic == consumerOffsets || !sc.topicPattern.MatchString(topic) {
+			continue
 		}
 
-		quality, err := consts.GetQualityFromString(qualityString)
+		matching[topic] = true
+		if current[topic] {
+			continue
+		}
+
+		fmt.Println("Discovered kafka topic", topic)
+		err = sc.addTopic(topic)
 		if err != nil {
-			return nil, err
+			fmt.Println("Unable to read topic", topic, err)
+		}
+	}
+
+	for topic := range current {
+		if !matching[topic] {
+			sc.removeTopic(topic)
+		}
+	}
+
+	return nil
+}
+
+func (sc *KafkaConsumer) refreshLoop(stop chan struct{}) {
+	ticker := time.NewTicker(sc.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := sc.refreshTopics()
+			if err != nil {
+				fmt.Println("Error refreshing kafka topics", err)
+			}
+		case <-stop:
+			return
 		}
+	}
+}
 
-		stream, ok := streamConnections[streamName]
-		if !ok {
-			stream = NewKafkaStreamConnection(streamName, consts.StreamType(streamType), kafkaConsumer, quality)
-			streamConnections[streamName] = stream
+// messageHeaders returns the record headers of a message by key
+func messageHeaders(msg *sarama.ConsumerMessage) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
 		}
-		stream.AddConnection(quality, kafkaConsumer)
 	}
 
-	consumer := KafkaConsumer{streamConnections}
-	return &consumer, nil
+	return headers
 }
 
 func (sc *KafkaConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
 	stream, ok := sc.topicStreams[streamID]
 	if !ok {
 		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
@@ -142,24 +359,38 @@ func (sc *KafkaConsumer) GetStream(streamID string) (consumer.StreamConnection,
 }
 
 func (sc *KafkaConsumer) Start() error {
-	for _, connection := range sc.topicStreams {
-		for _, quality := range connection.GetQualities() {
-			go func(c *KafkaStreamConnection) {
-				c.HandleStream(quality)
-			}(connection)
-		}
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.running = true
+	for _, reader := range sc.topicReaders {
+		sc.startReader(reader)
+	}
+
+	sc.stop = make(chan struct{})
+	go sc.monitorLoop(sc.stop)
+	if sc.topicPattern != nil {
+		go sc.refreshLoop(sc.stop)
 	}
 
 	return nil
 }
 
 func (sc *KafkaConsumer) Stop() error {
-	for _, connection := range sc.topicStreams {
-		err := connection.CloseAll()
-		if err != nil {
-			return err
-		}
+	sc.Lock()
+	sc.running = false
+	if sc.stop != nil {
+		close(sc.stop)
+		sc.stop = nil
 	}
+	sc.Unlock()
 
+	for topic := range sc.topics() {
+		sc.removeTopic(topic)
+	}
+
+	if sc.client != nil {
+		return sc.client.Close()
+	}
 	return nil
 }
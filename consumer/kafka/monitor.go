@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"StreamingServer/consumer"
+	"time"
+)
+
+// monitorInterval is how often the lag and the state of the topics are updated
+const monitorInterval = 5 * time.Second
+
+// stateRank orders the states of the topics of a stream, the stream has the state of its best topic
+var stateRank = map[consumer.StreamState]int{
+	consumer.StreamStalled:    0,
+	consumer.StreamPaused:     1,
+	consumer.StreamRecovering: 2,
+	consumer.StreamLive:       3,
+}
+
+func (sc *KafkaConsumer) monitorLoop(stop chan struct{}) {
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sc.monitor()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// monitor puts the lag of every topic into the stats of its stream and updates the state of the streams
+func (sc *KafkaConsumer) monitor() {
+	states := make(map[*KafkaStreamConnection]consumer.StreamState)
+	for _, reader := range sc.startedReaders() {
+		stream := sc.streamOf(reader)
+		if stream == nil {
+			continue
+		}
+
+		stream.SetStat(statLagPrefix+reader.topic, uint64(reader.lag()))
+
+		state := reader.state(sc.stallTimeout)
+		if current, ok := states[stream]; !ok || stateRank[state] > stateRank[current] {
+			states[stream] = state
+		}
+	}
+
+	for stream, state := range states {
+		stream.SetState(state)
+	}
+}
+
+// Lag returns the number of messages that are not read yet by topic
+func (sc *KafkaConsumer) Lag() map[string]int64 {
+	lag := make(map[string]int64)
+	for _, reader := range sc.startedReaders() {
+		lag[reader.topic] = reader.lag()
+	}
+
+	return lag
+}
+
+func (sc *KafkaConsumer) startedReaders() []*topicReader {
+	sc.Lock()
+	defer sc.Unlock()
+
+	var readers []*topicReader
+	for _, reader := range sc.topicReaders {
+		if reader.started {
+			readers = append(readers, reader)
+		}
+	}
+
+	return readers
+}
@@ -0,0 +1,300 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"StreamingServer/streamtype"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Template fields that are not part of the stream id
+	fieldCodec     = "codec"
+	fieldRendition = "rendition"
+
+	// Message headers that carry the stream type and rendition if the topic name does not
+	headerCodec     = "codec"
+	headerRendition = "rendition"
+)
+
+var templateField = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// TopicName is what a topic name tells about the stream that is read from it.
+// The stream type and rendition come from the message headers if the topic name does not contain them.
+type TopicName struct {
+	StreamID   string
+	StreamType consts.StreamType
+	Quality    consts.Quality
+	HasType    bool
+	HasQuality bool
+}
+
+// namingToken is either a literal part or a field of a template
+type namingToken struct {
+	literal string
+	field   string
+}
+
+// TopicNaming maps topic names to streams with a template like `{site}.{camera}.{codec}.{rendition}`.
+// The {codec} field is the stream type and {rendition} the quality (low or high), both are optional.
+// The stream id is made from the other fields, by default the template without codec and rendition, e.g. `{site}.{camera}`.
+type TopicNaming struct {
+	template       []namingToken
+	streamTemplate []namingToken
+	pattern        *regexp.Regexp
+	streamPattern  *regexp.Regexp
+}
+
+// NewTopicNaming compiles the topic template and the optional stream id template.
+// An empty topic template keeps the <stream>_<type>_<quality> names.
+func NewTopicNaming(template, streamIDTemplate string) (*TopicNaming, error) {
+	if template == "" {
+		if streamIDTemplate != "" {
+			return nil, fmt.Errorf("stream_id_template needs a topic_template")
+		}
+		return &TopicNaming{}, nil
+	}
+
+	tokens, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	pattern := "^"
+	for _, token := range tokens {
+		if token.field == "" {
+			pattern += regexp.QuoteMeta(token.literal)
+			continue
+		}
+
+		if fields[token.field] {
+			return nil, fmt.Errorf("topic_template uses the field {%s} twice", token.field)
+		}
+		fields[token.field] = true
+		pattern += "(.+?)"
+	}
+
+	naming := &TopicNaming{
+		template:       tokens,
+		streamTemplate: defaultStreamTemplate(tokens),
+		pattern:        regexp.MustCompile(pattern + "$"),
+	}
+
+	if streamIDTemplate != "" {
+		naming.streamTemplate, err = parseTemplate(streamIDTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	streamPattern := "^"
+	for _, token := range naming.streamTemplate {
+		if token.field == "" {
+			streamPattern += regexp.QuoteMeta(token.literal)
+			continue
+		}
+
+		if !fields[token.field] || token.field == fieldCodec || token.field == fieldRendition {
+			return nil, fmt.Errorf("stream_id_template can only use the fields of the topic_template besides codec and rendition, not {%s}", token.field)
+		}
+		streamPattern += "(.+?)"
+	}
+	naming.streamPattern = regexp.MustCompile(streamPattern + "$")
+
+	return naming, nil
+}
+
+func parseTemplate(template string) ([]namingToken, error) {
+	var tokens []namingToken
+	last := 0
+	for _, match := range templateField.FindAllStringSubmatchIndex(template, -1) {
+		if match[0] > last {
+			tokens = append(tokens, namingToken{literal: template[last:match[0]]})
+		} else if len(tokens) > 0 {
+			return nil, fmt.Errorf("fields of template '%s' must be separated", template)
+		}
+
+		field := template[match[2]:match[3]]
+		switch field {
+		case "type":
+			field = fieldCodec
+		case "quality":
+			field = fieldRendition
+		}
+		tokens = append(tokens, namingToken{field: field})
+		last = match[1]
+	}
+
+	if last < len(template) {
+		tokens = append(tokens, namingToken{literal: template[last:]})
+	}
+
+	for _, token := range tokens {
+		if strings.ContainsAny(token.literal, "{}") {
+			return nil, fmt.Errorf("template '%s' has an invalid field, fields are lower case like {camera}", template)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("template must not be empty")
+	}
+
+	return tokens, nil
+}
+
+// defaultStreamTemplate removes the codec and rendition fields together with the separator in front of them
+func defaultStreamTemplate(tokens []namingToken) []namingToken {
+	var streamTokens []namingToken
+	for index := 0; index < len(tokens); index++ {
+		token := tokens[index]
+		if token.field == fieldCodec || token.field == fieldRendition {
+			if len(streamTokens) > 0 && streamTokens[len(streamTokens)-1].field == "" {
+				streamTokens = streamTokens[:len(streamTokens)-1]
+			} else if index+1 < len(tokens) && tokens[index+1].field == "" {
+				index++
+			}
+			continue
+		}
+
+		streamTokens = append(streamTokens, token)
+	}
+
+	return streamTokens
+}
+
+// Parse returns the stream a topic belongs to
+func (tn *TopicNaming) Parse(topic string) (TopicName, error) {
+	if tn.pattern == nil {
+		streamID, streamType, quality, err := consumer.ParseStreamName(topic)
+		if err != nil {
+			return TopicName{}, err
+		}
+		return TopicName{StreamID: streamID, StreamType: streamType, Quality: quality, HasType: true, HasQuality: true}, nil
+	}
+
+	match := tn.pattern.FindStringSubmatch(topic)
+	if match == nil {
+		return TopicName{}, fmt.Errorf("topic '%s' does not match the topic template", topic)
+	}
+
+	values := make(map[string]string)
+	index := 1
+	for _, token := range tn.template {
+		if token.field != "" {
+			values[token.field] = match[index]
+			index++
+		}
+	}
+
+	var name TopicName
+	for _, token := range tn.streamTemplate {
+		name.StreamID += token.literal + values[token.field]
+	}
+
+	if codec, ok := values[fieldCodec]; ok {
+		name.StreamType = consts.StreamType(codec)
+		name.HasType = true
+		if !streamtype.IsRegistered(name.StreamType) {
+			return TopicName{}, fmt.Errorf("no such stream type %s", codec)
+		}
+	}
+
+	if rendition, ok := values[fieldRendition]; ok {
+		quality, err := consts.GetQualityFromString(rendition)
+		if err != nil {
+			return TopicName{}, err
+		}
+		name.Quality = quality
+		name.HasQuality = true
+	}
+
+	return name, nil
+}
+
+// Format returns the topic of a stream quality, it is the reverse of Parse.
+// The fields of the topic template are taken from the stream id, codec and rendition from the stream type and quality.
+func (tn *TopicNaming) Format(streamID string, streamType consts.StreamType, quality consts.Quality) (string, error) {
+	rendition, err := consts.GetStringFromQuality(quality)
+	if err != nil {
+		return "", err
+	}
+
+	if tn.pattern == nil {
+		return fmt.Sprintf("%s_%s_%s", streamID, streamType, rendition), nil
+	}
+
+	match := tn.streamPattern.FindStringSubmatch(streamID)
+	if match == nil {
+		return "", fmt.Errorf("stream id '%s' does not match the stream id template", streamID)
+	}
+
+	values := map[string]string{fieldCodec: string(streamType), fieldRendition: rendition}
+	index := 1
+	for _, token := range tn.streamTemplate {
+		if token.field != "" {
+			values[token.field] = match[index]
+			index++
+		}
+	}
+
+	var topic string
+	for _, token := range tn.template {
+		if token.field == "" {
+			topic += token.literal
+			continue
+		}
+
+		value, ok := values[token.field]
+		if !ok {
+			return "", fmt.Errorf("field {%s} of the topic template is not part of the stream id", token.field)
+		}
+		topic += value
+	}
+
+	return topic, nil
+}
+
+// usesHeaders reports whether the stream type or rendition come from the message headers
+func (tn *TopicNaming) usesHeaders() bool {
+	if tn.pattern == nil {
+		return false
+	}
+
+	fields := make(map[string]bool)
+	for _, token := range tn.template {
+		fields[token.field] = true
+	}
+
+	return !fields[fieldCodec] || !fields[fieldRendition]
+}
+
+// fromHeaders fills the stream type and quality that the topic name does not contain from the message headers.
+// Messages without rendition header are low quality.
+func (name TopicName) fromHeaders(headers map[string]string) (TopicName, error) {
+	if !name.HasType {
+		codec, ok := headers[headerCodec]
+		if !ok {
+			return name, fmt.Errorf("message has no %s header", headerCodec)
+		}
+		name.StreamType = consts.StreamType(codec)
+		if !streamtype.IsRegistered(name.StreamType) {
+			return name, fmt.Errorf("no such stream type %s", codec)
+		}
+	}
+
+	if !name.HasQuality {
+		name.Quality = consts.LowQuality
+		if rendition, ok := headers[headerRendition]; ok {
+			quality, err := consts.GetQualityFromString(rendition)
+			if err != nil {
+				return name, err
+			}
+			name.Quality = quality
+		}
+	}
+
+	return name, nil
+}
@@ -0,0 +1,192 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// maxRecordOverhead is what sarama adds to the key, value and headers of a record when it checks
+// the size of a message against Producer.MaxMessageBytes, it mirrors sarama's maximumRecordOverhead
+const maxRecordOverhead = 5*binary.MaxVarintLen32 + binary.MaxVarintLen64 + 1
+
+// KafkaProducer is a frame sink that republishes the frames of all streams to kafka,
+// using the same topic naming and headers the KafkaConsumer reads
+type KafkaProducer struct {
+	producer       sarama.AsyncProducer
+	naming         *TopicNaming
+	maxMessageSize int
+	frameID        uint64
+	// sequences counts the frames of each topic for frames without a sequence number
+	sequences map[string]uint64
+	drops     uint64
+	sync.Mutex
+}
+
+// NewKafkaProducer connects to the brokers, it accepts the naming, version and security keys of the KafkaConsumer
+// and max_message_size
+func NewKafkaProducer(brokers string, kwargs ...KafkaArgs) (*KafkaProducer, error) {
+	args := KafkaArgs{}
+	if len(kwargs) > 0 {
+		args = kwargs[0]
+	}
+
+	kc, err := ParseKafkaProducerArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	naming, err := NewTopicNaming(kc.TopicTemplate, kc.StreamIDTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	// the frame metadata is sent as record headers
+	if kc.VersionSet && !kc.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, fmt.Errorf("the producer needs at least kafka version 0.11.0.0 for record headers")
+	}
+	if !kc.Version.IsAtLeast(sarama.V0_11_0_0) {
+		kc.Version = sarama.V0_11_0_0
+	}
+
+	config, err := kc.producerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducer(strings.Split(brokers, ","), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKafkaProducer(producer, naming, int(kc.MaxMessageSize)), nil
+}
+
+func newKafkaProducer(producer sarama.AsyncProducer, naming *TopicNaming, maxMessageSize int) *KafkaProducer {
+	kp := &KafkaProducer{
+		producer:       producer,
+		naming:         naming,
+		maxMessageSize: maxMessageSize,
+		// frame ids must not repeat after a restart, otherwise chunks of different frames could be mixed up
+		frameID:   uint64(time.Now().UnixNano()),
+		sequences: make(map[string]uint64),
+	}
+
+	go kp.watchErrors()
+	return kp
+}
+
+// WriteFrame sends the frame to the topic of its stream and quality, it drops the frame if the producer is busy
+func (kp *KafkaProducer) WriteFrame(streamID string, streamType consts.StreamType, quality consts.Quality, frame []byte, info consumer.FrameInfo) {
+	topic, err := kp.naming.Format(streamID, streamType, quality)
+	if err != nil {
+		fmt.Println("Unable to produce frame of stream", streamID, err)
+		return
+	}
+
+	rendition, err := consts.GetStringFromQuality(quality)
+	if err != nil {
+		fmt.Println("Unable to produce frame of stream", streamID, err)
+		return
+	}
+
+	kp.Lock()
+	kp.frameID++
+	frameID := kp.frameID
+	kp.sequences[topic]++
+	sequence := kp.sequences[topic]
+	kp.Unlock()
+
+	if info.Sequence > 0 {
+		sequence = info.Sequence
+	}
+
+	captureTime := info.CaptureTime
+	if captureTime.IsZero() {
+		captureTime = time.Now()
+	}
+
+	headers := []sarama.RecordHeader{
+		{Key: []byte(headerCodec), Value: []byte(streamType)},
+		{Key: []byte(headerRendition), Value: []byte(rendition)},
+		{Key: []byte(headerTimestamp), Value: []byte(strconv.FormatInt(captureTime.UnixNano()/int64(time.Millisecond), 10))},
+		{Key: []byte(headerSequence), Value: []byte(strconv.FormatUint(sequence, 10))},
+	}
+	if info.Keyframe {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(headerKeyframe), Value: []byte("true")})
+	}
+	if info.Width > 0 && info.Height > 0 {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(headerResolution), Value: []byte(fmt.Sprintf("%dx%d", info.Width, info.Height))})
+	}
+
+	key := sarama.StringEncoder(streamID)
+	chunks, err := ChunkFrame(frame, frameID, kp.maxMessageSize-messageOverhead(key, headers))
+	if err != nil {
+		fmt.Println("Unable to produce frame of stream", streamID, err)
+		return
+	}
+
+	for _, chunk := range chunks {
+		msg := &sarama.ProducerMessage{
+			Topic:   topic,
+			Key:     key,
+			Value:   sarama.ByteEncoder(chunk),
+			Headers: headers,
+		}
+
+		select {
+		case kp.producer.Input() <- msg:
+		default:
+			// the rest of a chunked frame is useless, the consumer drops the partial frame
+			kp.drop(topic)
+			return
+		}
+	}
+}
+
+// messageOverhead is the part of the message size that sarama counts besides the value
+func messageOverhead(key sarama.Encoder, headers []sarama.RecordHeader) int {
+	overhead := maxRecordOverhead + key.Length()
+	for _, header := range headers {
+		overhead += len(header.Key) + len(header.Value) + 2*binary.MaxVarintLen32
+	}
+	return overhead
+}
+
+// Drops returns the number of frames that were dropped because the producer was busy
+func (kp *KafkaProducer) Drops() uint64 {
+	kp.Lock()
+	defer kp.Unlock()
+	return kp.drops
+}
+
+func (kp *KafkaProducer) drop(topic string) {
+	kp.Lock()
+	kp.drops++
+	drops := kp.drops
+	kp.Unlock()
+
+	// log only every 100th drop, a slow broker drops a lot of frames
+	if drops%100 == 1 {
+		fmt.Printf("Kafka producer is busy, dropped frame of topic %s, %d frames dropped so far\n", topic, drops)
+	}
+}
+
+// watchErrors logs the failed messages until the producer is closed
+func (kp *KafkaProducer) watchErrors() {
+	for err := range kp.producer.Errors() {
+		fmt.Println("Unable to produce message to topic", err.Msg.Topic, err.Err)
+	}
+}
+
+// Close waits until the buffered messages are sent and closes the producer
+func (kp *KafkaProducer) Close() error {
+	return kp.producer.Close()
+}
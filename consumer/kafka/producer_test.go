@@ -0,0 +1,90 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// recordSize is how sarama sizes a message before it compares it with Producer.MaxMessageBytes
+func recordSize(t *testing.T, msg *sarama.ProducerMessage) int {
+	size := 5*binary.MaxVarintLen32 + binary.MaxVarintLen64 + 1
+	for _, header := range msg.Headers {
+		size += len(header.Key) + len(header.Value) + 2*binary.MaxVarintLen32
+	}
+
+	key, err := msg.Key.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size + len(key) + msg.Value.Length()
+}
+
+func TestKafkaProducerChunksFitTheMaxMessageSize(t *testing.T) {
+	kc, err := ParseKafkaProducerArgs(KafkaArgs{"max_message_size": "100000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := kc.producerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mock hands the sent messages back as successes
+	config.Producer.Return.Successes = true
+
+	naming, err := NewTopicNaming("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two and a half times the message size is three chunks whatever the headers add
+	const chunks = 3
+	mockProducer := mocks.NewAsyncProducer(t, config)
+	for i := 0; i < chunks; i++ {
+		mockProducer.ExpectInputAndSucceed()
+	}
+	kp := newKafkaProducer(mockProducer, naming, int(kc.MaxMessageSize))
+	defer kp.Close()
+
+	frame := bytes.Repeat([]byte{0x42}, 250000)
+	info := consumer.FrameInfo{CaptureTime: time.Now(), Sequence: 7, Keyframe: true, Width: 1920, Height: 1080}
+	kp.WriteFrame("front_door", consts.StreamH264, consts.HighQuality, frame, info)
+
+	assembler := newChunkAssembler(defaultChunkTimeout)
+	for i := 0; i < chunks; i++ {
+		var msg *sarama.ProducerMessage
+		select {
+		case msg = <-mockProducer.Successes():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d chunks", i, chunks)
+		}
+
+		if size := recordSize(t, msg); size > config.Producer.MaxMessageBytes {
+			t.Fatalf("chunk %d is %d bytes, the producer only accepts %d", i, size, config.Producer.MaxMessageBytes)
+		}
+		if msg.Topic != "front_door_h264_high" {
+			t.Errorf("chunk %d went to topic %s", i, msg.Topic)
+		}
+
+		value, err := msg.Value.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		whole, _, err := assembler.add(value, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < chunks-1 && whole != nil {
+			t.Fatalf("frame was complete after %d chunks", i+1)
+		}
+		if i == chunks-1 && !bytes.Equal(whole, frame) {
+			t.Fatal("reassembled frame differs from the sent one")
+		}
+	}
+}
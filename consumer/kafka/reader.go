@@ -0,0 +1,413 @@
+package consumer
+
+import (
+	"StreamingServer/consumer"
+	"fmt"
+	"sync"
+	"time"
+
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+const (
+	// The consumer of a topic whose messages stop is recreated with a backoff between these durations
+	minRecoveryBackoff = time.Second
+	maxRecoveryBackoff = 30 * time.Second
+
+	// Stats of the stream metadata
+	statConsumerErrors  = "consumer_errors"
+	statRebalances      = "rebalances"
+	statRebalanceErrors = "rebalance_errors"
+	statRecoveries      = "recoveries"
+	statLagPrefix       = "lag_"
+)
+
+// topicReader reads the messages of a single topic and pushes them into its stream
+type topicReader struct {
+	topic    string
+	name     TopicName
+	consumer *cluster.Consumer
+	done     chan struct{}
+	stop     chan struct{}
+	// started is protected by the lock of the KafkaConsumer
+	started bool
+
+	// offsets holds the offset of the last message read from each partition
+	offsets     map[int32]int64
+	lastMessage time.Time
+	recovering  bool
+	// paused readers have no consumer, wake tells them to resume
+	paused bool
+	wake   chan struct{}
+	sync.Mutex
+}
+
+func newTopicReader(topic string, name TopicName, kafkaConsumer *cluster.Consumer) *topicReader {
+	return &topicReader{
+		topic:    topic,
+		name:     name,
+		consumer: kafkaConsumer,
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+		offsets:  make(map[int32]int64),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// startReader starts reading the topic, sc must be locked
+func (sc *KafkaConsumer) startReader(reader *topicReader) {
+	if reader.started {
+		return
+	}
+
+	reader.started = true
+	go sc.readTopic(reader)
+}
+
+// readTopic pushes the messages of the topic into their stream until the reader is closed.
+// If the messages stop before, the consumer is recreated.
+// Paused readers wait until they are resumed and start again at the newest messages.
+func (sc *KafkaConsumer) readTopic(reader *topicReader) {
+	defer close(reader.done)
+
+	for {
+		kafkaConsumer := reader.getConsumer()
+		if kafkaConsumer == nil {
+			if !reader.waitForResume() {
+				return
+			}
+
+			fmt.Println("Resuming topic", reader.topic)
+			if !sc.reconnect(reader, sc.liveConfig) {
+				return
+			}
+			continue
+		}
+
+		go sc.watchErrors(reader, kafkaConsumer)
+		go sc.watchNotifications(reader, kafkaConsumer)
+		sc.consumeMessages(reader, kafkaConsumer)
+
+		if reader.isClosed() {
+			return
+		}
+		if reader.getConsumer() == nil {
+			// the consumer was closed by pause
+			continue
+		}
+
+		fmt.Println("Messages of topic", reader.topic, "stopped, recovering")
+		reader.setRecovering()
+		kafkaConsumer.Close()
+
+		select {
+		case <-time.After(minRecoveryBackoff):
+		case <-reader.stop:
+			return
+		}
+
+		if !sc.reconnect(reader, sc.config) {
+			return
+		}
+		if reader.getConsumer() == nil {
+			// paused while recovering
+			continue
+		}
+
+		fmt.Println("Recovered consumer of topic", reader.topic)
+		sc.incrementStat(reader, statRecoveries)
+	}
+}
+
+// reconnect creates a new consumer for the reader with a growing backoff, unless the reader is paused.
+// It returns false if the reader was closed in the meantime.
+func (sc *KafkaConsumer) reconnect(reader *topicReader, config *cluster.Config) bool {
+	backoff := minRecoveryBackoff
+	for !reader.isPaused() {
+		newConsumer, err := cluster.NewConsumer(sc.brokers, sc.groupID, []string{reader.topic}, config)
+		if err == nil {
+			if !reader.replaceConsumer(newConsumer) {
+				newConsumer.Close()
+				return !reader.isClosed()
+			}
+			return true
+		}
+
+		fmt.Println("Unable to create consumer of topic", reader.topic, err)
+		select {
+		case <-time.After(backoff):
+		case <-reader.stop:
+			return false
+		}
+
+		backoff *= 2
+		if backoff > maxRecoveryBackoff {
+			backoff = maxRecoveryBackoff
+		}
+	}
+
+	return true
+}
+
+// consumeMessages pushes the messages of the consumer into their stream until the consumer stops
+func (sc *KafkaConsumer) consumeMessages(reader *topicReader, kafkaConsumer *cluster.Consumer) {
+	var stream *KafkaStreamConnection
+	for msg := range kafkaConsumer.Messages() {
+		reader.observe(msg.Partition, msg.Offset)
+
+		headers := messageHeaders(msg)
+		name := reader.name
+		if !name.HasType || !name.HasQuality {
+			var err error
+			name, err = name.fromHeaders(headers)
+			if err != nil {
+				fmt.Println("Dropping message of topic", reader.topic, err)
+				continue
+			}
+		}
+
+		if err := checkCodec(headers, name.StreamType); err != nil {
+			fmt.Println("Dropping message of topic", reader.topic, err)
+			continue
+		}
+
+		// the frame itself is fine, only the metadata of a malformed header is lost
+		info, headerErrs := frameInfo(headers)
+		for _, err := range headerErrs {
+			fmt.Println("Ignoring header of message of topic", reader.topic, err)
+		}
+
+		if stream == nil || !stream.hasQuality(name.Quality) {
+			var err error
+			sc.Lock()
+			stream, err = sc.streamForName(reader, name)
+			sc.Unlock()
+			if err != nil {
+				fmt.Println("Dropping message of topic", reader.topic, err)
+				continue
+			}
+		}
+
+		stream.AddDataToStream(msg.Value, info, name.Quality)
+	}
+}
+
+// watchErrors logs the errors of the consumer until it is closed
+func (sc *KafkaConsumer) watchErrors(reader *topicReader, kafkaConsumer *cluster.Consumer) {
+	for err := range kafkaConsumer.Errors() {
+		fmt.Println("Error consuming topic", reader.topic, err)
+		sc.incrementStat(reader, statConsumerErrors)
+	}
+}
+
+// watchNotifications logs the rebalances of the consumer group until the consumer is closed
+func (sc *KafkaConsumer) watchNotifications(reader *topicReader, kafkaConsumer *cluster.Consumer) {
+	for notification := range kafkaConsumer.Notifications() {
+		fmt.Printf("Rebalance of topic %s: %s, claimed %v, released %v, current %v\n",
+			reader.topic,
+			notification.Type,
+			notification.Claimed[reader.topic],
+			notification.Released[reader.topic],
+			notification.Current[reader.topic],
+		)
+
+		switch notification.Type {
+		case cluster.RebalanceOK:
+			sc.incrementStat(reader, statRebalances)
+			reader.keepPartitions(notification.Current[reader.topic])
+		case cluster.RebalanceError:
+			sc.incrementStat(reader, statRebalanceErrors)
+		}
+	}
+}
+
+// streamOf returns the stream the topic feeds, or nil if it has none yet
+func (sc *KafkaConsumer) streamOf(reader *topicReader) *KafkaStreamConnection {
+	sc.Lock()
+	defer sc.Unlock()
+
+	return sc.topicStreams[reader.name.StreamID]
+}
+
+func (sc *KafkaConsumer) incrementStat(reader *topicReader, name string) {
+	if stream := sc.streamOf(reader); stream != nil {
+		stream.IncrementStat(name, 1)
+	}
+}
+
+func (reader *topicReader) getConsumer() *cluster.Consumer {
+	reader.Lock()
+	defer reader.Unlock()
+	return reader.consumer
+}
+
+func (reader *topicReader) observe(partition int32, offset int64) {
+	reader.Lock()
+	defer reader.Unlock()
+
+	reader.offsets[partition] = offset
+	reader.lastMessage = time.Now()
+}
+
+// keepPartitions forgets the offsets of the partitions that went to other members of the group
+func (reader *topicReader) keepPartitions(partitions []int32) {
+	reader.Lock()
+	defer reader.Unlock()
+
+	current := make(map[int32]bool)
+	for _, partition := range partitions {
+		current[partition] = true
+	}
+
+	for partition := range reader.offsets {
+		if !current[partition] {
+			delete(reader.offsets, partition)
+		}
+	}
+}
+
+func (reader *topicReader) setRecovering() {
+	reader.Lock()
+	defer reader.Unlock()
+	reader.recovering = true
+}
+
+// replaceConsumer sets the recovered consumer, it returns false if the reader was closed or paused in the meantime
+func (reader *topicReader) replaceConsumer(kafkaConsumer *cluster.Consumer) bool {
+	reader.Lock()
+	defer reader.Unlock()
+
+	if reader.isClosed() || reader.paused {
+		return false
+	}
+
+	reader.consumer = kafkaConsumer
+	reader.offsets = make(map[int32]int64)
+	reader.recovering = false
+	return true
+}
+
+// pause closes the consumer of the reader, it returns false if the reader is paused already
+func (reader *topicReader) pause() bool {
+	reader.Lock()
+	if reader.paused || reader.isClosed() {
+		reader.Unlock()
+		return false
+	}
+
+	reader.paused = true
+	reader.recovering = false
+	kafkaConsumer := reader.consumer
+	reader.consumer = nil
+	reader.offsets = make(map[int32]int64)
+	reader.Unlock()
+
+	// a recovering reader may have no consumer
+	if kafkaConsumer != nil {
+		err := kafkaConsumer.Close()
+		if err != nil {
+			fmt.Println("Error closing consumer of topic", reader.topic, err)
+		}
+	}
+	return true
+}
+
+// resume tells a paused reader to create a new consumer, it returns false if the reader was not paused
+func (reader *topicReader) resume() bool {
+	reader.Lock()
+	defer reader.Unlock()
+
+	if !reader.paused {
+		return false
+	}
+
+	reader.paused = false
+	select {
+	case reader.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (reader *topicReader) isPaused() bool {
+	reader.Lock()
+	defer reader.Unlock()
+	return reader.paused
+}
+
+// waitForResume blocks while the reader is paused, it returns false if the reader was closed
+func (reader *topicReader) waitForResume() bool {
+	for reader.isPaused() {
+		select {
+		case <-reader.wake:
+		case <-reader.stop:
+			return false
+		}
+	}
+
+	return !reader.isClosed()
+}
+
+func (reader *topicReader) isClosed() bool {
+	select {
+	case <-reader.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops reading the topic, the reader must not be closed twice
+func (reader *topicReader) close() error {
+	reader.Lock()
+	close(reader.stop)
+	kafkaConsumer := reader.consumer
+	reader.Unlock()
+
+	if kafkaConsumer == nil {
+		return nil
+	}
+	return kafkaConsumer.Close()
+}
+
+// lag returns how many messages of the partitions the reader holds are not read yet
+func (reader *topicReader) lag() int64 {
+	reader.Lock()
+	kafkaConsumer := reader.consumer
+	offsets := make(map[int32]int64)
+	for partition, offset := range reader.offsets {
+		offsets[partition] = offset
+	}
+	reader.Unlock()
+
+	if kafkaConsumer == nil {
+		return 0
+	}
+
+	var lag int64
+	for partition, highWaterMark := range kafkaConsumer.HighWaterMarks()[reader.topic] {
+		offset, ok := offsets[partition]
+		if ok && highWaterMark > offset+1 {
+			lag += highWaterMark - offset - 1
+		}
+	}
+
+	return lag
+}
+
+// state tells whether the topic delivers messages
+func (reader *topicReader) state(stallTimeout time.Duration) consumer.StreamState {
+	reader.Lock()
+	defer reader.Unlock()
+
+	switch {
+	case reader.paused:
+		return consumer.StreamPaused
+	case reader.recovering:
+		return consumer.StreamRecovering
+	case time.Since(reader.lastMessage) > stallTimeout:
+		return consumer.StreamStalled
+	default:
+		return consumer.StreamLive
+	}
+}
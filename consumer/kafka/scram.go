@@ -0,0 +1,38 @@
+package consumer
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	"github.com/xdg/scram"
+)
+
+var (
+	scramSHA256 scram.HashGeneratorFcn = func() hash.Hash { return sha256.New() }
+	scramSHA512 scram.HashGeneratorFcn = func() hash.Hash { return sha512.New() }
+)
+
+// scramClient implements sarama.SCRAMClient for the SCRAM-SHA-256 and SCRAM-SHA-512 mechanisms
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+func (sc *scramClient) Begin(userName, password, authzID string) error {
+	client, err := sc.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	sc.conversation = client.NewConversation()
+	return nil
+}
+
+func (sc *scramClient) Step(challenge string) (string, error) {
+	return sc.conversation.Step(challenge)
+}
+
+func (sc *scramClient) Done() bool {
+	return sc.conversation.Done()
+}
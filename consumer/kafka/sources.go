@@ -0,0 +1,159 @@
+package consumer
+
+import (
+	"StreamingServer/consumer"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// KafkaSource is a named kafka cluster, e.g. the cluster of one site
+type KafkaSource struct {
+	Name    string `json:"name"`
+	Brokers string `json:"brokers"`
+	// Prefix is put in front of the stream ids of the source, it is "<name>/" if it is not set
+	Prefix *string `json:"prefix,omitempty"`
+	// Args are the KafkaArgs of the source, e.g. its topics and security
+	Args KafkaArgs `json:"args"`
+}
+
+// LoadKafkaSources reads a json list of KafkaSource from a file
+func LoadKafkaSources(path string) ([]KafkaSource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []KafkaSource
+	err = json.Unmarshal(data, &sources)
+	if err != nil {
+		return nil, fmt.Errorf("kafka sources in %s are not valid: %s", path, err)
+	}
+
+	return sources, nil
+}
+
+// prefix returns the prefix of the stream ids of the source
+func (source KafkaSource) prefix() string {
+	if source.Prefix != nil {
+		return *source.Prefix
+	}
+	return source.Name + "/"
+}
+
+type namedKafkaConsumer struct {
+	name   string
+	prefix string
+	*KafkaConsumer
+}
+
+// MultiKafkaConsumer reads the streams of several kafka sources.
+// Every source has a KafkaConsumer of its own and its streams are told apart by the prefix of their ids.
+type MultiKafkaConsumer struct {
+	// consumers are sorted by the length of their prefix, the longest first
+	consumers []namedKafkaConsumer
+}
+
+// NewMultiKafkaConsumer connects to all sources, the names of the sources must be unique
+func NewMultiKafkaConsumer(sources []KafkaSource) (*MultiKafkaConsumer, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no kafka sources")
+	}
+
+	mc := &MultiKafkaConsumer{}
+	names := make(map[string]bool)
+	prefixes := make(map[string]string)
+	for _, source := range sources {
+		if source.Name == "" || source.Brokers == "" {
+			mc.Stop()
+			return nil, fmt.Errorf("every kafka source needs a name and brokers")
+		}
+		if names[source.Name] {
+			mc.Stop()
+			return nil, fmt.Errorf("kafka source %s exists twice", source.Name)
+		}
+		names[source.Name] = true
+
+		prefix := source.prefix()
+		if other, ok := prefixes[prefix]; ok {
+			mc.Stop()
+			return nil, fmt.Errorf("kafka sources %s and %s have the same prefix '%s'", other, source.Name, prefix)
+		}
+		prefixes[prefix] = source.Name
+
+		args := KafkaArgs{}
+		for key, value := range source.Args {
+			args[key] = value
+		}
+		if _, ok := args[streamPrefixKey]; ok {
+			mc.Stop()
+			return nil, fmt.Errorf("kafka source %s sets %s in its args, use prefix instead", source.Name, streamPrefixKey)
+		}
+		args[streamPrefixKey] = prefix
+
+		kafkaConsumer, err := NewKafkaConsumer(source.Brokers, args)
+		if err != nil {
+			mc.Stop()
+			return nil, fmt.Errorf("kafka source %s: %s", source.Name, err)
+		}
+
+		mc.consumers = append(mc.consumers, namedKafkaConsumer{name: source.Name, prefix: prefix, KafkaConsumer: kafkaConsumer})
+	}
+
+	sort.SliceStable(mc.consumers, func(i, j int) bool {
+		return len(mc.consumers[i].prefix) > len(mc.consumers[j].prefix)
+	})
+	return mc, nil
+}
+
+// GetStream looks for the stream in the sources whose prefix the stream id starts with
+func (mc *MultiKafkaConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	for _, kafkaConsumer := range mc.consumers {
+		if !strings.HasPrefix(streamID, kafkaConsumer.prefix) {
+			continue
+		}
+
+		stream, err := kafkaConsumer.GetStream(streamID)
+		if err == nil {
+			return stream, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No stream registered with id '%s' in any kafka source", streamID)
+}
+
+// Lag returns the number of messages that are not read yet by source and topic
+func (mc *MultiKafkaConsumer) Lag() map[string]map[string]int64 {
+	lag := make(map[string]map[string]int64)
+	for _, kafkaConsumer := range mc.consumers {
+		lag[kafkaConsumer.name] = kafkaConsumer.Lag()
+	}
+
+	return lag
+}
+
+func (mc *MultiKafkaConsumer) Start() error {
+	for _, kafkaConsumer := range mc.consumers {
+		err := kafkaConsumer.Start()
+		if err != nil {
+			return fmt.Errorf("kafka source %s: %s", kafkaConsumer.name, err)
+		}
+	}
+
+	return nil
+}
+
+// Stop stops all sources, it returns the first error
+func (mc *MultiKafkaConsumer) Stop() error {
+	var firstErr error
+	for _, kafkaConsumer := range mc.consumers {
+		err := kafkaConsumer.Stop()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("kafka source %s: %s", kafkaConsumer.name, err)
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,242 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/streamtype"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafkaTimeShift replays a topic from a point in the past with its own partition consumers,
+// outside of the consumer group of the live stream
+type kafkaTimeShift struct {
+	frames     chan []byte
+	messages   chan *sarama.ConsumerMessage
+	consumer   sarama.Consumer
+	partitions []sarama.PartitionConsumer
+	name       TopicName
+	quality    consts.Quality
+	assembler  *chunkAssembler
+	catchUp    chan struct{}
+	catchUpOne sync.Once
+	stop       chan struct{}
+	closeOnce  sync.Once
+	readers    sync.WaitGroup
+	// inspector collects the parameter sets of the frames that are skipped before the first keyframe
+	inspector streamtype.FrameInspector
+}
+
+// openTimeShift starts reading every partition of the topic at the first message written at or after from
+func (sc *KafkaConsumer) openTimeShift(reader *topicReader, quality consts.Quality, from time.Time) (*kafkaTimeShift, error) {
+	client, err := sc.metadataClient()
+	if err != nil {
+		return nil, err
+	}
+
+	partitions, err := client.Partitions(reader.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaConsumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &kafkaTimeShift{
+		frames:    make(chan []byte, 4),
+		messages:  make(chan *sarama.ConsumerMessage, 16),
+		consumer:  kafkaConsumer,
+		name:      reader.name,
+		quality:   quality,
+		assembler: newChunkAssembler(sc.chunkTimeout),
+		catchUp:   make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+
+	timestamp := from.UnixNano() / int64(time.Millisecond)
+	for _, partition := range partitions {
+		offset, err := client.GetOffset(reader.topic, partition, timestamp)
+		if err != nil {
+			ts.Close()
+			return nil, fmt.Errorf("unable to resolve offset of %s partition %d: %s", reader.topic, partition, err)
+		}
+
+		// nothing was written since from, start at the live end
+		if offset < 0 {
+			offset = sarama.OffsetNewest
+		}
+
+		partitionConsumer, err := kafkaConsumer.ConsumePartition(reader.topic, partition, offset)
+		if err != nil {
+			ts.Close()
+			return nil, err
+		}
+		ts.partitions = append(ts.partitions, partitionConsumer)
+
+		ts.readers.Add(1)
+		go ts.readPartition(partitionConsumer)
+	}
+
+	fmt.Println("Time shift of topic", reader.topic, "starting at", from.Format(time.RFC3339))
+	go ts.replay()
+	return ts, nil
+}
+
+// metadataClient returns the client used for metadata and time shift requests, it is created on first use
+func (sc *KafkaConsumer) metadataClient() (sarama.Client, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.client != nil {
+		return sc.client, nil
+	}
+
+	client, err := sarama.NewClient(sc.brokers, &sc.config.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.client = client
+	return client, nil
+}
+
+func (ts *kafkaTimeShift) readPartition(partitionConsumer sarama.PartitionConsumer) {
+	defer ts.readers.Done()
+
+	for msg := range partitionConsumer.Messages() {
+		select {
+		case ts.messages <- msg:
+		case <-ts.stop:
+			return
+		}
+	}
+}
+
+// replay sends the messages at the pace of their timestamps until CatchUp is called.
+// Messages of different partitions are not ordered, an older timestamp is sent right away.
+// The replay starts at the first keyframe, preceded by the parameter sets that were seen before it.
+func (ts *kafkaTimeShift) replay() {
+	defer close(ts.frames)
+
+	var firstTimestamp, start time.Time
+	started := false
+	for {
+		var msg *sarama.ConsumerMessage
+		select {
+		case msg = <-ts.messages:
+		case <-ts.stop:
+			return
+		}
+
+		headers := messageHeaders(msg)
+		name := ts.name
+		if !name.HasType || !name.HasQuality {
+			var err error
+			name, err = name.fromHeaders(headers)
+			if err != nil || name.Quality != ts.quality {
+				continue
+			}
+		}
+
+		// the capture time of the envelope is more exact than the time the message was produced
+		timestamp := msg.Timestamp
+		if captureTime, err := parseTimestamp(headers[headerTimestamp]); err == nil {
+			timestamp = captureTime
+		}
+
+		frame := msg.Value
+		if IsChunk(frame) {
+			frame, _, _ = ts.assembler.add(frame, time.Now())
+			if frame == nil {
+				continue
+			}
+		}
+
+		// frames before the first keyframe cannot be decoded and would hold back the pacing
+		if !started {
+			if !ts.isKeyframe(name.StreamType, headers, frame) {
+				continue
+			}
+			started = true
+
+			if ts.inspector != nil {
+				for _, parameterSet := range ts.inspector.ParameterSets() {
+					select {
+					case ts.frames <- parameterSet:
+					case <-ts.stop:
+						return
+					}
+				}
+			}
+		}
+
+		if !timestamp.IsZero() {
+			if firstTimestamp.IsZero() {
+				firstTimestamp, start = timestamp, time.Now()
+			}
+
+			wait := time.Until(start.Add(timestamp.Sub(firstTimestamp)))
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ts.catchUp:
+				case <-ts.stop:
+					return
+				}
+			}
+		}
+
+		select {
+		case ts.frames <- frame:
+		case <-ts.stop:
+			return
+		}
+	}
+}
+
+// isKeyframe reports whether the replay can start at the frame. The keyframe header is trusted over
+// the inspector of the stream type, every frame counts as a keyframe if there is neither.
+func (ts *kafkaTimeShift) isKeyframe(streamType consts.StreamType, headers map[string]string, frame []byte) bool {
+	if ts.inspector == nil {
+		if def, err := streamtype.Lookup(streamType); err == nil && def.NewInspector != nil {
+			ts.inspector = def.NewInspector()
+		}
+	}
+
+	keyframe := true
+	if ts.inspector != nil {
+		keyframe = ts.inspector.Inspect(frame)
+	}
+	if header, err := strconv.ParseBool(headers[headerKeyframe]); err == nil {
+		keyframe = header
+	}
+
+	return keyframe
+}
+
+func (ts *kafkaTimeShift) Frames() <-chan []byte {
+	return ts.frames
+}
+
+func (ts *kafkaTimeShift) CatchUp() {
+	ts.catchUpOne.Do(func() { close(ts.catchUp) })
+}
+
+func (ts *kafkaTimeShift) Close() error {
+	var err error
+	ts.closeOnce.Do(func() {
+		close(ts.stop)
+		for _, partitionConsumer := range ts.partitions {
+			partitionConsumer.AsyncClose()
+		}
+		ts.readers.Wait()
+		err = ts.consumer.Close()
+	})
+
+	return err
+}
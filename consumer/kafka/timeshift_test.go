@@ -0,0 +1,88 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	_ "StreamingServer/streamtype/builtin"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func replayedFrames(t *testing.T, messages []*sarama.ConsumerMessage, want int) [][]byte {
+	t.Helper()
+	ts := &kafkaTimeShift{
+		frames:    make(chan []byte, len(messages)+4),
+		messages:  make(chan *sarama.ConsumerMessage, len(messages)),
+		name:      TopicName{StreamID: "stream0", StreamType: consts.StreamH264, Quality: consts.HighQuality, HasType: true, HasQuality: true},
+		quality:   consts.HighQuality,
+		assembler: newChunkAssembler(time.Second),
+		catchUp:   make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	defer close(ts.stop)
+
+	for _, msg := range messages {
+		ts.messages <- msg
+	}
+	ts.CatchUp()
+	go ts.replay()
+
+	var frames [][]byte
+	for len(frames) < want {
+		select {
+		case frame := <-ts.frames:
+			frames = append(frames, frame)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("replay sent %d frames, want %d", len(frames), want)
+		}
+	}
+
+	select {
+	case frame := <-ts.frames:
+		t.Fatalf("replay sent the unexpected frame %x", frame)
+	case <-time.After(20 * time.Millisecond):
+	}
+	return frames
+}
+
+func TestReplayStartsAtKeyframe(t *testing.T) {
+	sps := []byte{0, 0, 0, 1, 0x67, 0x42}
+	pps := []byte{0, 0, 0, 1, 0x68, 0xce}
+	slice := []byte{0, 0, 0, 1, 0x41, 0x9a}
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88}
+
+	frames := replayedFrames(t, []*sarama.ConsumerMessage{
+		{Value: slice},
+		{Value: sps},
+		{Value: pps},
+		{Value: slice},
+		{Value: idr},
+		{Value: slice},
+	}, 4)
+
+	for index, want := range [][]byte{sps, pps, idr, slice} {
+		if !bytes.Equal(frames[index], want) {
+			t.Errorf("frame %d of the replay is %x, want %x", index, frames[index], want)
+		}
+	}
+}
+
+func TestReplayTrustsKeyframeHeader(t *testing.T) {
+	keyframe := func(value string) []*sarama.RecordHeader {
+		return []*sarama.RecordHeader{{Key: []byte(headerKeyframe), Value: []byte(value)}}
+	}
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88}
+	slice := []byte{0, 0, 0, 1, 0x41, 0x9a}
+
+	frames := replayedFrames(t, []*sarama.ConsumerMessage{
+		{Value: idr, Headers: keyframe("false")},
+		{Value: slice, Headers: keyframe("true")},
+		{Value: idr},
+	}, 2)
+
+	if !bytes.Equal(frames[0], slice) || !bytes.Equal(frames[1], idr) {
+		t.Errorf("replay sent %x, want it to start at the frame with the keyframe header", frames)
+	}
+}
@@ -0,0 +1,238 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/streamtype"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StreamMetadata describes a stream and the codec parameters seen on it so far
+type StreamMetadata struct {
+	StreamID     string            `json:"stream_id"`
+	StreamType   consts.StreamType `json:"stream_type"`
+	State        StreamState       `json:"state,omitempty"`
+	MIMEType     string            `json:"mime_type,omitempty"`
+	Frames       uint64            `json:"frames"`
+	Keyframes    uint64            `json:"keyframes"`
+	LastKeyframe *time.Time        `json:"last_keyframe,omitempty"`
+	// LastCapture is the capture time of the last frame, if the publisher sent one
+	LastCapture *time.Time `json:"last_capture,omitempty"`
+	// LastSequence is the sequence number of the last frame of the highest quality, if the publisher sent one
+	LastSequence uint64            `json:"last_sequence,omitempty"`
+	Resolution   string            `json:"resolution,omitempty"`
+	Codec        map[string]string `json:"codec,omitempty"`
+	Stats        map[string]uint64 `json:"stats,omitempty"`
+
+	// ParameterSets holds the units that a decoder needs before the first keyframe
+	ParameterSets [][]byte `json:"-"`
+	// Renditions holds the codec parameters of each quality, Codec and ParameterSets are those of the highest one
+	Renditions map[consts.Quality]RenditionMetadata `json:"-"`
+}
+
+// RenditionMetadata holds the codec parameters of one quality of a stream,
+// the qualities may be encoded with different profiles and resolutions
+type RenditionMetadata struct {
+	Codec         map[string]string
+	ParameterSets [][]byte
+	// LastSequence is the sequence number of the last frame of the quality, every quality counts its own frames
+	LastSequence uint64
+}
+
+// FrameInfo holds what a publisher told about a frame besides its data
+type FrameInfo struct {
+	CaptureTime time.Time
+	Keyframe    bool
+	// Sequence counts the frames of the publisher starting at 1, 0 if it is unknown
+	Sequence uint64
+	// Width and Height are 0 if the resolution is unknown
+	Width  int
+	Height int
+}
+
+// StreamState tells whether a stream delivers frames, consumers that do not track it leave it empty
+type StreamState string
+
+const (
+	// StreamLive streams deliver frames
+	StreamLive StreamState = "live"
+	// StreamStalled streams are connected but did not deliver frames for a while
+	StreamStalled StreamState = "stalled"
+	// StreamRecovering streams lost their connection and are reconnecting
+	StreamRecovering StreamState = "recovering"
+	// StreamPaused streams are not read because nobody watches them
+	StreamPaused StreamState = "paused"
+)
+
+// statSequenceGaps counts the frames that are missing between sequence numbers
+const statSequenceGaps = "sequence_gaps"
+
+// metadataCollector inspects the frames of a stream to keep its metadata up to date
+type metadataCollector struct {
+	mimeType     string
+	newInspector func() streamtype.FrameInspector
+	// inspectors has one inspector per quality, every quality has its own parameter sets
+	inspectors   map[consts.Quality]streamtype.FrameInspector
+	frames       uint64
+	keyframes    uint64
+	lastKeyframe time.Time
+	lastCapture  time.Time
+	// lastSequence is kept per quality, the publisher numbers the frames of each quality on their own
+	lastSequence map[consts.Quality]uint64
+	width        int
+	height       int
+	state        StreamState
+	stats        map[string]uint64
+	sync.Mutex
+}
+
+func newMetadataCollector(streamType consts.StreamType) *metadataCollector {
+	collector := &metadataCollector{
+		inspectors:   make(map[consts.Quality]streamtype.FrameInspector),
+		lastSequence: make(map[consts.Quality]uint64),
+		stats:        make(map[string]uint64),
+	}
+	def, err := streamtype.Lookup(streamType)
+	if err != nil {
+		return collector
+	}
+
+	collector.mimeType = def.MIMEType
+	collector.newInspector = def.NewInspector
+	return collector
+}
+
+// inspector returns the inspector of the quality, it is nil if the stream type has none
+func (mc *metadataCollector) inspector(quality consts.Quality) streamtype.FrameInspector {
+	if mc.newInspector == nil {
+		return nil
+	}
+
+	inspector, ok := mc.inspectors[quality]
+	if !ok {
+		inspector = mc.newInspector()
+		mc.inspectors[quality] = inspector
+	}
+	return inspector
+}
+
+// observe counts the frame of the quality and reports whether it is a keyframe
+func (mc *metadataCollector) observe(quality consts.Quality, frame []byte, info FrameInfo) bool {
+	mc.Lock()
+	defer mc.Unlock()
+
+	mc.frames++
+	keyframe := info.Keyframe
+	if inspector := mc.inspector(quality); inspector != nil && inspector.Inspect(frame) {
+		keyframe = true
+	}
+
+	if keyframe {
+		mc.keyframes++
+		mc.lastKeyframe = time.Now()
+	}
+
+	if !info.CaptureTime.IsZero() {
+		mc.lastCapture = info.CaptureTime
+	}
+
+	if info.Sequence > 0 {
+		last := mc.lastSequence[quality]
+		if last > 0 && info.Sequence > last+1 {
+			mc.stats[statSequenceGaps] += info.Sequence - last - 1
+		}
+		mc.lastSequence[quality] = info.Sequence
+	}
+
+	if info.Width > 0 && info.Height > 0 {
+		mc.width, mc.height = info.Width, info.Height
+	}
+
+	return keyframe
+}
+
+// setState changes the state and returns the previous one
+func (mc *metadataCollector) setState(state StreamState) StreamState {
+	mc.Lock()
+	defer mc.Unlock()
+
+	previous := mc.state
+	mc.state = state
+	return previous
+}
+
+func (mc *metadataCollector) setStat(name string, value uint64) {
+	mc.Lock()
+	mc.stats[name] = value
+	mc.Unlock()
+}
+
+func (mc *metadataCollector) incrementStat(name string, delta uint64) {
+	mc.Lock()
+	mc.stats[name] += delta
+	mc.Unlock()
+}
+
+func (mc *metadataCollector) metadata(streamID string, streamType consts.StreamType) StreamMetadata {
+	mc.Lock()
+	defer mc.Unlock()
+
+	metadata := StreamMetadata{
+		StreamID:   streamID,
+		StreamType: streamType,
+		MIMEType:   mc.mimeType,
+		State:      mc.state,
+		Frames:     mc.frames,
+		Keyframes:  mc.keyframes,
+	}
+
+	if mc.width > 0 {
+		metadata.Resolution = fmt.Sprintf("%dx%d", mc.width, mc.height)
+	}
+
+	if !mc.lastKeyframe.IsZero() {
+		lastKeyframe := mc.lastKeyframe
+		metadata.LastKeyframe = &lastKeyframe
+	}
+
+	if !mc.lastCapture.IsZero() {
+		lastCapture := mc.lastCapture
+		metadata.LastCapture = &lastCapture
+	}
+
+	if len(mc.stats) > 0 {
+		metadata.Stats = make(map[string]uint64)
+		for name, value := range mc.stats {
+			metadata.Stats[name] = value
+		}
+	}
+
+	qualities := make(map[consts.Quality]bool)
+	for quality := range mc.inspectors {
+		qualities[quality] = true
+	}
+	for quality := range mc.lastSequence {
+		qualities[quality] = true
+	}
+
+	highest := consts.Quality(-1)
+	for quality := range qualities {
+		if metadata.Renditions == nil {
+			metadata.Renditions = make(map[consts.Quality]RenditionMetadata)
+		}
+		rendition := RenditionMetadata{LastSequence: mc.lastSequence[quality]}
+		if inspector, ok := mc.inspectors[quality]; ok {
+			rendition.Codec, rendition.ParameterSets = inspector.Parameters(), inspector.ParameterSets()
+		}
+		metadata.Renditions[quality] = rendition
+
+		if quality > highest {
+			highest = quality
+			metadata.Codec, metadata.ParameterSets = rendition.Codec, rendition.ParameterSets
+			metadata.LastSequence = rendition.LastSequence
+		}
+	}
+
+	return metadata
+}
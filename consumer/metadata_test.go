@@ -0,0 +1,68 @@
+package consumer_test
+
+import (
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	_ "StreamingServer/streamtype/builtin"
+	"bytes"
+	"testing"
+)
+
+func keyframe(nals ...[]byte) []byte {
+	var frame []byte
+	for _, nal := range nals {
+		frame = append(frame, codec.WithStartCode(nal)...)
+	}
+	return frame
+}
+
+func TestMetadataKeepsParameterSetsPerQuality(t *testing.T) {
+	// the qualities are separate encodings, here a high profile 720p and a baseline one
+	highSPS := []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0, 0, 3, 0, 0x10, 0, 0, 3, 3, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	lowSPS := []byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0xe0, 0x08, 0x9f, 0x96, 0x10, 0, 0, 3, 0, 0x10, 0, 0, 3, 3, 0x20, 0xf1, 0x83, 0x1a, 0x80}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+
+	stream := consumer.NewBaseStreamConnection("front_door", consts.StreamH264)
+	stream.ObserveFrame(consts.HighQuality, keyframe(highSPS, pps, idr))
+	stream.ObserveFrame(consts.LowQuality, keyframe(lowSPS, pps, idr))
+
+	metadata := stream.GetMetadata()
+	high, low := metadata.Renditions[consts.HighQuality], metadata.Renditions[consts.LowQuality]
+	if len(high.ParameterSets) == 0 || !bytes.Equal(high.ParameterSets[0], codec.WithStartCode(highSPS)) {
+		t.Errorf("high quality parameter sets are %x", high.ParameterSets)
+	}
+	if len(low.ParameterSets) == 0 || !bytes.Equal(low.ParameterSets[0], codec.WithStartCode(lowSPS)) {
+		t.Errorf("low quality parameter sets are %x", low.ParameterSets)
+	}
+	if high.Codec["codecs"] == low.Codec["codecs"] {
+		t.Errorf("both qualities have the codecs %s", high.Codec["codecs"])
+	}
+	if metadata.Codec["codecs"] != high.Codec["codecs"] {
+		t.Errorf("stream codecs are %s, want those of the highest quality %s", metadata.Codec["codecs"], high.Codec["codecs"])
+	}
+}
+
+func TestMetadataCountsSequenceGapsPerQuality(t *testing.T) {
+	stream := consumer.NewBaseStreamConnection("front_door", consts.StreamMJPG)
+	for sequence := uint64(1); sequence <= 10; sequence++ {
+		stream.ObserveFrameInfo(consts.HighQuality, []byte{0xff, 0xd8}, consumer.FrameInfo{Sequence: sequence})
+		stream.ObserveFrameInfo(consts.LowQuality, []byte{0xff, 0xd8}, consumer.FrameInfo{Sequence: sequence * 2})
+	}
+
+	// only the low quality skips a frame between each of its frames
+	metadata := stream.GetMetadata()
+	if gaps := metadata.Stats["sequence_gaps"]; gaps != 9 {
+		t.Errorf("sequence_gaps is %d, want 9", gaps)
+	}
+	if last := metadata.Renditions[consts.HighQuality].LastSequence; last != 10 {
+		t.Errorf("last sequence of the high quality is %d, want 10", last)
+	}
+	if last := metadata.Renditions[consts.LowQuality].LastSequence; last != 20 {
+		t.Errorf("last sequence of the low quality is %d, want 20", last)
+	}
+	if metadata.LastSequence != 10 {
+		t.Errorf("last sequence of the stream is %d, want that of the highest quality", metadata.LastSequence)
+	}
+}
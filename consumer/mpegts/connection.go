@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"sync"
+)
+
+// TSStreamConnection is an elementary stream that was demuxed from an MPEG-TS source.
+// The frames are pushed into it by the MPEGTSConsumer that reads the source.
+type TSStreamConnection struct {
+	consumer.BaseStreamConnection
+	streamChanMap map[consts.Quality](chan []byte)
+	isOpen        bool
+	sync.Mutex
+}
+
+func NewTSStreamConnection(streamID string, streamType consts.StreamType, quality consts.Quality) *TSStreamConnection {
+	sc := &TSStreamConnection{
+		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
+		streamChanMap:        make(map[consts.Quality](chan []byte)),
+	}
+
+	sc.streamChanMap[quality] = make(chan []byte, 32)
+	return sc
+}
+
+// AddConnection adds another quality to the stream, the second argument is not used
+func (sc *TSStreamConnection) AddConnection(quality consts.Quality, _ interface{}) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, exists := sc.streamChanMap[quality]; exists {
+		return fmt.Errorf("stream %s already has quality %d", sc.GetID(), quality)
+	}
+
+	sc.streamChanMap[quality] = make(chan []byte, 32)
+	return nil
+}
+
+func (sc *TSStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	ch, ok := sc.streamChanMap[quality]
+	if !ok {
+		return nil, fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	return ch, nil
+}
+
+// AddDataToStream sends a demuxed frame to the viewers of the quality
+func (sc *TSStreamConnection) AddDataToStream(data []byte, quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	qualityChannel, ok := sc.streamChanMap[quality]
+	if !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	data, valid := sc.ValidateFrame(data)
+	if !valid {
+		return nil
+	}
+
+	sc.ObserveFrame(quality, data)
+	select {
+	case qualityChannel <- data:
+	default:
+		<-qualityChannel
+		qualityChannel <- data
+	}
+	return nil
+}
+
+// HandleStream marks the quality as open, the frames are pushed by the consumer reading the source
+func (sc *TSStreamConnection) HandleStream(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, ok := sc.streamChanMap[quality]; !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	sc.isOpen = true
+	return nil
+}
+
+func (sc *TSStreamConnection) Close(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	channel, ok := sc.streamChanMap[quality]
+	if !ok {
+		return fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	close(channel)
+	delete(sc.streamChanMap, quality)
+	if len(sc.streamChanMap) == 0 {
+		sc.isOpen = false
+	}
+
+	return nil
+}
+
+// closeAll closes every quality of the stream
+func (sc *TSStreamConnection) closeAll() {
+	sc.Lock()
+	defer sc.Unlock()
+
+	for quality, channel := range sc.streamChanMap {
+		close(channel)
+		delete(sc.streamChanMap, quality)
+	}
+	sc.isOpen = false
+}
+
+func (sc *TSStreamConnection) IsOpen() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.isOpen
+}
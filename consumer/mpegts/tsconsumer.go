@@ -0,0 +1,197 @@
+package consumer
+
+import (
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+)
+
+const maxUDPPacketSize = 65536
+
+// TSSource is a UDP address that receives an MPEG-TS stream.
+// Multicast groups are joined on the given interface or the system default if it is empty.
+type TSSource struct {
+	Address   string
+	Interface string
+	StreamID  string
+	Quality   consts.Quality
+}
+
+// MPEGTSConsumer receives MPEG-TS over UDP (unicast or multicast) and serves the video
+// of the first program as a stream with the id of the source.
+type MPEGTSConsumer struct {
+	sources       []TSSource
+	activeStreams map[string]*TSStreamConnection
+	listeners     []*net.UDPConn
+	running       bool
+	sync.Mutex
+}
+
+func NewMPEGTSConsumer(sources ...TSSource) *MPEGTSConsumer {
+	return &MPEGTSConsumer{
+		sources:       sources,
+		activeStreams: make(map[string]*TSStreamConnection),
+	}
+}
+
+func (sc *MPEGTSConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	stream, ok := sc.activeStreams[streamID]
+	if !ok {
+		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
+	}
+
+	return stream, nil
+}
+
+func listenTSSource(source TSSource) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", source.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !udpAddr.IP.IsMulticast() {
+		return net.ListenUDP("udp", udpAddr)
+	}
+
+	var ifi *net.Interface
+	if source.Interface != "" {
+		ifi, err = net.InterfaceByName(source.Interface)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("Joining multicast group", udpAddr)
+	return net.ListenMulticastUDP("udp", ifi, udpAddr)
+}
+
+// Start joins all sources and blocks until the consumer is stopped
+func (sc *MPEGTSConsumer) Start() error {
+	sc.Lock()
+	for _, source := range sc.sources {
+		listener, err := listenTSSource(source)
+		if err != nil {
+			fmt.Printf("Unable to listen for MPEG-TS on %s. Aborting due to error: %s\n", source.Address, err)
+			sc.Unlock()
+			sc.Stop()
+			return err
+		}
+		sc.listeners = append(sc.listeners, listener)
+	}
+	sc.running = true
+	listeners := sc.listeners
+	sc.Unlock()
+
+	var wg sync.WaitGroup
+	for i, listener := range listeners {
+		wg.Add(1)
+		go func(listener *net.UDPConn, source TSSource) {
+			defer wg.Done()
+			sc.readSource(listener, source)
+		}(listener, sc.sources[i])
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func (sc *MPEGTSConsumer) readSource(listener *net.UDPConn, source TSSource) {
+	demuxer := codec.NewTSDemuxer()
+	buffer := make([]byte, maxUDPPacketSize)
+	for {
+		n, err := listener.Read(buffer)
+		if err != nil {
+			if !sc.isRunning() {
+				return
+			}
+			fmt.Printf("Error reading MPEG-TS from %s: %s\n", source.Address, err)
+			continue
+		}
+
+		// datagrams normally hold whole packets, skip anything before the first sync byte
+		data := buffer[:n]
+		if start := bytes.IndexByte(data, codec.TSSyncByte); start > 0 {
+			data = data[start:]
+		}
+
+		for _, frame := range demuxer.Feed(data) {
+			var streamID string
+			var streamType consts.StreamType
+			switch frame.StreamType {
+			case codec.TSStreamTypeH264:
+				streamID, streamType = source.StreamID, consts.StreamH264
+			case codec.TSStreamTypeH265:
+				streamID, streamType = source.StreamID, consts.StreamH265
+			default:
+				// audio is demuxed but there is no stream type to serve it as
+				continue
+			}
+
+			stream, err := sc.getOrCreateStream(streamID, streamType, source.Quality)
+			if err != nil {
+				fmt.Println("Dropping frame of", streamID, err)
+				continue
+			}
+
+			stream.AddDataToStream(frame.Data, source.Quality)
+			stream.SetStat("ts_packets", demuxer.Stats.Packets)
+			stream.SetStat("ts_continuity_errors", demuxer.Stats.ContinuityErrors)
+			stream.SetStat("ts_transport_errors", demuxer.Stats.TransportErrors)
+			stream.SetStat("ts_sync_errors", demuxer.Stats.SyncErrors)
+		}
+	}
+}
+
+func (sc *MPEGTSConsumer) getOrCreateStream(streamID string, streamType consts.StreamType, quality consts.Quality) (*TSStreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	stream, exists := sc.activeStreams[streamID]
+	if !exists {
+		fmt.Println("Registering stream with id:", streamID)
+		stream = NewTSStreamConnection(streamID, streamType, quality)
+		sc.activeStreams[streamID] = stream
+		return stream, stream.HandleStream(quality)
+	}
+
+	if stream.GetType() != streamType {
+		return nil, fmt.Errorf("source switched from %s to %s", stream.GetType(), streamType)
+	}
+
+	if _, err := stream.GetOutputChan(quality); err != nil {
+		stream.AddConnection(quality, nil)
+		return stream, stream.HandleStream(quality)
+	}
+
+	return stream, nil
+}
+
+func (sc *MPEGTSConsumer) isRunning() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.running
+}
+
+func (sc *MPEGTSConsumer) Stop() error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.running = false
+	for _, listener := range sc.listeners {
+		listener.Close()
+	}
+	sc.listeners = nil
+
+	for streamID, stream := range sc.activeStreams {
+		stream.closeAll()
+		delete(sc.activeStreams, streamID)
+	}
+	return nil
+}
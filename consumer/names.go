@@ -0,0 +1,33 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/streamtype"
+	"fmt"
+	"strings"
+)
+
+// ParseStreamName splits a Kafka topic or NATS subject named <stream>_<type>_<quality>
+// into the stream id, the stream type and the quality
+func ParseStreamName(name string) (string, consts.StreamType, consts.Quality, error) {
+	streamInfo := strings.Split(name, "_")
+	if len(streamInfo) < 3 {
+		return "", "", 0, fmt.Errorf("topic name needs to specify stream type and quality at the end seperated by '_'")
+	}
+
+	// stream ids may contain underscores themselves, e.g. front_door_h264_low
+	streamName := strings.Join(streamInfo[:len(streamInfo)-2], "_")
+	streamType := consts.StreamType(streamInfo[len(streamInfo)-2])
+	qualityString := streamInfo[len(streamInfo)-1]
+
+	if !streamtype.IsRegistered(streamType) {
+		return "", "", 0, fmt.Errorf("no such stream type %s", streamType)
+	}
+
+	quality, err := consts.GetQualityFromString(qualityString)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return streamName, streamType, quality, nil
+}
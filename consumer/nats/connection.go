@@ -0,0 +1,163 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsSubscription struct {
+	subject    string
+	msgChan    chan *nats.Msg
+	outChan    chan []byte
+	done       chan struct{}
+	sub        *nats.Subscription
+	subscribed bool
+}
+
+// NATSStreamConnection reads the qualities of a stream from JetStream subjects
+type NATSStreamConnection struct {
+	consumer.BaseStreamConnection
+	js            nats.JetStreamContext
+	subOpts       []nats.SubOpt
+	subscriptions map[consts.Quality]*natsSubscription
+	isOpen        bool
+	sync.Mutex
+}
+
+func NewNATSStreamConnection(streamID string, streamType consts.StreamType, js nats.JetStreamContext, subOpts []nats.SubOpt) *NATSStreamConnection {
+	return &NATSStreamConnection{
+		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
+		js:                   js,
+		subOpts:              subOpts,
+		subscriptions:        make(map[consts.Quality]*natsSubscription),
+	}
+}
+
+// AddConnection adds the subject the quality is read from, it must be a string
+func (sc *NATSStreamConnection) AddConnection(quality consts.Quality, subject interface{}) error {
+	subjectName, ok := subject.(string)
+	if !ok {
+		return fmt.Errorf("second argument must be a subject name of type string")
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.subscriptions[quality] = &natsSubscription{
+		subject: subjectName,
+		msgChan: make(chan *nats.Msg, 64),
+		outChan: make(chan []byte, 4),
+		done:    make(chan struct{}),
+	}
+	return nil
+}
+
+func (sc *NATSStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	subscription, ok := sc.subscriptions[quality]
+	if !ok {
+		return nil, fmt.Errorf("no stream for quality %d", quality)
+	}
+
+	return subscription.outChan, nil
+}
+
+// HandleStream subscribes to the subject of the quality with an ordered push consumer
+// and forwards the messages until the subscription is closed
+func (sc *NATSStreamConnection) HandleStream(quality consts.Quality) error {
+	sc.Lock()
+	subscription, ok := sc.subscriptions[quality]
+	if !ok {
+		sc.Unlock()
+		return fmt.Errorf("no subject for quality %d", quality)
+	}
+
+	sub, err := sc.js.ChanSubscribe(subscription.subject, subscription.msgChan, sc.subOpts...)
+	if err != nil {
+		sc.Unlock()
+		return err
+	}
+	subscription.sub = sub
+	subscription.subscribed = true
+	sc.isOpen = true
+	sc.Unlock()
+
+	defer close(subscription.done)
+	for msg := range subscription.msgChan {
+		frame, valid := sc.ValidateFrame(msg.Data)
+		if !valid {
+			continue
+		}
+
+		sc.ObserveFrame(quality, frame)
+		select {
+		case subscription.outChan <- frame:
+		default:
+			<-subscription.outChan
+			subscription.outChan <- frame
+		}
+	}
+	return nil
+}
+
+func (sc *NATSStreamConnection) Close(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	subscription, ok := sc.subscriptions[quality]
+	if !ok {
+		return fmt.Errorf("No nats subscription with quality %d", quality)
+	}
+
+	delete(sc.subscriptions, quality)
+	if len(sc.subscriptions) == 0 {
+		sc.isOpen = false
+	}
+
+	if subscription.subscribed {
+		err := subscription.sub.Unsubscribe()
+		if err != nil {
+			return err
+		}
+		// HandleStream stops once the message channel is closed
+		close(subscription.msgChan)
+		<-subscription.done
+	}
+	close(subscription.outChan)
+	return nil
+}
+
+func (sc *NATSStreamConnection) CloseAll() error {
+	for _, quality := range sc.GetQualities() {
+		err := sc.Close(quality)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sc *NATSStreamConnection) GetQualities() []consts.Quality {
+	sc.Lock()
+	defer sc.Unlock()
+
+	var qualities []consts.Quality
+	for quality := range sc.subscriptions {
+		qualities = append(qualities, quality)
+	}
+
+	return qualities
+}
+
+func (sc *NATSStreamConnection) IsOpen() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.isOpen
+}
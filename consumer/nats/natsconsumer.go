@@ -0,0 +1,144 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Keyword Args Keys
+	subjectsKey  = "subjects"
+	streamKey    = "stream"
+	startTimeKey = "start_time"
+)
+
+type NATSArgs map[string]string
+
+func getOrDefault(key, default_val string, kwargs NATSArgs) string {
+	val, ok := kwargs[key]
+	if !ok {
+		return default_val
+	}
+
+	return val
+}
+
+// NATSConsumer reads streams from NATS JetStream subjects that are named like the Kafka topics
+// (<stream>_<type>_<quality>). Every quality is read with an ordered push consumer that starts
+// at the last message of the subject, or at start_time to replay the stored frames.
+type NATSConsumer struct {
+	conn         *nats.Conn
+	ownsConn     bool
+	topicStreams map[string]*NATSStreamConnection
+}
+
+// NewNATSConsumer connects to the given NATS servers (comma separated urls)
+func NewNATSConsumer(natsURL string, kwargs ...NATSArgs) (*NATSConsumer, error) {
+	conn, err := nats.Connect(natsURL, nats.Name("StreamingServer"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := NewNATSConsumerWithConn(conn, kwargs...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sc.ownsConn = true
+	return sc, nil
+}
+
+// NewNATSConsumerWithConn uses an existing connection, e.g. to an embedded nats-server
+func NewNATSConsumerWithConn(conn *nats.Conn, kwargs ...NATSArgs) (*NATSConsumer, error) {
+	var args NATSArgs
+	if len(kwargs) > 0 {
+		args = kwargs[0]
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	subOpts := []nats.SubOpt{nats.OrderedConsumer()}
+	startTime := getOrDefault(startTimeKey, "", args)
+	if startTime == "" {
+		subOpts = append(subOpts, nats.DeliverLast())
+	} else {
+		start, err := time.Parse(time.RFC3339, startTime)
+		if err != nil {
+			return nil, fmt.Errorf("start_time must be in RFC3339 format: %s", err)
+		}
+		subOpts = append(subOpts, nats.StartTime(start))
+	}
+
+	if stream := getOrDefault(streamKey, "", args); stream != "" {
+		subOpts = append(subOpts, nats.BindStream(stream))
+	}
+
+	subjects := getOrDefault(subjectsKey, "", args)
+	if subjects == "" {
+		return nil, fmt.Errorf("subjects must exist in NATSArgs as comma seperated string")
+	}
+
+	streamConnections := make(map[string]*NATSStreamConnection)
+	for _, subject := range strings.Split(subjects, ",") {
+		streamName, streamType, quality, err := consumer.ParseStreamName(subject)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, ok := streamConnections[streamName]
+		if !ok {
+			stream = NewNATSStreamConnection(streamName, streamType, js, subOpts)
+			streamConnections[streamName] = stream
+		}
+		stream.AddConnection(quality, subject)
+	}
+
+	return &NATSConsumer{conn: conn, topicStreams: streamConnections}, nil
+}
+
+func (sc *NATSConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	stream, ok := sc.topicStreams[streamID]
+	if !ok {
+		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
+	}
+
+	return stream, nil
+}
+
+func (sc *NATSConsumer) Start() error {
+	for _, connection := range sc.topicStreams {
+		for _, quality := range connection.GetQualities() {
+			go func(c *NATSStreamConnection, quality consts.Quality) {
+				err := c.HandleStream(quality)
+				if err != nil {
+					fmt.Println("Error subscribing to stream", c.GetID(), err)
+				}
+			}(connection, quality)
+		}
+	}
+
+	return nil
+}
+
+func (sc *NATSConsumer) Stop() error {
+	for _, connection := range sc.topicStreams {
+		err := connection.CloseAll()
+		if err != nil {
+			return err
+		}
+	}
+
+	if sc.ownsConn {
+		sc.conn.Close()
+	}
+	return nil
+}
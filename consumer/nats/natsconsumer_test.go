@@ -0,0 +1,102 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	_ "StreamingServer/streamtype/builtin"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startJetStream runs an in process nats-server with JetStream that is shut down with the test
+func startJetStream(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestNATSConsumerReadsStreamSubjects(t *testing.T) {
+	conn := startJetStream(t)
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subjects := []string{"front_door_mjpg_high", "front_door_mjpg_low"}
+	_, err = js.AddStream(&nats.StreamConfig{Name: "cameras", Subjects: subjects})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer starts at the last message of every subject
+	high := []byte{0xff, 0xd8, 0x01, 0xff, 0xd9}
+	low := []byte{0xff, 0xd8, 0x02, 0xff, 0xd9}
+	for subject, frame := range map[string][]byte{subjects[0]: high, subjects[1]: low} {
+		if _, err = js.Publish(subject, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	natsConsumer, err := NewNATSConsumerWithConn(conn, NATSArgs{"subjects": "front_door_mjpg_high,front_door_mjpg_low"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = natsConsumer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer natsConsumer.Stop()
+
+	stream, err := natsConsumer.GetStream("front_door")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.GetType() != consts.StreamMJPG {
+		t.Fatalf("stream type is %s, want %s", stream.GetType(), consts.StreamMJPG)
+	}
+
+	for quality, want := range map[consts.Quality][]byte{consts.HighQuality: high, consts.LowQuality: low} {
+		frames, err := stream.GetOutputChan(quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case frame := <-frames:
+			if !bytes.Equal(frame, want) {
+				t.Errorf("quality %d got frame %x, want %x", quality, frame, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no frame for quality %d", quality)
+		}
+	}
+
+	if !stream.IsOpen() {
+		t.Error("stream is not open while subscribed")
+	}
+	if _, err = natsConsumer.GetStream("back_door"); err == nil {
+		t.Error("unknown stream was found")
+	}
+}
@@ -0,0 +1,141 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"StreamingServer/streamtype"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PipeStreamConnection is a stream that is read as a raw byte stream from a pipe or stdin
+type PipeStreamConnection struct {
+	consumer.BaseStreamConnection
+	streamChanMap map[consts.Quality](chan []byte)
+	readers       map[consts.Quality]io.Reader
+	isOpen        bool
+	sync.Mutex
+}
+
+func NewPipeStreamConnection(streamID string, streamType consts.StreamType) *PipeStreamConnection {
+	return &PipeStreamConnection{
+		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
+		streamChanMap:        make(map[consts.Quality](chan []byte)),
+		readers:              make(map[consts.Quality]io.Reader),
+	}
+}
+
+// AddConnection sets the reader the quality is read from, it must be an io.Reader
+func (sc *PipeStreamConnection) AddConnection(quality consts.Quality, conn interface{}) error {
+	reader, ok := conn.(io.Reader)
+	if !ok {
+		return fmt.Errorf("conn argument must be of type io.Reader")
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.readers[quality] = reader
+	if _, exists := sc.streamChanMap[quality]; !exists {
+		sc.streamChanMap[quality] = make(chan []byte, 32)
+	}
+	return nil
+}
+
+func (sc *PipeStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	ch, ok := sc.streamChanMap[quality]
+	if !ok {
+		return nil, fmt.Errorf("No stream for %s with quality %d", sc.GetID(), quality)
+	}
+
+	return ch, nil
+}
+
+// HandleStream reads the raw stream until the reader returns EOF
+func (sc *PipeStreamConnection) HandleStream(quality consts.Quality) error {
+	def, err := streamtype.Lookup(sc.GetType())
+	if err != nil {
+		return err
+	}
+
+	if def.RawIngest == nil {
+		return fmt.Errorf("stream type %s cannot be read from a raw byte stream", sc.GetType())
+	}
+
+	sc.Lock()
+	reader, hasReader := sc.readers[quality]
+	outChan, hasChan := sc.streamChanMap[quality]
+	sc.isOpen = hasReader && hasChan
+	sc.Unlock()
+	if !hasReader || !hasChan {
+		return fmt.Errorf("no reader for quality %d", quality)
+	}
+
+	handlerChan := make(chan []byte, 32)
+	forwardDone := make(chan struct{})
+	go func() {
+		defer close(forwardDone)
+		for frame := range handlerChan {
+			frame, valid := sc.ValidateFrame(frame)
+			if !valid {
+				continue
+			}
+
+			sc.ObserveFrame(quality, frame)
+			select {
+			case outChan <- frame:
+			default:
+				<-outChan
+				outChan <- frame
+			}
+		}
+	}()
+
+	err = def.RawIngest(reader, handlerChan)
+	close(handlerChan)
+	<-forwardDone
+	return err
+}
+
+// closeReader makes a running HandleStream of the quality return
+func (sc *PipeStreamConnection) closeReader(quality consts.Quality) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if closer, ok := sc.readers[quality].(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func (sc *PipeStreamConnection) Close(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	channel, ok := sc.streamChanMap[quality]
+	if !ok {
+		return fmt.Errorf("No stream for %s with quality %d", sc.GetID(), quality)
+	}
+
+	if closer, ok := sc.readers[quality].(io.Closer); ok {
+		closer.Close()
+	}
+
+	close(channel)
+	delete(sc.streamChanMap, quality)
+	delete(sc.readers, quality)
+	if len(sc.streamChanMap) == 0 {
+		sc.isOpen = false
+	}
+
+	return nil
+}
+
+func (sc *PipeStreamConnection) IsOpen() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.isOpen
+}
@@ -0,0 +1,171 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	tcphandler "StreamingServer/consumer/tcp/handler"
+	"StreamingServer/streamtype"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// StdinPath makes the PipeConsumer read from stdin
+const StdinPath = "-"
+
+// PipeConsumer reads a single raw stream (e.g. `libcamera-vid -o -`) from stdin or a named pipe
+// and serves it under a configured stream id.
+// Named pipes are opened again when the writer closes them, stdin is only read once.
+type PipeConsumer struct {
+	path       string
+	streamID   string
+	streamType consts.StreamType
+	quality    consts.Quality
+	stream     *PipeStreamConnection
+	running    bool
+	sync.Mutex
+}
+
+// NewPipeConsumer creates a consumer for the stream type, or detects the type from the first bytes if it is consts.StreamAuto
+func NewPipeConsumer(path, streamID string, streamType consts.StreamType, quality consts.Quality) (*PipeConsumer, error) {
+	if streamType != consts.StreamAuto {
+		def, err := streamtype.Lookup(streamType)
+		if err != nil {
+			return nil, err
+		}
+
+		if def.RawIngest == nil {
+			return nil, fmt.Errorf("stream type %s cannot be read from a raw byte stream", streamType)
+		}
+	}
+
+	return &PipeConsumer{
+		path:       path,
+		streamID:   streamID,
+		streamType: streamType,
+		quality:    quality,
+	}, nil
+}
+
+func (sc *PipeConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.stream == nil || streamID != sc.streamID {
+		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
+	}
+
+	return sc.stream, nil
+}
+
+func (sc *PipeConsumer) open() (io.Reader, error) {
+	if sc.path == StdinPath {
+		// the stream must not close stdin itself
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+
+	// blocks until a writer opens the pipe
+	return os.Open(sc.path)
+}
+
+// Start reads the stream and blocks until it ended or the consumer is stopped
+func (sc *PipeConsumer) Start() error {
+	sc.Lock()
+	sc.running = true
+	sc.Unlock()
+
+	for sc.isRunning() {
+		reader, err := sc.open()
+		if err != nil {
+			fmt.Printf("Unable to open %s. Aborting due to error: %s\n", sc.path, err)
+			return err
+		}
+
+		def, reader, err := sc.resolve(reader)
+		if err != nil {
+			fmt.Println("Rejecting stream", sc.streamID, err)
+			if closer, ok := reader.(io.Closer); ok {
+				closer.Close()
+			}
+			if sc.path == StdinPath {
+				return err
+			}
+			continue
+		}
+
+		stream := NewPipeStreamConnection(sc.streamID, def.Type)
+		stream.AddConnection(sc.quality, reader)
+
+		fmt.Println("Registering stream with id:", sc.streamID)
+		sc.Lock()
+		sc.stream = stream
+		sc.Unlock()
+
+		err = stream.HandleStream(sc.quality)
+		if err != nil {
+			fmt.Println("Error reading stream", sc.streamID, err)
+		}
+
+		sc.Lock()
+		stream.Close(sc.quality)
+		sc.stream = nil
+		sc.Unlock()
+
+		if sc.path == StdinPath {
+			break
+		}
+	}
+
+	return nil
+}
+
+// resolve checks the first bytes of the stream against the configured type, or detects the type.
+// The returned reader reads the stream from its start and closes the opened reader.
+func (sc *PipeConsumer) resolve(reader io.Reader) (streamtype.Definition, io.Reader, error) {
+	sample, sniffed, err := tcphandler.SniffRawStream(reader)
+	if err != nil {
+		return streamtype.Definition{}, reader, err
+	}
+
+	if closer, ok := reader.(io.Closer); ok {
+		sniffed = readCloser{sniffed, closer}
+	}
+
+	def, err := streamtype.Resolve(sc.streamType, sample, false)
+	if err != nil {
+		return def, sniffed, err
+	}
+
+	if def.RawIngest == nil {
+		return def, sniffed, fmt.Errorf("stream type %s cannot be read from a raw byte stream", def.Type)
+	}
+
+	fmt.Println("Reading stream", sc.streamID, "as", def.Type)
+	return def, sniffed, nil
+}
+
+// readCloser closes the pipe below a reader that wraps it
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (sc *PipeConsumer) isRunning() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.running
+}
+
+func (sc *PipeConsumer) Stop() error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.running = false
+	if sc.stream != nil {
+		// Start closes the stream once the reader returned
+		sc.stream.closeReader(sc.quality)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// minJitterTick keeps the jitter buffer from being polled in a busy loop when the delay is tiny
+const minJitterTick = time.Millisecond
+
+// depacketizer rebuilds frames from the payloads of RTP packets that arrive in sequence order
+type depacketizer interface {
+	// push returns a frame once its last packet was pushed
+	push(pkt *bufferedPacket) ([]byte, error)
+}
+
+func newDepacketizer(streamType consts.StreamType) (depacketizer, error) {
+	switch streamType {
+	case consts.StreamMJPG:
+		return newJPEGDepacketizer(), nil
+	case consts.StreamH264:
+		return &h264Depacketizer{}, nil
+	default:
+		return nil, fmt.Errorf("No rtp depacketizer for stream type %s", streamType)
+	}
+}
+
+type rtpSession struct {
+	packetChan chan *rtpPacket
+	outChan    chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newRTPSession() *rtpSession {
+	return &rtpSession{
+		packetChan: make(chan *rtpPacket, 256),
+		outChan:    make(chan []byte, 32),
+		done:       make(chan struct{}),
+	}
+}
+
+func (s *rtpSession) stop() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// RTPStreamConnection represents a stream that is received as RTP packets over UDP
+type RTPStreamConnection struct {
+	consumer.BaseStreamConnection
+	sessions       map[consts.Quality]*rtpSession
+	jitterDelay    time.Duration
+	sessionTimeout time.Duration
+	isOpen         bool
+	sync.Mutex
+}
+
+func NewRTPStreamConnection(streamID string, streamType consts.StreamType, quality consts.Quality, jitterDelay, sessionTimeout time.Duration) *RTPStreamConnection {
+	sc := &RTPStreamConnection{
+		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
+		sessions:             make(map[consts.Quality]*rtpSession),
+		jitterDelay:          jitterDelay,
+		sessionTimeout:       sessionTimeout,
+	}
+
+	sc.sessions[quality] = newRTPSession()
+	return sc
+}
+
+// AddConnection adds a session for another quality, the second argument is not used for rtp streams
+func (sc *RTPStreamConnection) AddConnection(quality consts.Quality, _ interface{}) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, exists := sc.sessions[quality]; exists {
+		return fmt.Errorf("stream %s already has a session with quality %d", sc.GetID(), quality)
+	}
+
+	sc.sessions[quality] = newRTPSession()
+	return nil
+}
+
+func (sc *RTPStreamConnection) getSession(quality consts.Quality) (*rtpSession, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	session, ok := sc.sessions[quality]
+	if !ok {
+		return nil, fmt.Errorf("No rtp session for %s with quality %d", sc.GetID(), quality)
+	}
+
+	return session, nil
+}
+
+// pushPacket hands a packet to the session of the quality, it is dropped if the session cannot keep up
+func (sc *RTPStreamConnection) pushPacket(quality consts.Quality, pkt *rtpPacket) error {
+	session, err := sc.getSession(quality)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case session.packetChan <- pkt:
+	default:
+		sc.IncrementStat("rtp_packets_dropped", 1)
+	}
+	return nil
+}
+
+func (sc *RTPStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []byte, error) {
+	session, err := sc.getSession(quality)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.outChan, nil
+}
+
+// HandleStream runs the packets of a session through the jitter buffer and rebuilds the frames.
+// It returns once the session was stopped or no packets arrived for the session timeout.
+func (sc *RTPStreamConnection) HandleStream(quality consts.Quality) error {
+	session, err := sc.getSession(quality)
+	if err != nil {
+		return err
+	}
+
+	depacketizer, err := newDepacketizer(sc.GetType())
+	if err != nil {
+		return err
+	}
+
+	sc.Lock()
+	sc.isOpen = true
+	sc.Unlock()
+
+	jitterBuffer := newJitterBuffer(sc.jitterDelay, 512)
+	var reported jitterStats
+	var ssrc uint32
+	lastPacket := time.Now()
+	tick := sc.jitterDelay / 2
+	if tick < minJitterTick {
+		tick = minJitterTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case pkt := <-session.packetChan:
+			lastPacket = time.Now()
+			if jitterBuffer.started && pkt.ssrc != ssrc {
+				fmt.Printf("Stream %s changed ssrc from %08x to %08x\n", sc.GetID(), ssrc, pkt.ssrc)
+				jitterBuffer.reset()
+			}
+			ssrc = pkt.ssrc
+			jitterBuffer.push(pkt, lastPacket)
+
+		case <-ticker.C:
+			if time.Since(lastPacket) > sc.sessionTimeout {
+				fmt.Printf("No rtp packets received for %s, closing stream with quality %d\n", sc.GetID(), quality)
+				return nil
+			}
+
+		case <-session.done:
+			return nil
+		}
+
+		for _, pkt := range jitterBuffer.pop(time.Now()) {
+			frame, err := depacketizer.push(pkt)
+			if err != nil {
+				sc.IncrementStat("rtp_depacketize_errors", 1)
+			}
+			if frame == nil {
+				continue
+			}
+
+			frame, valid := sc.ValidateFrame(frame)
+			if !valid {
+				continue
+			}
+
+			sc.ObserveFrame(quality, frame)
+			select {
+			case session.outChan <- frame:
+			default:
+				<-session.outChan
+				session.outChan <- frame
+			}
+		}
+
+		sc.reportJitterStats(&jitterBuffer.stats, &reported)
+	}
+}
+
+// reportJitterStats adds what changed since the last report to the stream stats
+func (sc *RTPStreamConnection) reportJitterStats(stats, reported *jitterStats) {
+	if *stats == *reported {
+		return
+	}
+
+	sc.IncrementStat("rtp_packets_received", stats.received-reported.received)
+	sc.IncrementStat("rtp_packets_lost", stats.lost-reported.lost)
+	sc.IncrementStat("rtp_packets_reordered", stats.reordered-reported.reordered)
+	sc.IncrementStat("rtp_packets_duplicate", stats.duplicates-reported.duplicates)
+	sc.IncrementStat("rtp_packets_late", stats.late-reported.late)
+	*reported = *stats
+}
+
+// stop makes every running HandleStream of the connection return
+func (sc *RTPStreamConnection) stop() {
+	sc.Lock()
+	defer sc.Unlock()
+
+	for _, session := range sc.sessions {
+		session.stop()
+	}
+}
+
+func (sc *RTPStreamConnection) Close(quality consts.Quality) error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	session, ok := sc.sessions[quality]
+	if !ok {
+		return fmt.Errorf("No rtp session for %s with quality %d", sc.GetID(), quality)
+	}
+
+	session.stop()
+	close(session.outChan)
+	delete(sc.sessions, quality)
+	if len(sc.sessions) == 0 {
+		sc.isOpen = false
+	}
+
+	return nil
+}
+
+func (sc *RTPStreamConnection) IsOpen() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.isOpen
+}
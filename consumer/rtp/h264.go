@@ -0,0 +1,104 @@
+package consumer
+
+import (
+	"StreamingServer/codec"
+	"encoding/binary"
+	"fmt"
+)
+
+// H.264 RTP payload structures (RFC 6184)
+const (
+	h264NALSTAPA = 24
+	h264NALFUA   = 28
+)
+
+// h264Depacketizer rebuilds Annex-B access units from H.264 RTP packets
+type h264Depacketizer struct {
+	accessUnit []byte
+	fragment   []byte
+	// inFragment is set while the FU-A fragments of a NAL unit are being collected
+	inFragment bool
+}
+
+func (d *h264Depacketizer) push(pkt *bufferedPacket) ([]byte, error) {
+	if pkt.lostBefore {
+		// a NAL unit that lost one of its fragments cannot be decoded
+		d.fragment = nil
+		d.inFragment = false
+	}
+
+	if len(pkt.payload) < 1 {
+		return nil, fmt.Errorf("empty h264 rtp payload")
+	}
+
+	var err error
+	nalType := pkt.payload[0] & 0x1f
+	switch {
+	case nalType >= 1 && nalType <= 23:
+		d.appendNAL(pkt.payload)
+	case nalType == h264NALSTAPA:
+		err = d.pushSTAPA(pkt.payload[1:])
+	case nalType == h264NALFUA:
+		err = d.pushFUA(pkt.payload)
+	default:
+		err = fmt.Errorf("unsupported h264 rtp packetization type %d", nalType)
+	}
+
+	if !pkt.marker || len(d.accessUnit) == 0 {
+		return nil, err
+	}
+
+	accessUnit := d.accessUnit
+	d.accessUnit = nil
+	return accessUnit, err
+}
+
+func (d *h264Depacketizer) appendNAL(nal []byte) {
+	d.accessUnit = append(d.accessUnit, codec.NALStartCode...)
+	d.accessUnit = append(d.accessUnit, nal...)
+}
+
+// pushSTAPA splits a single-time aggregation packet into its NAL units
+func (d *h264Depacketizer) pushSTAPA(payload []byte) error {
+	for len(payload) > 2 {
+		size := int(binary.BigEndian.Uint16(payload))
+		payload = payload[2:]
+		if size > len(payload) {
+			return fmt.Errorf("stap-a nal unit size %d exceeds packet", size)
+		}
+
+		d.appendNAL(payload[:size])
+		payload = payload[size:]
+	}
+
+	return nil
+}
+
+// pushFUA collects the fragments of a NAL unit and appends it once the last fragment arrived
+func (d *h264Depacketizer) pushFUA(payload []byte) error {
+	if len(payload) < 2 {
+		return fmt.Errorf("fu-a packet too short")
+	}
+
+	indicator := payload[0]
+	header := payload[1]
+	start := header&0x80 != 0
+	end := header&0x40 != 0
+
+	if start {
+		// rebuild the NAL header from the fu indicator and the fu header
+		d.fragment = append(d.fragment[:0], indicator&0xe0|header&0x1f)
+		d.inFragment = true
+	} else if !d.inFragment {
+		return nil
+	}
+
+	d.fragment = append(d.fragment, payload[2:]...)
+	if end {
+		d.appendNAL(d.fragment)
+		d.fragment = nil
+		d.inFragment = false
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package consumer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestH264Depacketizer(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x01, 0x02, 0x03, 0x04, 0x05}
+	annexB := func(nals ...[]byte) []byte {
+		var frame []byte
+		for _, nal := range nals {
+			frame = append(append(frame, 0, 0, 0, 1), nal...)
+		}
+		return frame
+	}
+	// stapA aggregates the nal units with their 16 bit sizes
+	stapA := func(nals ...[]byte) []byte {
+		payload := []byte{0x78}
+		for _, nal := range nals {
+			payload = append(payload, byte(len(nal)>>8), byte(len(nal)))
+			payload = append(payload, nal...)
+		}
+		return payload
+	}
+	// fuA is a fragment of the idr, start and end set the bits of the fu header
+	fuA := func(start, end bool, data ...byte) []byte {
+		header := byte(0x05)
+		if start {
+			header |= 0x80
+		}
+		if end {
+			header |= 0x40
+		}
+		return append([]byte{0x7c, header}, data...)
+	}
+
+	tests := []struct {
+		name    string
+		packets []bufferedPacket
+		want    []byte
+	}{
+		{
+			name:    "single nal units",
+			packets: []bufferedPacket{{rtpPacket: &rtpPacket{payload: sps}}, {rtpPacket: &rtpPacket{payload: idr, marker: true}}},
+			want:    annexB(sps, idr),
+		},
+		{
+			name:    "stap-a",
+			packets: []bufferedPacket{{rtpPacket: &rtpPacket{payload: stapA(sps, pps, idr), marker: true}}},
+			want:    annexB(sps, pps, idr),
+		},
+		{
+			name: "fu-a",
+			packets: []bufferedPacket{
+				{rtpPacket: &rtpPacket{payload: stapA(sps, pps)}},
+				{rtpPacket: &rtpPacket{payload: fuA(true, false, 0x88, 0x01)}},
+				{rtpPacket: &rtpPacket{payload: fuA(false, false, 0x02, 0x03)}},
+				{rtpPacket: &rtpPacket{payload: fuA(false, true, 0x04, 0x05), marker: true}},
+			},
+			want: annexB(sps, pps, idr),
+		},
+		{
+			name: "fu-a that lost a fragment",
+			packets: []bufferedPacket{
+				{rtpPacket: &rtpPacket{payload: sps}},
+				{rtpPacket: &rtpPacket{payload: fuA(true, false, 0x88, 0x01)}},
+				{rtpPacket: &rtpPacket{payload: fuA(false, true, 0x04, 0x05), marker: true}, lostBefore: true},
+			},
+			want: annexB(sps),
+		},
+		{
+			name: "fu-a without its start",
+			packets: []bufferedPacket{
+				{rtpPacket: &rtpPacket{payload: fuA(false, false, 0x02, 0x03)}},
+				{rtpPacket: &rtpPacket{payload: fuA(false, true, 0x04, 0x05)}},
+				{rtpPacket: &rtpPacket{payload: pps, marker: true}},
+			},
+			want: annexB(pps),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &h264Depacketizer{}
+			var frames [][]byte
+			for i := range test.packets {
+				frame, err := d.push(&test.packets[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if frame != nil {
+					frames = append(frames, frame)
+				}
+			}
+
+			if len(frames) != 1 || !bytes.Equal(frames[0], test.want) {
+				t.Errorf("got frames %x, want %x", frames, test.want)
+			}
+		})
+	}
+}
+
+func TestH264DepacketizerRejectsBrokenPackets(t *testing.T) {
+	for name, payload := range map[string][]byte{
+		"empty":              {},
+		"stap-a size":        {0x78, 0x00, 0x10, 0x67},
+		"short fu-a":         {0x7c},
+		"unsupported mtap16": {0x7a, 0x00},
+	} {
+		d := &h264Depacketizer{}
+		if _, err := d.push(&bufferedPacket{rtpPacket: &rtpPacket{payload: payload, marker: true}}); err == nil {
+			t.Errorf("%s packet was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"time"
+)
+
+type bufferedPacket struct {
+	*rtpPacket
+	arrival time.Time
+	// lostBefore is set when packets were lost between this packet and the previous one
+	lostBefore bool
+}
+
+// jitterStats counts what happened to the packets that went through a jitter buffer
+type jitterStats struct {
+	received   uint64
+	lost       uint64
+	reordered  uint64
+	duplicates uint64
+	late       uint64
+}
+
+// jitterBuffer holds back packets for up to delay so they can be released in sequence order
+type jitterBuffer struct {
+	delay      time.Duration
+	maxPackets int
+	packets    map[uint16]*bufferedPacket
+	nextSeq    uint16
+	highestSeq uint16
+	started    bool
+	stats      jitterStats
+}
+
+func newJitterBuffer(delay time.Duration, maxPackets int) *jitterBuffer {
+	return &jitterBuffer{
+		delay:      delay,
+		maxPackets: maxPackets,
+		packets:    make(map[uint16]*bufferedPacket),
+	}
+}
+
+func (jb *jitterBuffer) push(pkt *rtpPacket, arrival time.Time) {
+	jb.stats.received++
+	if !jb.started {
+		jb.started = true
+		jb.nextSeq = pkt.sequenceNumber
+		jb.highestSeq = pkt.sequenceNumber
+	}
+
+	if sequenceBefore(pkt.sequenceNumber, jb.nextSeq) {
+		// the gap was already given up on
+		jb.stats.late++
+		return
+	}
+
+	if _, exists := jb.packets[pkt.sequenceNumber]; exists {
+		jb.stats.duplicates++
+		return
+	}
+
+	if sequenceBefore(pkt.sequenceNumber, jb.highestSeq) {
+		jb.stats.reordered++
+	} else {
+		jb.highestSeq = pkt.sequenceNumber
+	}
+
+	jb.packets[pkt.sequenceNumber] = &bufferedPacket{rtpPacket: pkt, arrival: arrival}
+}
+
+// pop returns the packets that are ready, in sequence order.
+// A gap is skipped once the packet after it waited longer than the delay or the buffer is full.
+func (jb *jitterBuffer) pop(now time.Time) []*bufferedPacket {
+	var ready []*bufferedPacket
+	lost := false
+	for len(jb.packets) > 0 {
+		pkt, ok := jb.packets[jb.nextSeq]
+		if ok {
+			delete(jb.packets, jb.nextSeq)
+			pkt.lostBefore = lost
+			lost = false
+			ready = append(ready, pkt)
+			jb.nextSeq++
+			continue
+		}
+
+		oldest := jb.oldestPacket()
+		if now.Sub(oldest.arrival) < jb.delay && len(jb.packets) < jb.maxPackets {
+			break
+		}
+
+		// give up on the missing packets
+		jb.stats.lost += uint64(oldest.sequenceNumber - jb.nextSeq)
+		jb.nextSeq = oldest.sequenceNumber
+		lost = true
+	}
+
+	return ready
+}
+
+// oldestPacket returns the buffered packet with the lowest sequence number
+func (jb *jitterBuffer) oldestPacket() *bufferedPacket {
+	var oldest *bufferedPacket
+	for _, pkt := range jb.packets {
+		if oldest == nil || sequenceBefore(pkt.sequenceNumber, oldest.sequenceNumber) {
+			oldest = pkt
+		}
+	}
+
+	return oldest
+}
+
+// reset forgets all buffered packets, e.g. when the sender changed
+func (jb *jitterBuffer) reset() {
+	jb.packets = make(map[uint16]*bufferedPacket)
+	jb.started = false
+}
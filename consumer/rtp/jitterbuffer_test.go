@@ -0,0 +1,143 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterBuffer(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	tests := []struct {
+		name string
+		// sequence numbers in arrival order, all arrive at the same time
+		arrivals []uint16
+		// popped before the delay passed
+		early []uint16
+		// popped after the delay passed
+		late  []uint16
+		stats jitterStats
+	}{
+		{
+			name:     "in order",
+			arrivals: []uint16{10, 11, 12},
+			early:    []uint16{10, 11, 12},
+			stats:    jitterStats{received: 3},
+		},
+		{
+			name:     "reordered",
+			arrivals: []uint16{10, 12, 11, 13},
+			early:    []uint16{10, 11, 12, 13},
+			stats:    jitterStats{received: 4, reordered: 1},
+		},
+		{
+			name:     "duplicates",
+			arrivals: []uint16{10, 11, 11, 12, 12},
+			early:    []uint16{10, 11, 12},
+			stats:    jitterStats{received: 5, duplicates: 2},
+		},
+		{
+			name:     "wrap around",
+			arrivals: []uint16{65534, 0, 65535, 1},
+			early:    []uint16{65534, 65535, 0, 1},
+			stats:    jitterStats{received: 4, reordered: 1},
+		},
+		{
+			name:     "lost across the wrap around",
+			arrivals: []uint16{65533, 65534, 1, 2},
+			early:    []uint16{65533, 65534},
+			late:     []uint16{1, 2},
+			stats:    jitterStats{received: 4, lost: 2},
+		},
+		{
+			name:     "packet before the first one",
+			arrivals: []uint16{10, 9, 11},
+			early:    []uint16{10, 11},
+			stats:    jitterStats{received: 3, late: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jb := newJitterBuffer(delay, 64)
+			start := time.Now()
+			for _, seq := range test.arrivals {
+				jb.push(&rtpPacket{sequenceNumber: seq}, start)
+			}
+
+			checkPopped(t, "before the delay", jb.pop(start), test.early)
+			checkPopped(t, "after the delay", jb.pop(start.Add(delay)), test.late)
+			if jb.stats != test.stats {
+				t.Errorf("stats are %+v, want %+v", jb.stats, test.stats)
+			}
+		})
+	}
+}
+
+func TestJitterBufferMarksGapsAndDropsLatePackets(t *testing.T) {
+	jb := newJitterBuffer(time.Second, 64)
+	start := time.Now()
+	jb.push(&rtpPacket{sequenceNumber: 100}, start)
+	jb.pop(start)
+
+	jb.push(&rtpPacket{sequenceNumber: 103}, start)
+	popped := jb.pop(start.Add(time.Second))
+	if len(popped) != 1 || !popped[0].lostBefore {
+		t.Fatalf("packet after a gap was not marked: %+v", popped)
+	}
+
+	// the gap was given up on, the packets in it are too late now
+	jb.push(&rtpPacket{sequenceNumber: 101}, start)
+	if popped = jb.pop(start.Add(time.Second)); len(popped) != 0 {
+		t.Errorf("late packet %d was popped", popped[0].sequenceNumber)
+	}
+	if jb.stats.lost != 2 || jb.stats.late != 1 {
+		t.Errorf("stats are %+v, want 2 lost and 1 late", jb.stats)
+	}
+}
+
+func TestJitterBufferSkipsGapWhenFull(t *testing.T) {
+	jb := newJitterBuffer(time.Hour, 4)
+	start := time.Now()
+	for _, seq := range []uint16{1, 3, 4, 5, 6} {
+		jb.push(&rtpPacket{sequenceNumber: seq}, start)
+	}
+
+	checkPopped(t, "full buffer", jb.pop(start), []uint16{1, 3, 4, 5, 6})
+}
+
+func checkPopped(t *testing.T, when string, popped []*bufferedPacket, want []uint16) {
+	t.Helper()
+
+	var got []uint16
+	for _, pkt := range popped {
+		got = append(got, pkt.sequenceNumber)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%s popped %v, want %v", when, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s popped %v, want %v", when, got, want)
+		}
+	}
+}
+
+func TestSequenceBefore(t *testing.T) {
+	tests := []struct {
+		a, b   uint16
+		before bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{2, 2, false},
+		{65535, 0, true},
+		{0, 65535, false},
+		{65000, 100, true},
+	}
+
+	for _, test := range tests {
+		if before := sequenceBefore(test.a, test.b); before != test.before {
+			t.Errorf("sequenceBefore(%d, %d) is %t", test.a, test.b, before)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package consumer
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The standard tables from section K of the JPEG spec. RTP/JPEG (RFC 2435) does not carry them
+// so they have to be put back into the header of every frame.
+var (
+	// quantization tables in zig-zag order
+	jpegLumaQuantizer = [64]byte{
+		16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+	}
+	jpegChromaQuantizer = [64]byte{
+		17, 18, 18, 24, 21, 24, 47, 26, 26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+	}
+
+	lumDCCodeLens = []byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}
+	lumDCSymbols  = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	lumACCodeLens = []byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d}
+	chmDCCodeLens = []byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0}
+	chmDCSymbols  = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	chmACCodeLens = []byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77}
+	lumACSymbols  = []byte{
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+		0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+		0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+		0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}
+	chmACSymbols = []byte{
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+		0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+		0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+		0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+		0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}
+)
+
+// jpegDepacketizer rebuilds complete JPEG images from RTP/JPEG packets (RFC 2435)
+type jpegDepacketizer struct {
+	scanData []byte
+	// header of the frame being collected, nil when waiting for the first fragment of a frame
+	header []byte
+	// tables sent in-band for Q values >= 128, by Q value
+	dynamicTables map[byte][]byte
+}
+
+func newJPEGDepacketizer() *jpegDepacketizer {
+	return &jpegDepacketizer{dynamicTables: make(map[byte][]byte)}
+}
+
+func (d *jpegDepacketizer) push(pkt *bufferedPacket) ([]byte, error) {
+	if pkt.lostBefore {
+		d.header = nil
+		d.scanData = nil
+	}
+
+	payload := pkt.payload
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("rtp/jpeg packet too short")
+	}
+
+	fragmentOffset := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+	jpegType := payload[4]
+	q := payload[5]
+	width := int(payload[6]) * 8
+	height := int(payload[7]) * 8
+	payload = payload[8:]
+
+	var restartInterval uint16
+	if jpegType >= 64 && jpegType <= 127 {
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("rtp/jpeg packet too short for restart marker header")
+		}
+		restartInterval = binary.BigEndian.Uint16(payload)
+		payload = payload[4:]
+	}
+
+	if fragmentOffset == 0 {
+		var tables []byte
+		if q >= 128 {
+			if len(payload) < 4 {
+				return nil, fmt.Errorf("rtp/jpeg packet too short for quantization table header")
+			}
+
+			length := int(binary.BigEndian.Uint16(payload[2:4]))
+			payload = payload[4:]
+			if length > len(payload) {
+				return nil, fmt.Errorf("rtp/jpeg quantization tables exceed packet")
+			}
+
+			if length > 0 {
+				d.dynamicTables[q] = append([]byte(nil), payload[:length]...)
+			}
+			payload = payload[length:]
+
+			tables = d.dynamicTables[q]
+			if len(tables) < 64 {
+				return nil, fmt.Errorf("no quantization tables received for q %d", q)
+			}
+		} else {
+			tables = makeQuantizationTables(int(q))
+		}
+
+		if width == 0 || height == 0 {
+			return nil, fmt.Errorf("rtp/jpeg frames larger than 2040 pixels are not supported")
+		}
+
+		d.header = makeJPEGHeader(jpegType&0x3f, width, height, tables, restartInterval)
+		d.scanData = d.scanData[:0]
+	}
+
+	if d.header == nil {
+		// waiting for the start of the next frame
+		return nil, nil
+	}
+
+	if fragmentOffset != len(d.scanData) {
+		d.header = nil
+		return nil, fmt.Errorf("rtp/jpeg fragment offset %d does not match received %d bytes", fragmentOffset, len(d.scanData))
+	}
+
+	d.scanData = append(d.scanData, payload...)
+	if !pkt.marker {
+		return nil, nil
+	}
+
+	frame := make([]byte, 0, len(d.header)+len(d.scanData)+2)
+	frame = append(frame, d.header...)
+	frame = append(frame, d.scanData...)
+	if len(d.scanData) < 2 || d.scanData[len(d.scanData)-2] != 0xff || d.scanData[len(d.scanData)-1] != 0xd9 {
+		frame = append(frame, 0xff, 0xd9)
+	}
+
+	d.header = nil
+	d.scanData = d.scanData[:0]
+	return frame, nil
+}
+
+// makeQuantizationTables scales the standard tables by the quality factor as described in RFC 2435 appendix A
+func makeQuantizationTables(q int) []byte {
+	factor := q
+	if factor < 1 {
+		factor = 1
+	}
+	if factor > 99 {
+		factor = 99
+	}
+
+	scale := 200 - factor*2
+	if q < 50 {
+		scale = 5000 / factor
+	}
+
+	tables := make([]byte, 128)
+	for i := 0; i < 64; i++ {
+		tables[i] = scaleQuantizer(jpegLumaQuantizer[i], scale)
+		tables[64+i] = scaleQuantizer(jpegChromaQuantizer[i], scale)
+	}
+
+	return tables
+}
+
+func scaleQuantizer(value byte, scale int) byte {
+	scaled := (int(value)*scale + 50) / 100
+	if scaled < 1 {
+		return 1
+	}
+	if scaled > 255 {
+		return 255
+	}
+
+	return byte(scaled)
+}
+
+// makeJPEGHeader writes the JFIF markers that precede the scan data (RFC 2435 appendix B)
+func makeJPEGHeader(jpegType byte, width, height int, tables []byte, restartInterval uint16) []byte {
+	header := []byte{0xff, 0xd8}
+
+	// quantization tables, the second one is only used if present
+	header = append(header, 0xff, 0xdb, 0, 67, 0)
+	header = append(header, tables[:64]...)
+	chromaTable := byte(0)
+	if len(tables) >= 128 {
+		header = append(header, 0xff, 0xdb, 0, 67, 1)
+		header = append(header, tables[64:128]...)
+		chromaTable = 1
+	}
+
+	if restartInterval != 0 {
+		header = append(header, 0xff, 0xdd, 0, 4, byte(restartInterval>>8), byte(restartInterval))
+	}
+
+	lumaSampling := byte(0x21)
+	if jpegType == 1 {
+		lumaSampling = 0x22
+	}
+	header = append(header, 0xff, 0xc0, 0, 17, 8,
+		byte(height>>8), byte(height), byte(width>>8), byte(width), 3,
+		0, lumaSampling, 0,
+		1, 0x11, chromaTable,
+		2, 0x11, chromaTable,
+	)
+
+	header = appendHuffmanTable(header, 0x00, lumDCCodeLens, lumDCSymbols)
+	header = appendHuffmanTable(header, 0x10, lumACCodeLens, lumACSymbols)
+	header = appendHuffmanTable(header, 0x01, chmDCCodeLens, chmDCSymbols)
+	header = appendHuffmanTable(header, 0x11, chmACCodeLens, chmACSymbols)
+
+	header = append(header, 0xff, 0xda, 0, 12, 3, 0, 0x00, 1, 0x11, 2, 0x11, 0, 63, 0)
+	return header
+}
+
+func appendHuffmanTable(header []byte, tableClassID byte, codeLens, symbols []byte) []byte {
+	length := 3 + len(codeLens) + len(symbols)
+	header = append(header, 0xff, 0xc4, byte(length>>8), byte(length), tableClassID)
+	header = append(header, codeLens...)
+	return append(header, symbols...)
+}
@@ -0,0 +1,138 @@
+package consumer
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+)
+
+func TestMakeQuantizationTables(t *testing.T) {
+	tests := []struct {
+		q int
+		// first two entries of the luma and chroma tables
+		luma, chroma [2]byte
+	}{
+		{q: 50, luma: [2]byte{16, 11}, chroma: [2]byte{17, 18}},
+		{q: 75, luma: [2]byte{8, 6}, chroma: [2]byte{9, 9}},
+		{q: 25, luma: [2]byte{32, 22}, chroma: [2]byte{34, 36}},
+		// the factor is clamped to 1 and 99 and the entries to 1 and 255
+		{q: 0, luma: [2]byte{255, 255}, chroma: [2]byte{255, 255}},
+		{q: 1, luma: [2]byte{255, 255}, chroma: [2]byte{255, 255}},
+		{q: 99, luma: [2]byte{1, 1}, chroma: [2]byte{1, 1}},
+		{q: 127, luma: [2]byte{1, 1}, chroma: [2]byte{1, 1}},
+	}
+
+	for _, test := range tests {
+		tables := makeQuantizationTables(test.q)
+		if len(tables) != 128 {
+			t.Fatalf("q %d has %d bytes of tables", test.q, len(tables))
+		}
+		if luma := [2]byte{tables[0], tables[1]}; luma != test.luma {
+			t.Errorf("q %d luma table starts with %v, want %v", test.q, luma, test.luma)
+		}
+		if chroma := [2]byte{tables[64], tables[65]}; chroma != test.chroma {
+			t.Errorf("q %d chroma table starts with %v, want %v", test.q, chroma, test.chroma)
+		}
+	}
+}
+
+// jpegPacket builds an RTP/JPEG packet of a 80x64 type 1 frame
+func jpegPacket(offset int, q byte, marker bool, data ...byte) *bufferedPacket {
+	payload := []byte{0, byte(offset >> 16), byte(offset >> 8), byte(offset), 1, q, 80 / 8, 64 / 8}
+	return &bufferedPacket{rtpPacket: &rtpPacket{payload: append(payload, data...), marker: marker}}
+}
+
+// quantizationTables returns the tables of the DQT segments of a JPEG image
+func quantizationTables(t *testing.T, image []byte) [][]byte {
+	t.Helper()
+
+	var tables [][]byte
+	for offset := 2; offset+4 <= len(image) && image[offset] == 0xff && image[offset+1] != 0xda; {
+		length := int(image[offset+2])<<8 | int(image[offset+3])
+		if image[offset+1] == 0xdb {
+			tables = append(tables, image[offset+5:offset+2+length])
+		}
+		offset += 2 + length
+	}
+	return tables
+}
+
+func TestJPEGDepacketizerRebuildsHeader(t *testing.T) {
+	d := newJPEGDepacketizer()
+	if frame, err := d.push(jpegPacket(0, 75, false, 0x01, 0x02)); frame != nil || err != nil {
+		t.Fatalf("first fragment returned %x, %v", frame, err)
+	}
+	frame, err := d.push(jpegPacket(2, 75, true, 0x03, 0x04))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 80 || config.Height != 64 {
+		t.Errorf("image is %dx%d, want 80x64", config.Width, config.Height)
+	}
+
+	tables := quantizationTables(t, frame)
+	want := makeQuantizationTables(75)
+	if len(tables) != 2 || !bytes.Equal(tables[0], want[:64]) || !bytes.Equal(tables[1], want[64:]) {
+		t.Errorf("quantization tables of the header do not match q 75")
+	}
+
+	if !bytes.HasSuffix(frame, []byte{0x01, 0x02, 0x03, 0x04, 0xff, 0xd9}) {
+		t.Errorf("scan data is not followed by the end of image: %x", frame[len(frame)-8:])
+	}
+}
+
+func TestJPEGDepacketizerInBandTables(t *testing.T) {
+	tables := make([]byte, 128)
+	for i := range tables {
+		tables[i] = byte(i + 1)
+	}
+	header := append([]byte{0, 0, 0, 128}, tables...)
+
+	d := newJPEGDepacketizer()
+	frame, err := d.push(jpegPacket(0, 255, true, append(header, 0x01, 0xff, 0xd9)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := quantizationTables(t, frame)
+	if len(got) != 2 || !bytes.Equal(got[0], tables[:64]) || !bytes.Equal(got[1], tables[64:]) {
+		t.Errorf("in-band quantization tables were not used")
+	}
+
+	// later frames may leave out the tables of the same q
+	frame, err = d.push(jpegPacket(0, 255, true, 0, 0, 0, 0, 0x02, 0xff, 0xd9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got = quantizationTables(t, frame); len(got) != 2 || !bytes.Equal(got[0], tables[:64]) {
+		t.Errorf("cached quantization tables were not used")
+	}
+	if !bytes.HasSuffix(frame, []byte{0x02, 0xff, 0xd9}) || bytes.HasSuffix(frame, []byte{0xff, 0xd9, 0xff, 0xd9}) {
+		t.Errorf("end of image was added twice or lost: %x", frame[len(frame)-6:])
+	}
+
+	// another q without tables cannot be decoded
+	if _, err = d.push(jpegPacket(0, 254, true, 0, 0, 0, 0, 0x03)); err == nil {
+		t.Error("frame without quantization tables was accepted")
+	}
+}
+
+func TestJPEGDepacketizerDropsFramesWithMissingFragments(t *testing.T) {
+	d := newJPEGDepacketizer()
+	d.push(jpegPacket(0, 50, false, 0x01, 0x02))
+
+	// the fragment at offset 2 was lost
+	if frame, err := d.push(jpegPacket(4, 50, true, 0x05, 0x06)); frame != nil || err == nil {
+		t.Errorf("frame with a missing fragment returned %x, %v", frame, err)
+	}
+
+	lost := jpegPacket(0, 50, true, 0x07)
+	lost.lostBefore = true
+	if frame, err := d.push(lost); frame == nil || err != nil {
+		t.Errorf("next frame was not rebuilt: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const rtpVersion = 2
+
+// rtpPacket holds the fields of an RTP packet (RFC 3550) that are needed to rebuild the stream
+type rtpPacket struct {
+	marker         bool
+	payloadType    uint8
+	sequenceNumber uint16
+	timestamp      uint32
+	ssrc           uint32
+	payload        []byte
+}
+
+func parseRTPPacket(data []byte) (*rtpPacket, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("rtp packet too short: %d bytes", len(data))
+	}
+
+	if data[0]>>6 != rtpVersion {
+		return nil, fmt.Errorf("unsupported rtp version %d", data[0]>>6)
+	}
+
+	padding := data[0]&0x20 != 0
+	extension := data[0]&0x10 != 0
+	csrcCount := int(data[0] & 0x0f)
+
+	pkt := &rtpPacket{
+		marker:         data[1]&0x80 != 0,
+		payloadType:    data[1] & 0x7f,
+		sequenceNumber: binary.BigEndian.Uint16(data[2:4]),
+		timestamp:      binary.BigEndian.Uint32(data[4:8]),
+		ssrc:           binary.BigEndian.Uint32(data[8:12]),
+	}
+
+	offset := 12 + 4*csrcCount
+	if extension {
+		if len(data) < offset+4 {
+			return nil, fmt.Errorf("rtp packet too short for header extension")
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+	}
+
+	end := len(data)
+	if padding && end > 0 {
+		end -= int(data[end-1])
+	}
+
+	if offset > end {
+		return nil, fmt.Errorf("rtp packet has no payload")
+	}
+
+	pkt.payload = data[offset:end]
+	return pkt, nil
+}
+
+// sequenceBefore reports whether sequence number a comes before b, taking the wrap around into account
+func sequenceBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
@@ -0,0 +1,241 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"StreamingServer/consumer"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJitterDelay    = 200 * time.Millisecond
+	defaultSessionTimeout = 10 * time.Second
+	maxUDPPacketSize      = 65536
+)
+
+// PortStream maps everything received on a port to one stream, whatever the ssrc of the sender
+type PortStream struct {
+	StreamID   string
+	StreamType consts.StreamType
+	Quality    consts.Quality
+}
+
+// RTPConsumer listens for RTP streams on UDP ports.
+// Streams on mapped ports get the configured id, all others are identified by their ssrc.
+type RTPConsumer struct {
+	listenIP       string
+	ports          []int
+	streamPrefix   string
+	portStreams    map[int]PortStream
+	payloadTypes   map[uint8]consts.StreamType
+	jitterDelay    time.Duration
+	sessionTimeout time.Duration
+	activeStreams  map[string]*RTPStreamConnection
+	listeners      []*net.UDPConn
+	running        bool
+	sync.Mutex
+}
+
+func NewRTPConsumer(ip string, ports []int, streamPrefix string) *RTPConsumer {
+	return &RTPConsumer{
+		listenIP:     ip,
+		ports:        ports,
+		streamPrefix: streamPrefix,
+		portStreams:  make(map[int]PortStream),
+		payloadTypes: map[uint8]consts.StreamType{
+			26: consts.StreamMJPG, // static payload type of RFC 2435
+			96: consts.StreamH264,
+		},
+		jitterDelay:    defaultJitterDelay,
+		sessionTimeout: defaultSessionTimeout,
+		activeStreams:  make(map[string]*RTPStreamConnection),
+	}
+}
+
+// MapPort sends everything received on the port to the given stream
+func (sc *RTPConsumer) MapPort(port int, stream PortStream) error {
+	if _, err := newDepacketizer(stream.StreamType); err != nil {
+		return err
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+	sc.portStreams[port] = stream
+	return nil
+}
+
+// MapPayloadType sets the stream type of a (dynamic) payload type for streams on unmapped ports
+func (sc *RTPConsumer) MapPayloadType(payloadType uint8, streamType consts.StreamType) error {
+	if _, err := newDepacketizer(streamType); err != nil {
+		return err
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+	sc.payloadTypes[payloadType] = streamType
+	return nil
+}
+
+// SetJitterDelay sets how long packets are held back to wait for the ones missing before them
+func (sc *RTPConsumer) SetJitterDelay(delay time.Duration) error {
+	if delay <= 0 {
+		return fmt.Errorf("Jitter delay must be positive, not %s", delay)
+	}
+
+	sc.jitterDelay = delay
+	return nil
+}
+
+func (sc *RTPConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	stream, ok := sc.activeStreams[streamID]
+	if !ok {
+		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
+	}
+
+	return stream, nil
+}
+
+// Start listens on all ports and blocks until the consumer is stopped
+func (sc *RTPConsumer) Start() error {
+	sc.Lock()
+	for _, port := range sc.ports {
+		address := net.JoinHostPort(sc.listenIP, strconv.Itoa(port))
+		udpAddr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			sc.Unlock()
+			sc.Stop()
+			return err
+		}
+
+		listener, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			fmt.Printf("Unable to listen for RTP on port %d. Aborting due to error: %s\n", port, err)
+			sc.Unlock()
+			sc.Stop()
+			return err
+		}
+		sc.listeners = append(sc.listeners, listener)
+	}
+	sc.running = true
+	listeners := sc.listeners
+	sc.Unlock()
+
+	var wg sync.WaitGroup
+	for i, listener := range listeners {
+		wg.Add(1)
+		go func(listener *net.UDPConn, port int) {
+			defer wg.Done()
+			sc.readPackets(listener, port)
+		}(listener, sc.ports[i])
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func (sc *RTPConsumer) readPackets(listener *net.UDPConn, port int) {
+	fmt.Println("Listening for RTP packets on port", port)
+	buffer := make([]byte, maxUDPPacketSize)
+	for {
+		n, _, err := listener.ReadFromUDP(buffer)
+		if err != nil {
+			if !sc.isRunning() {
+				return
+			}
+			fmt.Printf("Error reading RTP packet on port %d: %s\n", port, err)
+			continue
+		}
+
+		pkt, err := parseRTPPacket(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			continue
+		}
+
+		stream, quality, err := sc.getOrCreateStream(port, pkt)
+		if err != nil {
+			continue
+		}
+
+		stream.pushPacket(quality, pkt)
+	}
+}
+
+// getOrCreateStream returns the stream the packet belongs to and starts handling it if it is new
+func (sc *RTPConsumer) getOrCreateStream(port int, pkt *rtpPacket) (*RTPStreamConnection, consts.Quality, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	mapping, mapped := sc.portStreams[port]
+	if !mapped {
+		streamType, ok := sc.payloadTypes[pkt.payloadType]
+		if !ok {
+			return nil, 0, fmt.Errorf("no stream type for rtp payload type %d", pkt.payloadType)
+		}
+
+		mapping = PortStream{
+			StreamID:   fmt.Sprintf("%s%08x", sc.streamPrefix, pkt.ssrc),
+			StreamType: streamType,
+			Quality:    consts.LowQuality,
+		}
+	}
+
+	stream, exists := sc.activeStreams[mapping.StreamID]
+	if !exists {
+		fmt.Println("Registering rtp stream with id:", mapping.StreamID)
+		stream = NewRTPStreamConnection(mapping.StreamID, mapping.StreamType, mapping.Quality, sc.jitterDelay, sc.sessionTimeout)
+		sc.activeStreams[mapping.StreamID] = stream
+	} else if _, err := stream.getSession(mapping.Quality); err == nil {
+		return stream, mapping.Quality, nil
+	} else if err := stream.AddConnection(mapping.Quality, nil); err != nil {
+		return nil, 0, err
+	}
+
+	go func(stream *RTPStreamConnection, quality consts.Quality) {
+		err := stream.HandleStream(quality)
+		if err != nil {
+			fmt.Println("Error handling rtp stream", stream.GetID(), err)
+		}
+
+		sc.Lock()
+		defer sc.Unlock()
+		err = stream.Close(quality)
+		if err != nil {
+			fmt.Println("Error closing stream channel")
+		}
+
+		if !stream.IsOpen() && sc.activeStreams[stream.GetID()] == stream {
+			fmt.Printf("Removing handler for %s\n", stream.GetID())
+			delete(sc.activeStreams, stream.GetID())
+		}
+	}(stream, mapping.Quality)
+
+	return stream, mapping.Quality, nil
+}
+
+func (sc *RTPConsumer) isRunning() bool {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.running
+}
+
+func (sc *RTPConsumer) Stop() error {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.running = false
+	for _, listener := range sc.listeners {
+		listener.Close()
+	}
+	sc.listeners = nil
+
+	for _, stream := range sc.activeStreams {
+		stream.stop()
+	}
+	return nil
+}
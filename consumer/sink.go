@@ -0,0 +1,30 @@
+package consumer
+
+import (
+	"StreamingServer/consts"
+	"sync"
+)
+
+// FrameSink receives every frame that a stream connection reads, e.g. to republish it to Kafka
+type FrameSink interface {
+	// WriteFrame must not block, it is called while the frame is on its way to the viewers
+	WriteFrame(streamID string, streamType consts.StreamType, quality consts.Quality, frame []byte, info FrameInfo)
+}
+
+var (
+	sinkLock  sync.RWMutex
+	frameSink FrameSink
+)
+
+// SetFrameSink sets the sink of all streams, nil removes it
+func SetFrameSink(sink FrameSink) {
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	frameSink = sink
+}
+
+func currentFrameSink() FrameSink {
+	sinkLock.RLock()
+	defer sinkLock.RUnlock()
+	return frameSink
+}
@@ -3,14 +3,25 @@ package consumer
 import (
 	"StreamingServer/consts"
 	"StreamingServer/consumer"
-	"StreamingServer/consumer/tcp/handler"
+	"StreamingServer/streamtype"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 )
 
+// sniffedConn reads the frames that were read to detect the stream type again before the rest of the connection
+type sniffedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
 type connectionStream struct {
-	conn    *net.TCPConn
+	conn    net.Conn
 	outChan chan []byte
 }
 
@@ -22,7 +33,7 @@ type TCPStreamConnection struct {
 	sync.Mutex
 }
 
-func NewTCPStreamConnection(streamID string, streamType consts.StreamType, streamQuality consts.Quality, connection *net.TCPConn) *TCPStreamConnection {
+func NewTCPStreamConnection(streamID string, streamType consts.StreamType, streamQuality consts.Quality, connection net.Conn) *TCPStreamConnection {
 	tsc := &TCPStreamConnection{
 		BaseStreamConnection: consumer.NewBaseStreamConnection(streamID, streamType),
 		streamChanMap:        make(map[consts.Quality]connectionStream),
@@ -34,9 +45,9 @@ func NewTCPStreamConnection(streamID string, streamType consts.StreamType, strea
 }
 
 func (sc *TCPStreamConnection) AddConnection(quality consts.Quality, conn interface{}) error {
-	connection, ok := conn.(*net.TCPConn)
+	connection, ok := conn.(net.Conn)
 	if !ok {
-		return fmt.Errorf("conn argument must be of type *net.TCPConn")
+		return fmt.Errorf("conn argument must be of type net.Conn")
 	}
 
 	sc.streamChanMap[quality] = connectionStream{
@@ -53,7 +64,7 @@ func (sc *TCPStreamConnection) Close(quality consts.Quality) error {
 	if sc.isOpen {
 		streamConn, ok := sc.streamChanMap[quality]
 		if !ok {
-			return fmt.Errorf("Connection for stream %s with quality %d does not exist", sc.GetID, quality)
+			return fmt.Errorf("Connection for stream %s with quality %d does not exist", sc.GetID(), quality)
 		}
 
 		err := streamConn.conn.Close()
@@ -86,7 +97,7 @@ func (sc *TCPStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []b
 	defer sc.Unlock()
 	streamChan, ok := sc.streamChanMap[quality]
 	if !ok {
-		return nil, fmt.Errorf("No stream for %s with quality %s", sc.GetID(), quality)
+		return nil, fmt.Errorf("No stream for %s with quality %d", sc.GetID(), quality)
 	}
 
 	return streamChan.outChan, nil
@@ -94,7 +105,7 @@ func (sc *TCPStreamConnection) GetOutputChan(quality consts.Quality) (<-chan []b
 
 func (sc *TCPStreamConnection) HandleStream(quality consts.Quality) error {
 	sc.isOpen = true
-	streamHandleFunc, err := tcphandler.GetTCPStreamHandleFunc(sc.GetType())
+	def, err := streamtype.Lookup(sc.GetType())
 	if err != nil {
 		return err
 	}
@@ -104,7 +115,31 @@ func (sc *TCPStreamConnection) HandleStream(quality consts.Quality) error {
 		return fmt.Errorf("no stream connection for quality %d", quality)
 	}
 
-	return streamHandleFunc(streamConn.conn, streamConn.outChan)
+	// frames pass through the connection so the stream metadata stays up to date
+	handlerChan := make(chan []byte, 32)
+	forwardDone := make(chan struct{})
+	go func() {
+		defer close(forwardDone)
+		for frame := range handlerChan {
+			frame, valid := sc.ValidateFrame(frame)
+			if !valid {
+				continue
+			}
+
+			sc.ObserveFrame(quality, frame)
+			select {
+			case streamConn.outChan <- frame:
+			default:
+				<-streamConn.outChan
+				streamConn.outChan <- frame
+			}
+		}
+	}()
+
+	err = def.Ingest(streamConn.conn, handlerChan)
+	close(handlerChan)
+	<-forwardDone
+	return err
 }
 
 func (sc *TCPStreamConnection) IsOpen() bool {
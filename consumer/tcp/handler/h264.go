@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
-	"net"
 	"time"
 )
 
-func HandleH264Stream(connection *net.TCPConn, outputChannel chan []byte) error {
+func HandleH264Stream(connection io.Reader, outputChannel chan []byte) error {
 
 	var frameBuffer []byte
 	var nalSeparator = []byte{0, 0, 0, 1}
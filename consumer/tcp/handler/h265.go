@@ -0,0 +1,63 @@
+package tcphandler
+
+import (
+	"StreamingServer/codec"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HandleH265Stream reads size prefixed HEVC access units and forwards every NAL unit separately.
+// The NAL units that cannot be parsed are dropped.
+func HandleH265Stream(connection io.Reader, outputChannel chan []byte) error {
+	count := 0
+	start := time.Now().Unix()
+	finish := time.Now().Unix()
+	var frameSize int32
+	for {
+		err := binary.Read(connection, binary.LittleEndian, &frameSize)
+		if err != nil {
+			fmt.Printf("Error while reading Frame Size from socket: %s\n", err)
+			return err
+		}
+
+		if frameSize == 0 {
+			fmt.Println("Streaming Client closed the connection.")
+			return nil
+		}
+
+		frameBuffer := make([]byte, frameSize)
+		_, err = io.ReadFull(connection, frameBuffer)
+		if err != nil {
+			return nil
+		}
+
+		count += len(frameBuffer)
+		finish = time.Now().Unix()
+		if finish-start > 10 {
+			bandwidth_mbit := ((float64(count) / float64(finish-start)) / 1000000.0) * 8.0
+			fmt.Println("Reading", bandwidth_mbit, "Mbits/s")
+			start = time.Now().Unix()
+			count = 0
+		}
+
+		for _, nal := range codec.SplitAnnexB(frameBuffer) {
+			header, err := codec.ParseHEVCNALHeader(nal)
+			if err != nil || header.ForbiddenBit {
+				fmt.Println("Dropping invalid HEVC NAL unit:", err)
+				continue
+			}
+
+			nalUnit := codec.WithStartCode(nal)
+
+			// if output channel is full start discarding NAL units.
+			select {
+			case outputChannel <- nalUnit:
+			default:
+				<-outputChannel
+				outputChannel <- nalUnit
+			}
+		}
+	}
+}
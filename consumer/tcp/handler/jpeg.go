@@ -3,12 +3,12 @@ package tcphandler
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
-	"net"
 	"time"
 )
 
-func HandleJpegStream(connection *net.TCPConn, outputChannel chan []byte) error {
+func HandleJpegStream(connection io.Reader, outputChannel chan []byte) error {
 	count := 0
 	start := time.Now().Unix()
 	finish := time.Now().Unix()
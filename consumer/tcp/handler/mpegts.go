@@ -0,0 +1,99 @@
+package tcphandler
+
+import (
+	"StreamingServer/codec"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	tsPacketSize = codec.TSPacketSize
+	tsSyncByte   = codec.TSSyncByte
+
+	// number of ts packets that are forwarded together as one chunk
+	tsPacketsPerChunk = 16
+)
+
+// HandleMPEGTSStream reads a raw MPEG-TS byte stream from the connection.
+// Unlike the mjpg and h264 streams there is no size prefix, the data is forwarded
+// in chunks that always start on a sync byte and contain only whole 188 byte packets.
+func HandleMPEGTSStream(connection io.Reader, outputChannel chan []byte) error {
+	count := 0
+	start := time.Now().Unix()
+	finish := time.Now().Unix()
+
+	var tsBuffer []byte
+	readBuffer := make([]byte, tsPacketSize*tsPacketsPerChunk)
+	for {
+		readCount, err := connection.Read(readBuffer)
+		if err == io.EOF {
+			fmt.Println("Streaming Client closed the connection.")
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("Error while reading MPEG-TS data from socket: %s\n", err)
+			return err
+		}
+
+		tsBuffer = append(tsBuffer, readBuffer[:readCount]...)
+		tsBuffer = alignTSBuffer(tsBuffer)
+
+		// a lost sync byte ends the chunk early so the rest can be realigned
+		nPackets := countAlignedTSPackets(tsBuffer)
+		if nPackets == 0 || (nPackets < tsPacketsPerChunk && nPackets == len(tsBuffer)/tsPacketSize) {
+			continue
+		}
+
+		chunk := make([]byte, nPackets*tsPacketSize)
+		copy(chunk, tsBuffer)
+		tsBuffer = tsBuffer[len(chunk):]
+
+		count += len(chunk)
+		finish = time.Now().Unix()
+		if finish-start > 10 {
+			bandwidth_mbit := ((float64(count) / float64(finish-start)) / 1000000.0) * 8.0
+			fmt.Println("Reading", bandwidth_mbit, "Mbits/s")
+			start = time.Now().Unix()
+			count = 0
+		}
+
+		// if output channel is full start discarding chunks.
+		select {
+		case outputChannel <- chunk:
+		default:
+			<-outputChannel
+			outputChannel <- chunk
+		}
+	}
+}
+
+// alignTSBuffer drops bytes from the start of the buffer until it begins with a sync byte
+// that is followed by another sync byte exactly one packet later.
+func alignTSBuffer(buffer []byte) []byte {
+	for len(buffer) > tsPacketSize {
+		if buffer[0] == tsSyncByte && buffer[tsPacketSize] == tsSyncByte {
+			return buffer
+		}
+
+		next := bytes.IndexByte(buffer[1:], tsSyncByte)
+		if next < 0 {
+			return buffer[:0]
+		}
+		buffer = buffer[next+1:]
+	}
+
+	return buffer
+}
+
+// countAlignedTSPackets returns the number of whole packets at the start of the buffer
+// that begin with a sync byte.
+func countAlignedTSPackets(buffer []byte) int {
+	nPackets := 0
+	for (nPackets+1)*tsPacketSize <= len(buffer) && buffer[nPackets*tsPacketSize] == tsSyncByte {
+		nPackets++
+	}
+
+	return nPackets
+}
@@ -0,0 +1,98 @@
+package tcphandler
+
+import (
+	"StreamingServer/codec"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+var (
+	jpegSOI = []byte{0xff, 0xd8}
+	jpegEOI = []byte{0xff, 0xd9}
+)
+
+func sendDroppingOldest(outputChannel chan []byte, data []byte) {
+	select {
+	case outputChannel <- data:
+	default:
+		<-outputChannel
+		outputChannel <- data
+	}
+}
+
+// HandleRawAnnexBStream reads an Annex-B byte stream without size prefixes (e.g. raspivid or libcamera-vid output)
+// and forwards every NAL unit with its start code
+func HandleRawAnnexBStream(reader io.Reader, outputChannel chan []byte) error {
+	var streamBuffer []byte
+	readBuffer := make([]byte, 64*1024)
+	for {
+		readCount, err := reader.Read(readBuffer)
+		streamBuffer = append(streamBuffer, readBuffer[:readCount]...)
+
+		// everything up to the last start code is made of complete NAL units
+		lastStart := bytes.LastIndex(streamBuffer, codec.NALStartCode[1:])
+		if lastStart > 0 {
+			for _, nal := range codec.SplitAnnexB(streamBuffer[:lastStart]) {
+				sendDroppingOldest(outputChannel, codec.WithStartCode(nal))
+			}
+			streamBuffer = append(streamBuffer[:0], streamBuffer[lastStart:]...)
+		}
+
+		if err == io.EOF {
+			for _, nal := range codec.SplitAnnexB(streamBuffer) {
+				sendDroppingOldest(outputChannel, codec.WithStartCode(nal))
+			}
+			fmt.Println("Raw stream ended.")
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("Error while reading raw stream: %s\n", err)
+			return err
+		}
+	}
+}
+
+// HandleRawJpegStream reads concatenated JPEG images without size prefixes (e.g. raspivid -cd MJPEG output)
+func HandleRawJpegStream(reader io.Reader, outputChannel chan []byte) error {
+	var streamBuffer []byte
+	readBuffer := make([]byte, 64*1024)
+	for {
+		readCount, err := reader.Read(readBuffer)
+		streamBuffer = append(streamBuffer, readBuffer[:readCount]...)
+
+		for {
+			start := bytes.Index(streamBuffer, jpegSOI)
+			if start < 0 {
+				// keep a trailing 0xff that could be the start of the next image
+				if len(streamBuffer) > 0 && streamBuffer[len(streamBuffer)-1] == 0xff {
+					streamBuffer = append(streamBuffer[:0], 0xff)
+				} else {
+					streamBuffer = streamBuffer[:0]
+				}
+				break
+			}
+
+			end := bytes.Index(streamBuffer[start+len(jpegSOI):], jpegEOI)
+			if end < 0 {
+				streamBuffer = append(streamBuffer[:0], streamBuffer[start:]...)
+				break
+			}
+
+			imageEnd := start + len(jpegSOI) + end + len(jpegEOI)
+			image := make([]byte, imageEnd-start)
+			copy(image, streamBuffer[start:imageEnd])
+			sendDroppingOldest(outputChannel, image)
+			streamBuffer = streamBuffer[imageEnd:]
+		}
+
+		if err == io.EOF {
+			fmt.Println("Raw stream ended.")
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("Error while reading raw stream: %s\n", err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tcphandler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// largest first frame that is read to detect the stream type
+	maxSniffFrameSize = 16 * 1024 * 1024
+
+	// number of bytes read from a raw byte stream to detect the stream type
+	rawSniffSize = 4096
+)
+
+// SniffStream reads the first frame of a stream in the tcp framing, i.e. a size prefixed frame or raw MPEG-TS packets.
+// It returns the sample, whether the sample is a whole frame and a reader that returns the stream from its start.
+func SniffStream(connection io.Reader) ([]byte, bool, io.Reader, error) {
+	header := make([]byte, 4, 2*tsPacketSize)
+	_, err := io.ReadFull(connection, header)
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	read := header
+	if header[0] == tsSyncByte {
+		read = read[:2*tsPacketSize]
+		_, err = io.ReadFull(connection, read[4:])
+		if err != nil {
+			return nil, false, nil, err
+		}
+
+		if read[tsPacketSize] == tsSyncByte {
+			return read, false, io.MultiReader(bytes.NewReader(read), connection), nil
+		}
+	}
+
+	// not MPEG-TS, so the stream starts with the size of the first frame
+	frameSize := int32(binary.LittleEndian.Uint32(header))
+	if frameSize <= 0 || frameSize > maxSniffFrameSize {
+		return nil, false, nil, fmt.Errorf("First frame has invalid size %d", frameSize)
+	}
+
+	frameEnd := 4 + int(frameSize)
+	if len(read) < frameEnd {
+		frameStart := len(read)
+		read = append(read, make([]byte, frameEnd-frameStart)...)
+		_, err = io.ReadFull(connection, read[frameStart:])
+		if err != nil {
+			return nil, false, nil, err
+		}
+	}
+
+	return read[4:frameEnd], true, io.MultiReader(bytes.NewReader(read), connection), nil
+}
+
+// SniffRawStream reads the start of a raw byte stream.
+// It returns the sample and a reader that returns the stream from its start.
+func SniffRawStream(connection io.Reader) ([]byte, io.Reader, error) {
+	sample := make([]byte, rawSniffSize)
+	readCount, err := io.ReadFull(connection, sample)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+
+	sample = sample[:readCount]
+	return sample, io.MultiReader(bytes.NewReader(sample), connection), nil
+}
@@ -3,34 +3,62 @@ package consumer
 import (
 	"StreamingServer/consts"
 	"StreamingServer/consumer"
+	tcphandler "StreamingServer/consumer/tcp/handler"
+	"StreamingServer/streamtype"
 	"encoding/binary"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// how long a new publisher may take to send its first frame
+const sniffTimeout = 10 * time.Second
+
+// TCPConsumer accepts stream connections over TCP (IPv4 and IPv6) or a Unix domain socket
 type TCPConsumer struct {
 	maxStreamers    int
 	readersReady    int32
 	activeStreamers map[string]*TCPStreamConnection
-	listenIP        string
-	listenPort      int
+	network         string
+	address         string
 	streamPrefix    string
 	running         bool
+	// pendingHandshakes counts the publishers that connected but did not send their first frame yet
+	pendingHandshakes int
+	sync.Mutex
 }
 
 func NewTCPConsumer(ip string, port, maxStreamers int, streamPrefix string) *TCPConsumer {
 	return &TCPConsumer{
 		activeStreamers: make(map[string]*TCPStreamConnection),
 		maxStreamers:    maxStreamers,
-		listenIP:        ip,
-		listenPort:      port,
+		network:         "tcp",
+		address:         net.JoinHostPort(ip, strconv.Itoa(port)),
+		streamPrefix:    streamPrefix,
+		readersReady:    0,
+	}
+}
+
+// NewUnixSocketConsumer accepts the same connections as the TCPConsumer on a Unix domain socket,
+// for encoders running on the same machine
+func NewUnixSocketConsumer(socketPath string, maxStreamers int, streamPrefix string) *TCPConsumer {
+	return &TCPConsumer{
+		activeStreamers: make(map[string]*TCPStreamConnection),
+		maxStreamers:    maxStreamers,
+		network:         "unix",
+		address:         socketPath,
 		streamPrefix:    streamPrefix,
 		readersReady:    0,
 	}
 }
 
 func (sc *TCPConsumer) GetStream(streamID string) (consumer.StreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
 	stream, ok := sc.activeStreamers[streamID]
 	if !ok {
 		return nil, fmt.Errorf("No stream registered with id '%s\n", streamID)
@@ -41,7 +69,7 @@ func (sc *TCPConsumer) GetStream(streamID string) (consumer.StreamConnection, er
 
 func (sc *TCPConsumer) Stop() error {
 	sc.running = false
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", sc.listenIP, sc.listenPort))
+	conn, err := net.Dial(sc.network, sc.address)
 	if err != nil {
 		sc.running = true
 		return err
@@ -51,74 +79,128 @@ func (sc *TCPConsumer) Stop() error {
 }
 
 func (sc *TCPConsumer) Start() error {
-	address := fmt.Sprintf("%s:%d", sc.listenIP, sc.listenPort)
-	tcpAddr, _ := net.ResolveTCPAddr("tcp4", address)
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if sc.network == "unix" {
+		// remove the socket left behind by a previous run
+		os.Remove(sc.address)
+	}
+
+	listener, err := net.Listen(sc.network, sc.address)
 	if err != nil {
-		fmt.Printf("Unable to start TCP Server on port 1235. Aborting due to error: %s\n", err)
+		fmt.Printf("Unable to start %s Server on %s. Aborting due to error: %s\n", sc.network, sc.address, err)
 		return err
 	}
 
-	mutex := &sync.Mutex{}
-	cond := sync.NewCond(mutex)
+	streamsChanged := sync.NewCond(&sc.Mutex)
 	sc.running = true
 	for sc.running {
 		fmt.Println("Listening for connection...")
-		conn, err := listener.AcceptTCP()
+		conn, err := listener.Accept()
 		if err != nil {
 			fmt.Printf("Error occurred when accepting connection, not handling this client: %s\n", err)
 			continue
 		}
 
-		var streamIDTypeQuality [3]int32
-		for i := 0; i < 3; i++ {
-			streamIDTypeQuality[i], err = sc.readInt32(conn)
-			if err != nil {
-				fmt.Printf("Error occurred when reading stream type, not handling this client: %s\n", err)
-				conn.Close()
-				continue
-			}
-		}
+		// a slow publisher must not keep the others from connecting while it sends its first frame,
+		// but it counts against the streamers until it is registered or rejected
+		sc.Lock()
+		sc.pendingHandshakes++
+		sc.Unlock()
+		go sc.handleConnection(conn, streamsChanged)
 
-		fmt.Println("Received connection successfully, passing to handler.")
-		streamID := fmt.Sprintf("%s%d", sc.streamPrefix, streamIDTypeQuality[0])
-		connection := NewTCPStreamConnection(streamID, consts.StreamTypeIDs[int(streamIDTypeQuality[1])], consts.Quality(streamIDTypeQuality[2]), conn)
-
-		fmt.Println("Registering stream with id:", streamID)
-		sc.activeStreamers[streamID] = connection
-
-		go func(sConnection *TCPStreamConnection) {
-			defer func() {
-				// No error means it is not handling anymore streams
-				err = sConnection.Close(consts.Quality(streamIDTypeQuality[2]))
-				if err != nil {
-					fmt.Println("Error closing stream channel")
-				}
-
-				if !sConnection.IsOpen() {
-					fmt.Printf("Removing handler for %s\n", streamID)
-					delete(sc.activeStreamers, streamID)
-				}
-
-				mutex.Lock()
-				cond.Broadcast()
-				mutex.Unlock()
-			}()
-
-			sConnection.HandleStream(consts.Quality(streamIDTypeQuality[2]))
-		}(connection)
-
-		for len(sc.activeStreamers) == sc.maxStreamers {
-			mutex.Lock()
-			cond.Wait()
-			mutex.Unlock()
+		sc.Lock()
+		for len(sc.activeStreamers)+sc.pendingHandshakes >= sc.maxStreamers {
+			streamsChanged.Wait()
 		}
+		sc.Unlock()
 	}
 	listener.Close()
 	return nil
 }
 
-func (sc *TCPConsumer) readInt32(connection *net.TCPConn) (int32, error) {
+// handleConnection reads the header and the first frame of a publisher and then serves its stream until it disconnects
+func (sc *TCPConsumer) handleConnection(conn net.Conn, streamsChanged *sync.Cond) {
+	handshaking := true
+	defer func() {
+		if handshaking {
+			sc.Lock()
+			sc.pendingHandshakes--
+			streamsChanged.Broadcast()
+			sc.Unlock()
+		}
+	}()
+
+	// the header and the first frame confirm the declared stream type or tell the type of auto streams
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	var streamIDTypeQuality [3]int32
+	for i := 0; i < 3; i++ {
+		var err error
+		streamIDTypeQuality[i], err = sc.readInt32(conn)
+		if err != nil {
+			fmt.Printf("Error occurred when reading stream type, not handling this client: %s\n", err)
+			conn.Close()
+			return
+		}
+	}
+
+	declaredType := consts.StreamAuto
+	if streamIDTypeQuality[1] != streamtype.AutoWireID {
+		def, err := streamtype.LookupWireID(int(streamIDTypeQuality[1]))
+		if err != nil {
+			fmt.Printf("Unknown stream type, not handling this client: %s\n", err)
+			conn.Close()
+			return
+		}
+		declaredType = def.Type
+	}
+
+	sample, wholeFrame, reader, err := tcphandler.SniffStream(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		fmt.Printf("Error occurred when reading the first frame, not handling this client: %s\n", err)
+		conn.Close()
+		return
+	}
+
+	def, err := streamtype.Resolve(declaredType, sample, wholeFrame)
+	if err != nil {
+		fmt.Printf("Rejecting stream, not handling this client: %s\n", err)
+		conn.Close()
+		return
+	}
+
+	fmt.Println("Received connection successfully, passing to handler.")
+	streamID := fmt.Sprintf("%s%d", sc.streamPrefix, streamIDTypeQuality[0])
+	quality := consts.Quality(streamIDTypeQuality[2])
+	connection := NewTCPStreamConnection(streamID, def.Type, quality, &sniffedConn{conn, reader})
+
+	fmt.Println("Registering stream with id:", streamID)
+	sc.Lock()
+	sc.activeStreamers[streamID] = connection
+	sc.pendingHandshakes--
+	handshaking = false
+	streamsChanged.Broadcast()
+	sc.Unlock()
+
+	defer func() {
+		// No error means it is not handling anymore streams
+		err := connection.Close(quality)
+		if err != nil {
+			fmt.Println("Error closing stream channel")
+		}
+
+		sc.Lock()
+		if !connection.IsOpen() && sc.activeStreamers[streamID] == connection {
+			fmt.Printf("Removing handler for %s\n", streamID)
+			delete(sc.activeStreamers, streamID)
+		}
+		streamsChanged.Broadcast()
+		sc.Unlock()
+	}()
+
+	connection.HandleStream(quality)
+}
+
+func (sc *TCPConsumer) readInt32(connection net.Conn) (int32, error) {
 	var val int32
 	err := binary.Read(connection, binary.LittleEndian, &val)
 	if err != nil {
@@ -129,6 +211,9 @@ func (sc *TCPConsumer) readInt32(connection *net.TCPConn) (int32, error) {
 }
 
 func (sc *TCPConsumer) getStreamConnection(streamID string) (*TCPStreamConnection, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
 	for k, v := range sc.activeStreamers {
 		if k == streamID {
 			return v, nil
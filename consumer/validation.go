@@ -0,0 +1,119 @@
+package consumer
+
+import (
+	"StreamingServer/streamtype"
+	"fmt"
+	"sync"
+)
+
+// ValidationPolicy tells what happens to frames that fail validation
+type ValidationPolicy string
+
+const (
+	// ValidationPass counts corrupt frames but forwards them unchanged
+	ValidationPass ValidationPolicy = "pass"
+	// ValidationDrop counts and drops corrupt frames
+	ValidationDrop ValidationPolicy = "drop"
+	// ValidationRepair tries to repair corrupt frames and drops them if that fails
+	ValidationRepair ValidationPolicy = "repair"
+
+	// Stats of the frame validation that are shown in the stream metadata
+	statCorruptFrames  = "corrupt_frames"
+	statDroppedFrames  = "corrupt_frames_dropped"
+	statRepairedFrames = "corrupt_frames_repaired"
+)
+
+// Validation configures the frame validation of a stream
+type Validation struct {
+	// Policy for corrupt frames, frames are not validated if it is empty
+	Policy ValidationPolicy
+	// FullDecode decodes every frame if the stream type supports it, e.g. JPEG
+	FullDecode bool
+}
+
+var (
+	validationLock    sync.RWMutex
+	defaultValidation Validation
+	streamValidations = make(map[string]Validation)
+)
+
+// ParseValidationPolicy returns the policy with the given name, an empty name disables validation
+func ParseValidationPolicy(name string) (ValidationPolicy, error) {
+	switch policy := ValidationPolicy(name); policy {
+	case "", ValidationPass, ValidationDrop, ValidationRepair:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Validation policy must be one of pass, drop or repair, not '%s'", name)
+	}
+}
+
+// SetDefaultValidation sets the validation of the streams without their own validation
+func SetDefaultValidation(validation Validation) {
+	validationLock.Lock()
+	defer validationLock.Unlock()
+	defaultValidation = validation
+}
+
+// SetStreamValidation sets the validation of a single stream.
+// It is used by the stream connections that are created after the call.
+func SetStreamValidation(streamID string, validation Validation) {
+	validationLock.Lock()
+	defer validationLock.Unlock()
+	streamValidations[streamID] = validation
+}
+
+func lookupValidation(streamID string) Validation {
+	validationLock.RLock()
+	defer validationLock.RUnlock()
+
+	validation, ok := streamValidations[streamID]
+	if !ok {
+		return defaultValidation
+	}
+
+	return validation
+}
+
+// frameValidator applies the validation of a stream to its frames
+type frameValidator struct {
+	Validation
+	validate func(frame []byte, fullDecode bool) error
+	repair   func(frame []byte) ([]byte, error)
+}
+
+func newFrameValidator(def streamtype.Definition, validation Validation) *frameValidator {
+	if validation.Policy == "" || def.Validate == nil {
+		return nil
+	}
+
+	return &frameValidator{
+		Validation: validation,
+		validate:   def.Validate,
+		repair:     def.Repair,
+	}
+}
+
+// check returns the frame that should be forwarded, which is nil if the frame is dropped
+func (fv *frameValidator) check(frame []byte, metadata *metadataCollector) []byte {
+	err := fv.validate(frame, fv.FullDecode)
+	if err == nil {
+		return frame
+	}
+
+	metadata.incrementStat(statCorruptFrames, 1)
+	switch fv.Policy {
+	case ValidationPass:
+		return frame
+	case ValidationRepair:
+		if fv.repair != nil {
+			repaired, err := fv.repair(frame)
+			if err == nil {
+				metadata.incrementStat(statRepairedFrames, 1)
+				return repaired
+			}
+		}
+	}
+
+	metadata.incrementStat(statDroppedFrames, 1)
+	return nil
+}
@@ -2,17 +2,46 @@ package main
 
 import (
 	broadcaster "StreamingServer/broadcaster/http"
+	streamconsumer "StreamingServer/consumer"
 	consumer "StreamingServer/consumer/kafka"
+	_ "StreamingServer/streamtype/builtin"
+	"flag"
+	"fmt"
+	"os"
 )
 
 func main() {
+	sourcesFile := flag.String("sources", "", "json file with the named kafka sources to read, see README")
+	flag.Parse()
+
 	maxStreams := 8
 	streamPrefix := "stream"
-	kwargs := make(consumer.KafkaArgs)
-	kwargs["group_id"] = "rpi2"
-	kwargs["topics"] = "stream0_h264_low"
-	consumer, _ := consumer.NewKafkaConsumer("kafka02:9092,kafka03:9092,kafka04:9092", kwargs)
-	httpBroadcaster := broadcaster.NewHTTPBroadcaster(consumer)
+	var streamServer streamconsumer.StreamConsumer
+	if *sourcesFile != "" {
+		sources, err := consumer.LoadKafkaSources(*sourcesFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		streamServer, err = consumer.NewMultiKafkaConsumer(sources)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		kwargs := make(consumer.KafkaArgs)
+		kwargs["group_id"] = "rpi2"
+		kwargs["topics"] = "stream0_h264_low"
+		kafkaServer, err := consumer.NewKafkaConsumer("kafka02:9092,kafka03:9092,kafka04:9092", kwargs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		streamServer = kafkaServer
+	}
+
+	httpBroadcaster := broadcaster.NewHTTPBroadcaster(streamServer)
 	go httpBroadcaster.Start()
 	httpBroadcaster.PrepareStreamHandlers(streamPrefix, maxStreams)
 	httpBroadcaster.StartServer("", 80)
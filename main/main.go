@@ -1,16 +1,205 @@
 package main
 
 import (
+	hls "StreamingServer/broadcaster/hls"
 	broadcaster "StreamingServer/broadcaster/http"
-	consumer "StreamingServer/consumer/tcp"
+	rtsp "StreamingServer/broadcaster/rtsp"
+	webrtc "StreamingServer/broadcaster/webrtc"
+	"StreamingServer/consts"
+	consumer "StreamingServer/consumer"
+	grpcconsumer "StreamingServer/consumer/grpc"
+	kafkaconsumer "StreamingServer/consumer/kafka"
+	pipeconsumer "StreamingServer/consumer/pipe"
+	tcpconsumer "StreamingServer/consumer/tcp"
+	_ "StreamingServer/streamtype/builtin"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
+// configureValidation sets the default policy for corrupt frames and the per stream
+// policies given as comma separated stream=policy pairs
+func configureValidation(policy string, fullDecode bool, streamPolicies string) error {
+	defaultPolicy, err := consumer.ParseValidationPolicy(policy)
+	if err != nil {
+		return err
+	}
+	consumer.SetDefaultValidation(consumer.Validation{Policy: defaultPolicy, FullDecode: fullDecode})
+
+	if streamPolicies == "" {
+		return nil
+	}
+
+	for _, streamPolicy := range strings.Split(streamPolicies, ",") {
+		parts := strings.SplitN(streamPolicy, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Stream validation must look like stream=policy, not '%s'", streamPolicy)
+		}
+
+		policy, err := consumer.ParseValidationPolicy(parts[1])
+		if err != nil {
+			return err
+		}
+		consumer.SetStreamValidation(parts[0], consumer.Validation{Policy: policy, FullDecode: fullDecode})
+	}
+
+	return nil
+}
+
+// configureKafkaSink republishes the frames of all streams to the kafka brokers,
+// args are comma separated key=value pairs of the KafkaProducer
+func configureKafkaSink(brokers string, args string) error {
+	kafkaArgs := kafkaconsumer.KafkaArgs{}
+	if args != "" {
+		for _, arg := range strings.Split(args, ",") {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("Kafka sink args must look like key=value, not '%s'", arg)
+			}
+			kafkaArgs[parts[0]] = parts[1]
+		}
+	}
+
+	producer, err := kafkaconsumer.NewKafkaProducer(brokers, kafkaArgs)
+	if err != nil {
+		return fmt.Errorf("Unable to create kafka sink: %s", err)
+	}
+
+	consumer.SetFrameSink(producer)
+	return nil
+}
+
+// newKafkaSources reads the streams of the kafka sources in the json file
+func newKafkaSources(path string) (*kafkaconsumer.MultiKafkaConsumer, error) {
+	sources, err := kafkaconsumer.LoadKafkaSources(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return kafkaconsumer.NewMultiKafkaConsumer(sources)
+}
+
+// runIngest serves a single raw stream read from stdin or a named pipe, e.g.
+// libcamera-vid -o - | streaming_server ingest --stream porch
+func runIngest(args []string) {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	streamID := flags.String("stream", "stream0", "id the stream is served under")
+	streamType := flags.String("type", string(consts.StreamH264), "stream type of the raw input, auto detects it from the first bytes")
+	input := flags.String("input", pipeconsumer.StdinPath, "named pipe to read from, - for stdin")
+	port := flags.Int("port", 80, "http port")
+	validate := flags.String("validate", "", "what to do with corrupt frames: pass, drop or repair, frames are not checked if empty")
+	fullDecode := flags.Bool("full-decode", false, "decode every frame when validating, if the stream type supports it")
+	flags.Parse(args)
+
+	if err := configureValidation(*validate, *fullDecode, ""); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	pipeConsumer, err := pipeconsumer.NewPipeConsumer(*input, *streamID, consts.StreamType(*streamType), consts.LowQuality)
+	if err != nil {
+		fmt.Println("Unable to read stream:", err)
+		os.Exit(1)
+	}
+
+	httpBroadcaster := broadcaster.NewHTTPBroadcaster(pipeConsumer)
+	go httpBroadcaster.Start()
+	httpBroadcaster.AddStreamHandler(*streamID)
+	// registers the static files and the metadata api
+	httpBroadcaster.PrepareStreamHandlers("", 0)
+	httpBroadcaster.StartServer("", *port)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ingest" {
+		runIngest(os.Args[2:])
+		return
+	}
+
+	unixSocket := flag.String("unix", "", "listen for streams on this unix domain socket instead of tcp port 12345")
+	grpcPort := flag.Int("grpc", 0, "accept streams from gRPC publishers on this port instead of tcp port 12345")
+	validate := flag.String("validate", "", "what to do with corrupt frames: pass, drop or repair, frames are not checked if empty")
+	streamValidate := flag.String("stream-validate", "", "per stream policies for corrupt frames, e.g. stream0=drop,stream1=repair")
+	fullDecode := flag.Bool("full-decode", false, "decode every frame when validating, if the stream type supports it")
+	kafkaSources := flag.String("kafka-sources", "", "json file with kafka sources whose streams are served besides the local ones")
+	preferKafka := flag.Bool("prefer-kafka", false, "serve the kafka stream if a local stream has the same id")
+	kafkaSink := flag.String("kafka-sink", "", "comma separated kafka brokers that all ingested frames are republished to")
+	kafkaSinkArgs := flag.String("kafka-sink-args", "", "settings of the kafka sink, e.g. client_id=edge1,max_message_size=500000")
+	hlsSegment := flag.Duration("hls-segment", 2*time.Second, "target duration of the hls segments, they are cut at the next keyframe")
+	hlsWindow := flag.Int("hls-window", 6, "number of hls segments kept in memory and listed in the playlists")
+	hlsFormat := flag.String("hls-format", hls.FormatTS, "container of the hls segments: ts or fmp4")
+	iceServers := flag.String("ice-servers", "", "comma separated STUN/TURN urls for webrtc viewers behind NAT, e.g. stun:stun.l.google.com:19302")
+	rtspPort := flag.Int("rtsp-port", 8554, "port of the rtsp server, 0 disables it")
+	rtspRTPPort := flag.Int("rtsp-rtp-port", 8000, "udp port the rtsp packets are sent from, rtcp uses the next one, 0 only allows tcp")
+	flag.Parse()
+
+	if err := configureValidation(*validate, *fullDecode, *streamValidate); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *kafkaSink != "" {
+		if err := configureKafkaSink(*kafkaSink, *kafkaSinkArgs); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	maxStreams := 8
 	streamPrefix := "stream"
-	streamServer := consumer.NewTCPConsumer("", 12345, maxStreams, streamPrefix)
+	var streamServer consumer.StreamConsumer = tcpconsumer.NewTCPConsumer("", 12345, maxStreams, streamPrefix)
+	if *unixSocket != "" {
+		streamServer = tcpconsumer.NewUnixSocketConsumer(*unixSocket, maxStreams, streamPrefix)
+	}
+	if *grpcPort > 0 {
+		streamServer = grpcconsumer.NewGRPCConsumer("", *grpcPort)
+	}
+
+	if *kafkaSources != "" {
+		kafkaServer, err := newKafkaSources(*kafkaSources)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// the cameras of this server take precedence over kafka streams with the same id unless kafka is preferred
+		children := []consumer.CompositeChild{
+			{Name: "local", Consumer: streamServer},
+			{Name: "kafka", Consumer: kafkaServer},
+		}
+		if *preferKafka {
+			children[0], children[1] = children[1], children[0]
+		}
+
+		streamServer, err = consumer.NewCompositeConsumer(children...)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	httpBroadcaster := broadcaster.NewHTTPBroadcaster(streamServer)
+	hlsConfig := hls.DefaultConfig()
+	hlsConfig.SegmentDuration, hlsConfig.Window, hlsConfig.Format = *hlsSegment, *hlsWindow, *hlsFormat
+	if err := httpBroadcaster.ConfigureHLS(hlsConfig); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *iceServers != "" {
+		httpBroadcaster.ConfigureWebRTC(webrtc.Config{ICEServers: strings.Split(*iceServers, ",")})
+	}
+
 	go httpBroadcaster.Start()
+	if *rtspPort > 0 {
+		rtspServer := rtsp.NewServer(httpBroadcaster.Broadcaster, rtsp.Config{Port: *rtspPort, RTPPort: *rtspRTPPort})
+		go func() {
+			if err := rtspServer.Start(); err != nil {
+				fmt.Println("Unable to serve rtsp:", err)
+			}
+		}()
+	}
 	httpBroadcaster.PrepareStreamHandlers(streamPrefix, maxStreams)
 	httpBroadcaster.StartServer("", 80)
 }
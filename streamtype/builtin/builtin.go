@@ -0,0 +1,98 @@
+// Package builtin registers the stream types that ship with the server.
+// Import it for its side effects in the main package.
+package builtin
+
+import (
+	"StreamingServer/broadcaster/http/httphandler"
+	"StreamingServer/codec"
+	"StreamingServer/consts"
+	tcphandler "StreamingServer/consumer/tcp/handler"
+	"StreamingServer/streamtype"
+)
+
+const (
+	OutputMultipart = "multipart"
+	OutputWebsocket = "websocket"
+	OutputFMP4      = "fmp4"
+)
+
+// intraOnlyInspector is used for streams where every frame can be decoded on its own
+type intraOnlyInspector struct{}
+
+func (intraOnlyInspector) Inspect(frame []byte) bool {
+	return true
+}
+
+func (intraOnlyInspector) Parameters() map[string]string {
+	return nil
+}
+
+func (intraOnlyInspector) ParameterSets() [][]byte {
+	return nil
+}
+
+func init() {
+	streamtype.MustRegister(streamtype.Definition{
+		Type:      consts.StreamMJPG,
+		WireID:    0,
+		MIMEType:  "image/jpeg",
+		Ingest:    tcphandler.HandleJpegStream,
+		RawIngest: tcphandler.HandleRawJpegStream,
+		Outputs: map[string]streamtype.Output{
+			OutputMultipart: {Write: httphandler.HandleJpegStreamRequest, Headers: httphandler.SendJpegHeaders},
+		},
+		DefaultOutput: OutputMultipart,
+		NewInspector:  func() streamtype.FrameInspector { return intraOnlyInspector{} },
+		Detect:        codec.LooksLikeJPEG,
+		Validate:      codec.ValidateJPEG,
+		Repair:        codec.RepairJPEG,
+	})
+
+	streamtype.MustRegister(streamtype.Definition{
+		Type:      consts.StreamH264,
+		WireID:    1,
+		MIMEType:  "video/h264",
+		Ingest:    tcphandler.HandleH264Stream,
+		RawIngest: tcphandler.HandleRawAnnexBStream,
+		Outputs: map[string]streamtype.Output{
+			OutputWebsocket: {Write: httphandler.HandleH264StreamRequest},
+			OutputFMP4:      {Write: httphandler.HandleH264FMP4StreamRequest},
+		},
+		DefaultOutput: OutputWebsocket,
+		NewInspector:  func() streamtype.FrameInspector { return &codec.H264Inspector{} },
+		Detect:        codec.LooksLikeH264,
+		Validate:      codec.ValidateH264,
+		Repair:        codec.RepairH264,
+	})
+
+	streamtype.MustRegister(streamtype.Definition{
+		Type:      consts.StreamMPEGTS,
+		WireID:    2,
+		MIMEType:  "video/mp2t",
+		Ingest:    tcphandler.HandleMPEGTSStream,
+		RawIngest: tcphandler.HandleMPEGTSStream,
+		Outputs: map[string]streamtype.Output{
+			OutputWebsocket: {Write: httphandler.HandleMPEGTSStreamRequest},
+		},
+		DefaultOutput: OutputWebsocket,
+		Detect: func(sample []byte, _ bool) bool {
+			return codec.LooksLikeMPEGTS(sample)
+		},
+	})
+
+	streamtype.MustRegister(streamtype.Definition{
+		Type:      consts.StreamH265,
+		WireID:    3,
+		MIMEType:  "video/h265",
+		Ingest:    tcphandler.HandleH265Stream,
+		RawIngest: tcphandler.HandleRawAnnexBStream,
+		Outputs: map[string]streamtype.Output{
+			OutputWebsocket: {Write: httphandler.HandleH265StreamRequest},
+		},
+		DefaultOutput: OutputWebsocket,
+		NewInspector:  func() streamtype.FrameInspector { return &codec.HEVCInspector{} },
+		Detect:        codec.LooksLikeH265,
+		Validate:      codec.ValidateH265,
+		Repair:        codec.RepairH265,
+	})
+}
@@ -0,0 +1,227 @@
+package streamtype
+
+import (
+	"StreamingServer/consts"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// IngestFramer reads the frames of a stream from a publisher and sends them to the output channel
+type IngestFramer func(reader io.Reader, outputChan chan []byte) error
+
+// OutputWriter writes the frames of a stream to an http client.
+// It returns whether the client can take a higher quality and an output that can be reused on the next call.
+type OutputWriter func(streamChan chan []byte, writer http.ResponseWriter, request *http.Request, reusableOutput interface{}) (bool, interface{}, error)
+
+// HeaderWriter sets the http headers that are sent before the first frame
+type HeaderWriter func(writer http.ResponseWriter)
+
+// FrameInspector keeps track of the codec state of a single stream
+type FrameInspector interface {
+	// Inspect looks at a frame that was read from the stream and reports whether it is a keyframe
+	Inspect(frame []byte) bool
+	// Parameters returns the codec parameters that are shown in the stream metadata
+	Parameters() map[string]string
+	// ParameterSets returns the units a decoder needs before it can start at a keyframe
+	ParameterSets() [][]byte
+}
+
+// Output is a way of delivering a stream to http clients
+type Output struct {
+	Write   OutputWriter
+	Headers HeaderWriter
+}
+
+// Definition holds everything the server needs to know about a stream type
+type Definition struct {
+	Type consts.StreamType
+	// WireID is the stream type id publishers send in the tcp handshake
+	WireID int
+	// MIMEType of the frames of the stream
+	MIMEType string
+	Ingest   IngestFramer
+	// RawIngest is optional and reads the stream from a plain byte stream without size prefixes (pipes, stdin)
+	RawIngest IngestFramer
+	// Outputs by name, the name can be chosen by clients with the format query parameter
+	Outputs       map[string]Output
+	DefaultOutput string
+	// NewInspector is optional and creates an inspector for every new stream of this type
+	NewInspector func() FrameInspector
+	// Detect is optional and reports whether a sample from the start of a stream belongs to this type.
+	// wholeFrame is false if the sample may end in the middle of a frame.
+	Detect func(sample []byte, wholeFrame bool) bool
+	// Validate is optional and checks a frame for corruption, fullDecode asks for a decode where the type supports it
+	Validate func(frame []byte, fullDecode bool) error
+	// Repair is optional and tries to turn a corrupt frame into one that decoders accept
+	Repair func(frame []byte) ([]byte, error)
+}
+
+// GetOutput returns the output with the given name or the default output if the name is empty
+func (def Definition) GetOutput(name string) (Output, error) {
+	if name == "" {
+		name = def.DefaultOutput
+	}
+
+	output, ok := def.Outputs[name]
+	if !ok {
+		return Output{}, fmt.Errorf("No output %s for stream type %s", name, def.Type)
+	}
+
+	return output, nil
+}
+
+// AutoWireID is the handshake id of publishers that let the server detect the stream type
+const AutoWireID = -1
+
+var (
+	registryLock sync.RWMutex
+	definitions  = make(map[consts.StreamType]Definition)
+	wireIDs      = make(map[int]consts.StreamType)
+)
+
+// Register adds a stream type to the registry.
+// It is meant to be called from the init function of the package implementing the stream type.
+func Register(def Definition) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if def.Type == "" {
+		return fmt.Errorf("stream type must have a name")
+	}
+
+	if def.Ingest == nil {
+		return fmt.Errorf("stream type %s has no ingest framer", def.Type)
+	}
+
+	if _, exists := definitions[def.Type]; exists {
+		return fmt.Errorf("stream type %s is already registered", def.Type)
+	}
+
+	if def.Type == consts.StreamAuto || def.WireID == AutoWireID {
+		return fmt.Errorf("stream type %s must not use the name or wire id of auto detection", def.Type)
+	}
+
+	if other, exists := wireIDs[def.WireID]; exists {
+		return fmt.Errorf("wire id %d of stream type %s is already used by %s", def.WireID, def.Type, other)
+	}
+
+	if len(def.Outputs) > 0 {
+		if _, err := def.GetOutput(""); err != nil {
+			return err
+		}
+	}
+
+	definitions[def.Type] = def
+	wireIDs[def.WireID] = def.Type
+	return nil
+}
+
+// MustRegister is like Register but panics if the stream type cannot be registered
+func MustRegister(def Definition) {
+	err := Register(def)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the definition of a registered stream type
+func Lookup(streamType consts.StreamType) (Definition, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	def, ok := definitions[streamType]
+	if !ok {
+		return Definition{}, fmt.Errorf("No stream type registered with name %s", streamType)
+	}
+
+	return def, nil
+}
+
+// LookupWireID returns the definition of the stream type with the given handshake id
+func LookupWireID(wireID int) (Definition, error) {
+	registryLock.RLock()
+	streamType, ok := wireIDs[wireID]
+	registryLock.RUnlock()
+	if !ok {
+		return Definition{}, fmt.Errorf("No stream type registered with wire id %d", wireID)
+	}
+
+	return Lookup(streamType)
+}
+
+// IsRegistered reports whether the stream type exists
+func IsRegistered(streamType consts.StreamType) bool {
+	_, err := Lookup(streamType)
+	return err == nil
+}
+
+// Types returns the names of all registered stream types
+func Types() []consts.StreamType {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	var types []consts.StreamType
+	for streamType := range definitions {
+		types = append(types, streamType)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Detect returns the stream type a sample from the start of a stream belongs to
+func Detect(sample []byte, wholeFrame bool) (Definition, error) {
+	var matches []Definition
+	for _, streamType := range Types() {
+		def, err := Lookup(streamType)
+		if err == nil && def.Detect != nil && def.Detect(sample, wholeFrame) {
+			matches = append(matches, def)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Definition{}, fmt.Errorf("Unable to detect the stream type of the first frame")
+	case 1:
+		return matches[0], nil
+	default:
+		return Definition{}, fmt.Errorf("First frame matches the stream types %s and %s", matches[0].Type, matches[1].Type)
+	}
+}
+
+// Confirm checks that a sample from the start of a stream belongs to the declared type.
+// Types without a Detect function are always confirmed.
+func Confirm(def Definition, sample []byte, wholeFrame bool) error {
+	if def.Detect == nil || def.Detect(sample, wholeFrame) {
+		return nil
+	}
+
+	detected, err := Detect(sample, wholeFrame)
+	if err != nil {
+		return fmt.Errorf("Stream declared as %s but the first frame does not look like %s", def.Type, def.Type)
+	}
+
+	return fmt.Errorf("Stream declared as %s but the first frame looks like %s", def.Type, detected.Type)
+}
+
+// Resolve returns the definition of the declared stream type, or detects it if the publisher declared auto
+func Resolve(declared consts.StreamType, sample []byte, wholeFrame bool) (Definition, error) {
+	if declared == consts.StreamAuto {
+		return Detect(sample, wholeFrame)
+	}
+
+	def, err := Lookup(declared)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	err = Confirm(def, sample, wholeFrame)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	return def, nil
+}